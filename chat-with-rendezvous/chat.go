@@ -10,6 +10,8 @@ import (
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
 	"github.com/multiformats/go-multiaddr"
@@ -25,10 +27,18 @@ func main() {
 	ctx := context.Background()
 
 	id := flag.Int("id", 0, "Source port number")
+	port := flag.Int("port", PORT, "listen port, use different ports to run several peers on one machine")
+	discovery := flag.String("discovery", DISCOVERY_DHT, "comma separated discovery modes: dht, mdns")
 	flag.Parse()
 
+	useDHT, useMDNS, err := parseDiscoveryModes(*discovery)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
 	// 构造 Host
-	basicHost, err := makeHost(*id, PORT)
+	basicHost, err := makeHost(*id, *port)
 	if err != nil {
 		log.Println(err)
 		return
@@ -37,15 +47,35 @@ func main() {
 	// 监听协议
 	startPeer(basicHost, handleStream)
 
+	if useMDNS {
+		// 局域网内用 mDNS 发现节点，不依赖 bootstrap peers
+		service, err := startMDNS(ctx, basicHost)
+		if err != nil {
+			fmt.Printf("start mdns failed, err = %v\n", err)
+			return
+		}
+		defer service.Close()
+	}
+
+	if useDHT {
+		if err = discoverDHTPeers(ctx, basicHost); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	select {}
+}
+
+// discoverDHTPeers 通过 DHT 在名空间中公布自己，并与找到的节点聊天
+func discoverDHTPeers(ctx context.Context, basicHost host.Host) error {
 	// 启动 DHT 服务，连接 bootstrap peers
 	kadDHT, err := dht.New(ctx, basicHost, dht.BootstrapPeers(BOOTSTRAP_PEERS...))
 	if err != nil {
-		fmt.Printf("new DHT failed, err =%v\n", err)
-		return
+		return fmt.Errorf("new DHT failed, err =%v", err)
 	}
 	if err = kadDHT.Bootstrap(ctx); err != nil {
-		fmt.Printf("KadDHT bootstrap failed, err =%v\n", err)
-		return
+		return fmt.Errorf("KadDHT bootstrap failed, err =%v", err)
 	}
 	time.Sleep(time.Second * 2)
 
@@ -56,29 +86,36 @@ func main() {
 	// 查找其它的节点
 	peerChan, err := routingDiscovery.FindPeers(ctx, NameSpace)
 	if err != nil {
-		fmt.Printf("FindPeers failed, err =%v\n", err)
-		return
+		return fmt.Errorf("FindPeers failed, err =%v", err)
 	}
-	for peer := range peerChan {
-		if peer.ID == basicHost.ID() {
+	for peerInfo := range peerChan {
+		if peerInfo.ID == basicHost.ID() {
 			continue
 		}
-		fmt.Printf("Found peer: %s \n", peer.ID)
+		fmt.Printf("Found peer: %s \n", peerInfo.ID)
+		chatWith(ctx, basicHost, peerInfo)
+	}
 
-		stream, err := basicHost.NewStream(ctx, peer.ID, NameSpace)
-		if err != nil {
-			fmt.Printf("NewStream failed, err =%v\n", err)
-			continue
-		}
+	return nil
+}
 
-		rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-		go writeData(rw)
-		go readData(rw)
+// chatWith 打开到{peerInfo}的聊天流
+func chatWith(ctx context.Context, basicHost host.Host, peerInfo peer.AddrInfo) {
+	if len(peerInfo.Addrs) > 0 {
+		basicHost.Peerstore().AddAddrs(peerInfo.ID, peerInfo.Addrs, peerstore.TempAddrTTL)
+	}
 
-		fmt.Printf("Connected to: %s\n", peer.ID)
+	stream, err := basicHost.NewStream(ctx, peerInfo.ID, NameSpace)
+	if err != nil {
+		fmt.Printf("NewStream failed, err =%v\n", err)
+		return
 	}
 
-	select {}
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	go writeData(rw)
+	go readData(rw)
+
+	fmt.Printf("Connected to: %s\n", peerInfo.ID)
 }
 
 func makeHost(id int, port int) (host.Host, error) {
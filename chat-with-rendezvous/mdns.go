@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	"strings"
+)
+
+// 节点发现方式，可以同时使用
+const (
+	DISCOVERY_DHT  = "dht"
+	DISCOVERY_MDNS = "mdns"
+)
+
+// mDNS 服务名只能包含字母、数字和连字符，与名空间 NameSpace 对应
+const MdnsServiceTag = "chat-test"
+
+type mdnsNotifee struct {
+	ctx context.Context
+	h   host.Host
+}
+
+// HandlePeerFound 与局域网内发现的节点聊天。
+// 双方会同时发现对方，只由 ID 较小的一方打开聊天流，避免重复的连接。
+func (n *mdnsNotifee) HandlePeerFound(peerInfo peer.AddrInfo) {
+	if peerInfo.ID == n.h.ID() || n.h.ID() > peerInfo.ID {
+		return
+	}
+	fmt.Printf("Found mdns peer: %s \n", peerInfo.ID)
+	chatWith(n.ctx, n.h, peerInfo)
+}
+
+func startMDNS(ctx context.Context, h host.Host) (mdns.Service, error) {
+	service := mdns.NewMdnsService(h, MdnsServiceTag, &mdnsNotifee{ctx: ctx, h: h})
+	return service, service.Start()
+}
+
+// parseDiscoveryModes 解析逗号分隔的节点发现方式
+func parseDiscoveryModes(modes string) (useDHT bool, useMDNS bool, err error) {
+	for _, mode := range strings.Split(modes, ",") {
+		switch strings.TrimSpace(mode) {
+		case DISCOVERY_DHT:
+			useDHT = true
+		case DISCOVERY_MDNS:
+			useMDNS = true
+		case "":
+		default:
+			return false, false, fmt.Errorf("unknown discovery mode `%s`", mode)
+		}
+	}
+	if !useDHT && !useMDNS {
+		return false, false, fmt.Errorf("at least one discovery mode is required")
+	}
+	return useDHT, useMDNS, nil
+}
@@ -1,143 +1,65 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
-	"github.com/gogo/protobuf/proto"
-	"github.com/google/uuid"
-	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/czh0526/libp2p-examples/signedproto"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"io"
 	"log"
 )
 
 const (
 	ECHO_Request  = "/echo/echoreq/0.0.1"
 	ECHO_Response = "/echo/echoresp/0.0.1"
+	// ECHO_Protocol 的应答写回请求所在的流，不需要对方能连进来
+	ECHO_Protocol = "/echo/1.0.0"
 )
 
 type EchoProtocol struct {
-	node     *Node
-	requests map[string]*p2p.EchoRequest
-	done     chan bool
+	node  *Node
+	proto *signedproto.Protocol[*p2p.EchoRequest, *p2p.EchoResponse]
 }
 
-func NewEchoProtocol(node *Node, done chan bool) *EchoProtocol {
-	e := EchoProtocol{
-		node:     node,
-		requests: make(map[string]*p2p.EchoRequest),
-		done:     done,
-	}
-	node.SetStreamHandler(ECHO_Request, e.onEchoRequest)
-	node.SetStreamHandler(ECHO_Response, e.onEchoResponse)
-	return &e
+func NewEchoProtocol(node *Node) *EchoProtocol {
+	e := &EchoProtocol{node: node}
+	e.proto = signedproto.Register(node.Service, signedproto.Spec[*p2p.EchoRequest, *p2p.EchoResponse]{
+		ID:         ECHO_Protocol,
+		RequestID:  ECHO_Request,
+		ResponseID: ECHO_Response,
+		NewRequest: func(data *p2p.MessageData) *p2p.EchoRequest {
+			return &p2p.EchoRequest{MessageData: data}
+		},
+		NewResponse: func(data *p2p.MessageData) *p2p.EchoResponse {
+			return &p2p.EchoResponse{MessageData: data}
+		},
+	}, e.onEcho)
+	return e
 }
 
-func (e *EchoProtocol) onEchoRequest(s network.Stream) {
-	data := &p2p.EchoRequest{}
-	buf, err := io.ReadAll(s)
-	if err != nil {
-		s.Reset()
-		log.Println(err)
-		return
-	}
-	s.Close()
-
-	err = proto.Unmarshal(buf, data)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	fmt.Printf("【echo】Received echo request from %s, Message = %v\n",
-		s.Conn().RemotePeer().String(), data.Message)
-
-	valid := e.node.AuthenticateMessage(data, data.MessageData)
-	if !valid {
-		log.Println("Failed to authenticate message")
-		return
-	}
-
-	fmt.Printf("【echo】Sending echo response to %s, Message = %v\n",
-		s.Conn().RemotePeer().String(), data.Message)
-	// create echo response
-	resp := &p2p.EchoResponse{
-		Message:     data.Message,
-		MessageData: e.node.NewMessageData(data.MessageData.Id, false),
+func (e *EchoProtocol) onEcho(from peer.ID, req *p2p.EchoRequest, resp *p2p.EchoResponse) error {
+	if req.MessageData.Gossip {
+		fmt.Printf("【gossip】Received echo from %s, hops = %d, Message = %v\n",
+			from, req.MessageData.Hops, req.Message)
+		return nil
 	}
-	signature, err := e.node.SignProtoMessage(resp)
-	if err != nil {
-		log.Printf("failed to sign response, err = %v", err)
-		return
-	}
-	resp.MessageData.Sign = signature
-
-	// send echo response
-	ok := e.node.SendProtoMessage(s.Conn().RemotePeer(), ECHO_Response, resp)
-	if !ok {
-		fmt.Printf("【echo】Echo response to %s sent.", s.Conn().RemotePeer())
-	}
-	e.done <- true
+	fmt.Printf("【echo】Received echo request from %s, Message = %v\n", from, req.Message)
+	resp.Message = req.Message
+	return nil
 }
 
-func (e *EchoProtocol) onEchoResponse(s network.Stream) {
-	data := &p2p.EchoResponse{}
-	buf, err := io.ReadAll(s)
-	if err != nil {
-		s.Reset()
-		log.Println(err)
-		return
-	}
-	s.Close()
-
-	err = proto.Unmarshal(buf, data)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	valid := e.node.AuthenticateMessage(data, data.MessageData)
-	if !valid {
-		log.Printf("Failed to authenticate message, err = %v", err)
-		return
-	}
-
-	req, ok := e.requests[data.MessageData.Id]
-	if ok {
-		delete(e.requests, data.MessageData.Id)
-	} else {
-		log.Printf("Failed to find request for id = %v", data.MessageData.Id)
-		return
-	}
-
-	fmt.Printf("【echo】Received echo response from %s, Message = %v\n",
-		s.Conn().RemotePeer().String(), req.Message)
-	e.done <- true
-}
-
-func (e *EchoProtocol) Echo(peerId peer.ID) bool {
+// Echo 向{peerId}发出 echo 请求，结果（包括 RTT）通过返回的 Future 得到
+func (e *EchoProtocol) Echo(ctx context.Context, peerId peer.ID) *signedproto.Future {
 	log.Printf("【echo】 Plan to send echo to: %s", peerId)
 
-	req := &p2p.EchoRequest{
-		MessageData: e.node.NewMessageData(uuid.New().String(), false),
-		Message:     fmt.Sprintf("Echo from %s", e.node.ID()),
-	}
-
-	signature, err := e.node.SignProtoMessage(req)
-	if err != nil {
-		log.Println("failed to sign echo message")
-		return false
-	}
-
-	req.MessageData.Sign = signature
-
-	ok := e.node.SendProtoMessage(peerId, ECHO_Request, req)
-	if !ok {
-		return false
-	}
-
-	e.requests[req.MessageData.Id] = req
-	fmt.Printf("【echo】 Echo to: %s was sent, Message: %s \n", peerId, req.Message)
+	req := e.proto.NewRequest()
+	req.Message = fmt.Sprintf("Echo from %s", e.node.ID())
+	return e.proto.Request(ctx, peerId, req)
+}
 
-	return true
+// Gossip 把 echo 消息洪泛给所有节点，收到的节点不回应答
+func (e *EchoProtocol) Gossip(ctx context.Context, message string) error {
+	req := e.proto.NewRequest()
+	req.Message = message
+	return e.proto.Gossip(ctx, req)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"github.com/czh0526/libp2p-examples/signedproto"
 	"github.com/czh0526/libp2p-examples/utils"
 	"github.com/libp2p/go-libp2p"
 	kaddht "github.com/libp2p/go-libp2p-kad-dht"
@@ -19,19 +20,22 @@ var PEERS = []string{
 
 func main() {
 	id := flag.Int("id", 0, "peer number to start")
+	window := flag.Duration("window", signedproto.DefaultAcceptanceWindow, "max clock skew of accepted messages")
+	seen := flag.Int("seen", signedproto.DefaultSeenCacheSize, "number of message ids remembered per peer")
+	hops := flag.Uint("hops", signedproto.DefaultGossipHops, "max times a gossip message is forwarded")
 	flag.Parse()
 
 	if *id < 1 {
 		panic("id should be greater than 0")
 	}
 
-	done := make(chan bool, 1)
-	host := makeNode(*id, PORT, done)
+	host := makeNode(*id, PORT, signedproto.NewMessageGuard(*window, *seen))
+	host.SetGossipHops(uint32(*hops))
 
 	host.run()
 }
 
-func makeNode(id int, port int, done chan bool) *Node {
+func makeNode(id int, port int, guard *signedproto.MessageGuard) *Node {
 	ctx := context.Background()
 
 	// 读取固定的私钥文件
@@ -67,5 +71,5 @@ func makeNode(id int, port int, done chan bool) *Node {
 		panic(fmt.Sprintf("connect bootstrap peers failed, err = %v", err))
 	}
 
-	return NewNode(routedHost, done)
+	return NewNode(routedHost, guard)
 }
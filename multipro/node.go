@@ -3,37 +3,35 @@ package main
 import (
 	"context"
 	"fmt"
-	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
-	ggio "github.com/gogo/protobuf/io"
-	"github.com/gogo/protobuf/proto"
-	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/czh0526/libp2p-examples/signedproto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/libp2p/go-libp2p/core/protocol"
-	"log"
 	"time"
 )
 
-const (
-	clientVersion = "go-p2p-node/0.0.1"
-)
-
 type Node struct {
 	host.Host
+	*signedproto.Service
 	*PingProtocol
 	*EchoProtocol
 }
 
-func NewNode(host host.Host, done chan bool) *Node {
-	node := &Node{Host: host}
-	node.PingProtocol = NewPingProtocol(node, done)
-	node.EchoProtocol = NewEchoProtocol(node, done)
+func NewNode(host host.Host, guard *signedproto.MessageGuard) *Node {
+	node := &Node{Host: host, Service: signedproto.NewService(host, guard)}
+	node.PingProtocol = NewPingProtocol(node)
+	node.EchoProtocol = NewEchoProtocol(node)
 	return node
 }
 
 func (n *Node) run() {
 	myId := n.ID()
 	for {
+		ctx, cancel := context.WithTimeout(context.Background(), signedproto.DefaultRequestTimeout)
+		if err := n.EchoProtocol.Gossip(ctx, fmt.Sprintf("Gossip from %s", myId)); err != nil {
+			fmt.Printf("【gossip】send gossip failed, err = %v\n", err)
+		}
+		cancel()
+
 		for _, pid := range PEERS {
 			peerId, err := peer.Decode(pid)
 			if err != nil {
@@ -43,106 +41,24 @@ func (n *Node) run() {
 				continue
 			}
 
-			if !n.Ping(peerId) {
-				fmt.Printf("【ping】`%s` is down\n", peerId)
+			ctx, cancel := context.WithTimeout(context.Background(), signedproto.DefaultRequestTimeout)
+			ping := n.Ping(ctx, peerId)
+			echo := n.Echo(ctx, peerId)
+			if res, err := ping.Wait(); err != nil {
+				fmt.Printf("【ping】`%s` is down, err = %v\n", peerId, err)
+			} else {
+				fmt.Printf("【ping】`%s` is up, rtt = %v\n", peerId, res.RTT)
 			}
-
-			if !n.Echo(peerId) {
-				fmt.Printf("【echo】`%s` is down\n", peerId)
+			if res, err := echo.Wait(); err != nil {
+				fmt.Printf("【echo】`%s` is down, err = %v\n", peerId, err)
+			} else {
+				fmt.Printf("【echo】`%s` is up, rtt = %v\n", peerId, res.RTT)
+			}
+			cancel()
+			if rejected := n.Guard().String(); len(rejected) > 0 {
+				fmt.Printf("rejected messages: %s\n", rejected)
 			}
 			time.Sleep(10 * time.Second)
 		}
 	}
 }
-
-func (n *Node) NewMessageData(messageId string, gossip bool) *p2p.MessageData {
-	nodePubKey, err := crypto.MarshalPublicKey(n.Peerstore().PubKey(n.ID()))
-	if err != nil {
-		panic("Failed to marshal public key for sender from local peer store.")
-	}
-
-	return &p2p.MessageData{
-		ClientVersion: clientVersion,
-		NodeId:        n.ID().String(),
-		NodePubKey:    nodePubKey,
-		Timestamp:     time.Now().Unix(),
-		Id:            messageId,
-		Gossip:        gossip,
-	}
-}
-
-func (n *Node) SignProtoMessage(message proto.Message) ([]byte, error) {
-	data, err := proto.Marshal(message)
-	if err != nil {
-		return nil, err
-	}
-	return n.signData(data)
-}
-
-func (n *Node) signData(data []byte) ([]byte, error) {
-	key := n.Peerstore().PrivKey(n.ID())
-	return key.Sign(data)
-}
-
-func (n *Node) AuthenticateMessage(message proto.Message, data *p2p.MessageData) bool {
-	sign := data.Sign
-	data.Sign = nil
-	bin, err := proto.Marshal(message)
-	if err != nil {
-		log.Printf("Failed to marshal message: %v", err)
-		return false
-	}
-	data.Sign = sign
-
-	peerId, err := peer.Decode(data.NodeId)
-	if err != nil {
-		log.Printf("Failed to decode peer id from base58: %v", err)
-		return false
-	}
-
-	return n.verifyData(bin, sign, peerId, data.NodePubKey)
-}
-
-func (n *Node) verifyData(data []byte, signature []byte, peerId peer.ID, pubKeyData []byte) bool {
-	key, err := crypto.UnmarshalPublicKey(pubKeyData)
-	if err != nil {
-		log.Println("Failed to unmarshal public key")
-		return false
-	}
-
-	idFromKey, err := peer.IDFromPublicKey(key)
-	if err != nil {
-		log.Println("Failed to extract peer id from public key")
-		return false
-	}
-
-	if idFromKey != peerId {
-		log.Println("Invalid peer id")
-		return false
-	}
-
-	res, err := key.Verify(data, signature)
-	if err != nil {
-		log.Println("Failed to verify signature")
-		return false
-	}
-	return res
-}
-
-func (n *Node) SendProtoMessage(id peer.ID, p protocol.ID, data proto.Message) bool {
-	s, err := n.NewStream(context.Background(), id, p)
-	if err != nil {
-		log.Println(err)
-		return false
-	}
-	defer s.Close()
-
-	writer := ggio.NewFullWriter(s)
-	err = writer.WriteMsg(data)
-	if err != nil {
-		log.Println(err)
-		s.Reset()
-		return false
-	}
-	return true
-}
@@ -1,145 +1,51 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
-	"github.com/gogo/protobuf/proto"
-	"github.com/google/uuid"
-	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/czh0526/libp2p-examples/signedproto"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"io"
-	"log"
-	"sync"
 )
 
 const PING_Request = "/ping/pingreq/0.0.1"
 const PING_Response = "/ping/pingresp/0.0.1"
 
+// PING_Protocol 的应答写回请求所在的流，不需要对方能连进来
+const PING_Protocol = "/ping/1.0.0"
+
 type PingProtocol struct {
-	node     *Node
-	mu       sync.Mutex
-	requests map[string]*p2p.PingRequest
-	done     chan bool
+	node  *Node
+	proto *signedproto.Protocol[*p2p.PingRequest, *p2p.PingResponse]
 }
 
-func NewPingProtocol(node *Node, done chan bool) *PingProtocol {
-	p := &PingProtocol{node: node, requests: make(map[string]*p2p.PingRequest), done: done}
-	node.SetStreamHandler(PING_Request, p.onPingRequest)
-	node.SetStreamHandler(PING_Response, p.onPingResponse)
+func NewPingProtocol(node *Node) *PingProtocol {
+	p := &PingProtocol{node: node}
+	p.proto = signedproto.Register(node.Service, signedproto.Spec[*p2p.PingRequest, *p2p.PingResponse]{
+		ID:         PING_Protocol,
+		RequestID:  PING_Request,
+		ResponseID: PING_Response,
+		NewRequest: func(data *p2p.MessageData) *p2p.PingRequest {
+			return &p2p.PingRequest{MessageData: data}
+		},
+		NewResponse: func(data *p2p.MessageData) *p2p.PingResponse {
+			return &p2p.PingResponse{MessageData: data}
+		},
+	}, p.onPing)
 	return p
 }
 
-func (p *PingProtocol) onPingRequest(s network.Stream) {
-	data := &p2p.PingRequest{}
-	buf, err := io.ReadAll(s)
-	if err != nil {
-		s.Reset()
-		log.Printf("Read ping request failed, err = %v", err)
-		return
-	}
-	s.Close()
-
-	err = proto.Unmarshal(buf, data)
-	if err != nil {
-		log.Printf("Unmarshal ping request failed, err = %v", err)
-		return
-	}
-
-	fmt.Printf("【ping】 Received ping request from %s, Message = %v \n",
-		s.Conn().RemotePeer(), data.Message)
-
-	valid := p.node.AuthenticateMessage(data, data.MessageData)
-	if !valid {
-		log.Println("Authentication failed")
-		return
-	}
-
-	fmt.Printf("【ping】 Sending ping response to %s. Message = %s \n",
-		s.Conn().RemotePeer(), data.Message)
-
-	resp := &p2p.PingResponse{
-		MessageData: p.node.NewMessageData(data.MessageData.Id, false),
-		Message:     fmt.Sprintf("Ping response from %s", p.node.ID()),
-	}
-
-	signature, err := p.node.SignProtoMessage(resp)
-	if err != nil {
-		log.Printf("Sign response failed: err = %v", err)
-		return
-	}
-	resp.MessageData.Sign = signature
-	ok := p.node.SendProtoMessage(s.Conn().RemotePeer(), PING_Response, resp)
-	if ok {
-		fmt.Printf("【ping】 Ping response to %s sent.\n", s.Conn().RemotePeer())
-	}
-	p.done <- true
+func (p *PingProtocol) onPing(from peer.ID, req *p2p.PingRequest, resp *p2p.PingResponse) error {
+	fmt.Printf("【ping】 Received ping request from %s, Message = %v \n", from, req.Message)
+	resp.Message = fmt.Sprintf("Ping response from %s", p.node.ID())
+	return nil
 }
 
-func (p *PingProtocol) onPingResponse(s network.Stream) {
-	data := &p2p.PingResponse{}
-	buf, err := io.ReadAll(s)
-	if err != nil {
-		s.Reset()
-		log.Printf("Read ping response failed, err = %v", err)
-		return
-	}
-	s.Close()
-
-	err = proto.Unmarshal(buf, data)
-	if err != nil {
-		log.Printf("Unmarshal ping response failed, err = %v", err)
-	}
-
-	fmt.Printf("【ping】 Received ping response from %s, Message = %v \n",
-		s.Conn().RemotePeer(), data.Message)
-
-	valid := p.node.AuthenticateMessage(data, data.MessageData)
-	if !valid {
-		log.Println("Failed to authenticate message")
-		return
-	}
-
-	p.mu.Lock()
-	_, ok := p.requests[data.MessageData.Id]
-	if ok {
-		delete(p.requests, data.MessageData.Id)
-	} else {
-		log.Println("Failed to find request data object for response")
-		p.mu.Lock()
-		return
-	}
-	p.mu.Unlock()
-
-	fmt.Printf("【ping】 Received ping response from %s ok \n", s.Conn().RemotePeer())
-	p.done <- true
-}
-
-func (p *PingProtocol) Ping(peerId peer.ID) bool {
+// Ping 向{peerId}发出 ping 请求，结果（包括 RTT）通过返回的 Future 得到
+func (p *PingProtocol) Ping(ctx context.Context, peerId peer.ID) *signedproto.Future {
 	fmt.Printf("【ping】Plan to send ping to: %s \n", peerId)
 
-	req := &p2p.PingRequest{
-		MessageData: p.node.NewMessageData(uuid.New().String(), false),
-		Message:     fmt.Sprintf("Ping from %s", p.node.ID()),
-	}
-
-	signature, err := p.node.SignProtoMessage(req)
-	if err != nil {
-		log.Printf("%s: sign ping data failed: err = %v", p.node.ID(), err)
-		return false
-	}
-
-	req.MessageData.Sign = signature
-
-	p.mu.Lock()
-	p.requests[req.MessageData.Id] = req
-	p.mu.Unlock()
-
-	ok := p.node.SendProtoMessage(peerId, PING_Request, req)
-	if !ok {
-		return false
-	}
-
-	fmt.Printf("【ping】 Ping to: %s was sent， Message = %s \n",
-		peerId, req.Message)
-	return true
+	req := p.proto.NewRequest()
+	req.Message = fmt.Sprintf("Ping from %s", p.node.ID())
+	return p.proto.Request(ctx, peerId, req)
 }
@@ -30,6 +30,7 @@ type MessageData struct {
 	NodeId               string   `protobuf:"bytes,5,opt,name=nodeId,proto3" json:"nodeId,omitempty"`
 	NodePubKey           []byte   `protobuf:"bytes,6,opt,name=nodePubKey,proto3" json:"nodePubKey,omitempty"`
 	Sign                 []byte   `protobuf:"bytes,7,opt,name=sign,proto3" json:"sign,omitempty"`
+	Hops                 uint32   `protobuf:"varint,8,opt,name=hops,proto3" json:"hops,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -117,6 +118,13 @@ func (m *MessageData) GetSign() []byte {
 	return nil
 }
 
+func (m *MessageData) GetHops() uint32 {
+	if m != nil {
+		return m.Hops
+	}
+	return 0
+}
+
 type PingRequest struct {
 	MessageData          *MessageData `protobuf:"bytes,1,opt,name=messageData,proto3" json:"messageData,omitempty"`
 	Message              string       `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
@@ -348,25 +356,25 @@ func init() {
 func init() { proto.RegisterFile("proto/p2p.proto", fileDescriptor_62251327f5b05f87) }
 
 var fileDescriptor_62251327f5b05f87 = []byte{
-	// 279 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x92, 0xcd, 0x4a, 0xc4, 0x30,
-	0x14, 0x85, 0x49, 0x3b, 0x76, 0xec, 0x4d, 0xfd, 0x21, 0x0b, 0xc9, 0x42, 0x4a, 0x29, 0x2e, 0xba,
-	0x1a, 0xa1, 0xbe, 0x81, 0xe8, 0x42, 0x44, 0x18, 0xb2, 0x70, 0x33, 0x6e, 0x3a, 0xd3, 0x50, 0x03,
-	0x36, 0x89, 0x73, 0x33, 0x0b, 0x1f, 0x50, 0x70, 0xe9, 0x23, 0x48, 0x9f, 0x44, 0x9a, 0xa9, 0x4c,
-	0xdd, 0xcf, 0xec, 0xce, 0x39, 0xb9, 0x39, 0xdc, 0x0f, 0x2e, 0x9c, 0xd9, 0xb5, 0x71, 0xe6, 0xda,
-	0x96, 0x76, 0xe6, 0x15, 0x0b, 0x6d, 0x69, 0xf3, 0x4f, 0x02, 0xf4, 0x49, 0x22, 0x56, 0x8d, 0xbc,
-	0xab, 0x5c, 0xc5, 0xae, 0xe0, 0x64, 0xf5, 0xa6, 0xa4, 0x76, 0xcf, 0x72, 0x8d, 0xca, 0x68, 0x4e,
-	0x32, 0x52, 0xc4, 0xe2, 0x7f, 0xc8, 0x2e, 0x21, 0x76, 0xaa, 0x95, 0xe8, 0xaa, 0xd6, 0xf2, 0x20,
-	0x23, 0x45, 0x28, 0x76, 0x01, 0x3b, 0x85, 0x40, 0xd5, 0x3c, 0xf4, 0x1f, 0x03, 0x55, 0xb3, 0x0b,
-	0x88, 0x1a, 0x83, 0xa8, 0x2c, 0x9f, 0x64, 0xa4, 0x38, 0x16, 0x83, 0xeb, 0x73, 0x6d, 0x6a, 0xf9,
-	0x50, 0xf3, 0x23, 0x3f, 0x3b, 0x38, 0x96, 0x02, 0xf4, 0x6a, 0xbe, 0x59, 0x3e, 0xca, 0x0f, 0x1e,
-	0x65, 0xa4, 0x48, 0xc4, 0x28, 0x61, 0x0c, 0x26, 0xa8, 0x1a, 0xcd, 0xa7, 0xfe, 0xc5, 0xeb, 0x7c,
-	0x01, 0x74, 0xae, 0x74, 0x23, 0xe4, 0xfb, 0x46, 0xa2, 0x63, 0x25, 0xd0, 0x76, 0x47, 0xe5, 0x21,
-	0x68, 0x79, 0x3e, 0xeb, 0xe1, 0x47, 0xb4, 0x62, 0x3c, 0xc4, 0x38, 0x4c, 0x07, 0xeb, 0x91, 0x62,
-	0xf1, 0x67, 0xf3, 0x17, 0x48, 0xb6, 0xe5, 0x68, 0x8d, 0x46, 0xb9, 0xe7, 0xf6, 0x05, 0xd0, 0xfb,
-	0xd5, 0xab, 0x39, 0xd8, 0xea, 0xdb, 0xf2, 0x43, 0xac, 0x7e, 0x9b, 0x7c, 0x75, 0x29, 0xf9, 0xee,
-	0x52, 0xf2, 0xd3, 0xa5, 0x64, 0x19, 0xf9, 0xbb, 0xba, 0xf9, 0x0d, 0x00, 0x00, 0xff, 0xff, 0x61,
-	0x5f, 0x6a, 0x0d, 0x6a, 0x02, 0x00, 0x00,
+	// 287 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x92, 0x31, 0x4f, 0xc3, 0x30,
+	0x10, 0x85, 0xe5, 0xa6, 0xa4, 0xcd, 0x25, 0x05, 0xe4, 0x01, 0x79, 0x40, 0x51, 0x14, 0x31, 0x78,
+	0x2a, 0x52, 0xf8, 0x07, 0x08, 0x06, 0x84, 0x90, 0x2a, 0x0f, 0x2c, 0x65, 0x49, 0x1b, 0x2b, 0xb5,
+	0x44, 0x6c, 0xd3, 0x73, 0x07, 0xfe, 0x21, 0x23, 0x2b, 0x1b, 0xca, 0x2f, 0x41, 0x71, 0x83, 0x1a,
+	0x76, 0xba, 0xbd, 0xf7, 0xe5, 0xf2, 0x74, 0xef, 0x64, 0x38, 0xb3, 0x5b, 0xe3, 0xcc, 0xb5, 0x2d,
+	0xec, 0xdc, 0x2b, 0x1a, 0xd8, 0xc2, 0xe6, 0x5f, 0x04, 0xe2, 0x27, 0x89, 0x58, 0xd6, 0xf2, 0xae,
+	0x74, 0x25, 0xbd, 0x82, 0xd9, 0xfa, 0x55, 0x49, 0xed, 0x9e, 0xe5, 0x16, 0x95, 0xd1, 0x8c, 0x64,
+	0x84, 0x47, 0xe2, 0x2f, 0xa4, 0x97, 0x10, 0x39, 0xd5, 0x48, 0x74, 0x65, 0x63, 0xd9, 0x28, 0x23,
+	0x3c, 0x10, 0x07, 0x40, 0x4f, 0x61, 0xa4, 0x2a, 0x16, 0xf8, 0x1f, 0x47, 0xaa, 0xa2, 0x17, 0x10,
+	0xd6, 0x06, 0x51, 0x59, 0x36, 0xce, 0x08, 0x9f, 0x8a, 0xde, 0x75, 0x5c, 0x9b, 0x4a, 0x3e, 0x54,
+	0xec, 0xc4, 0xcf, 0xf6, 0x8e, 0xa6, 0x00, 0x9d, 0x5a, 0xec, 0x56, 0x8f, 0xf2, 0x9d, 0x85, 0x19,
+	0xe1, 0x89, 0x18, 0x10, 0x4a, 0x61, 0x8c, 0xaa, 0xd6, 0x6c, 0xe2, 0xbf, 0x78, 0xdd, 0xb1, 0x8d,
+	0xb1, 0xc8, 0xa6, 0x19, 0xe1, 0x33, 0xe1, 0x75, 0xbe, 0x84, 0x78, 0xa1, 0x74, 0x2d, 0xe4, 0xdb,
+	0x4e, 0xa2, 0xa3, 0x05, 0xc4, 0xcd, 0xa1, 0xa9, 0x2f, 0x16, 0x17, 0xe7, 0xf3, 0xee, 0x20, 0x83,
+	0x0b, 0x88, 0xe1, 0x10, 0x65, 0x30, 0xe9, 0xad, 0xaf, 0x19, 0x89, 0x5f, 0x9b, 0xbf, 0x40, 0xb2,
+	0x0f, 0x47, 0x6b, 0x34, 0xca, 0x7f, 0x4e, 0x5f, 0x42, 0x7c, 0xbf, 0xde, 0x98, 0xa3, 0xad, 0xbe,
+	0x0f, 0x3f, 0xc6, 0xea, 0xb7, 0xc9, 0x47, 0x9b, 0x92, 0xcf, 0x36, 0x25, 0xdf, 0x6d, 0x4a, 0x56,
+	0xa1, 0x7f, 0x6b, 0x37, 0x3f, 0x03, 0x00, 0x5d, 0xae, 0x10, 0xa8, 0x7e, 0x02, 0x00, 0x00,
 }
 
 func (m *MessageData) Marshal() (dAtA []byte, err error) {
@@ -393,6 +401,11 @@ func (m *MessageData) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 		i -= len(m.XXX_unrecognized)
 		copy(dAtA[i:], m.XXX_unrecognized)
 	}
+	if m.Hops != 0 {
+		i = encodeVarintP2P(dAtA, i, uint64(m.Hops))
+		i--
+		dAtA[i] = 0x40
+	}
 	if len(m.Sign) > 0 {
 		i -= len(m.Sign)
 		copy(dAtA[i:], m.Sign)
@@ -673,6 +686,9 @@ func (m *MessageData) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovP2P(uint64(l))
 	}
+	if m.Hops != 0 {
+		n += 1 + sovP2P(uint64(m.Hops))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -997,6 +1013,25 @@ func (m *MessageData) Unmarshal(dAtA []byte) error {
 				m.Sign = []byte{}
 			}
 			iNdEx = postIndex
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Hops", wireType)
+			}
+			m.Hops = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowP2P
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Hops |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipP2P(dAtA[iNdEx:])
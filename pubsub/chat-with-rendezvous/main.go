@@ -10,19 +10,23 @@ import (
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
-	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
-	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
 	"os"
+	"strings"
 	"sync"
-	"time"
 )
 
 var (
 	topicNameFlag = flag.String("topicName", "applesauce", "name of the topic to join")
+	peersFlag     = flag.Int("peers", DefaultTargetPeers, "number of peers to keep connected")
+	discoveryFlag = flag.String("discovery", DISCOVERY_DHT, "comma separated discovery modes: dht, mdns")
 )
 
 func main() {
 	flag.Parse()
+	useDHT, useMDNS, err := parseDiscoveryModes(*discoveryFlag)
+	if err != nil {
+		panic(err)
+	}
 
 	// 创建本地主机
 	ctx := context.Background()
@@ -32,7 +36,18 @@ func main() {
 	}
 
 	// 启动节点发现模块
-	go discoverPeers(ctx, h)
+	var kadDHT *dht.IpfsDHT
+	if useDHT {
+		kadDHT = initDHT(ctx, h)
+	}
+	discovery := NewDiscoveryService(ctx, h, kadDHT, []string{*topicNameFlag}, *peersFlag)
+	go discovery.Run()
+	if useMDNS {
+		if err = discovery.StartMDNS(); err != nil {
+			panic(fmt.Sprintf("start mdns failed, err = %v", err))
+		}
+		defer discovery.Close()
+	}
 
 	ps, err := pubsub.NewGossipSub(ctx, h)
 	if err != nil {
@@ -42,7 +57,7 @@ func main() {
 	if err != nil {
 		panic(fmt.Sprintf("join topic failed, err = %v", err))
 	}
-	go streamConsoleTo(ctx, topic)
+	go streamConsoleTo(ctx, topic, discovery)
 
 	sub, err := topic.Subscribe()
 	if err != nil {
@@ -51,35 +66,6 @@ func main() {
 	printMessagesFrom(ctx, sub)
 }
 
-func discoverPeers(ctx context.Context, h host.Host) {
-	kadDHT := initDHT(ctx, h)
-	routingDiscovery := drouting.NewRoutingDiscovery(kadDHT)
-	dutil.Advertise(ctx, routingDiscovery, *topicNameFlag)
-
-	anyConnected := false
-	for !anyConnected {
-		fmt.Println("searching for peers...")
-		peerChan, err := routingDiscovery.FindPeers(ctx, *topicNameFlag)
-		if err != nil {
-			panic(err)
-		}
-		for p := range peerChan {
-			if p.ID == h.ID() {
-				continue
-			}
-			err := h.Connect(ctx, p)
-			if err != nil {
-				fmt.Printf("Failed connecting to peer(`%s`), err = %v\n", p.ID, err)
-			} else {
-				fmt.Printf("Connected to peer(`%s`)\n", p.ID)
-				anyConnected = true
-			}
-		}
-		time.Sleep(10 * time.Second)
-	}
-	fmt.Println("Peer discovery complete")
-}
-
 func initDHT(ctx context.Context, h host.Host) *dht.IpfsDHT {
 	kadDHT, err := dht.New(ctx, h)
 	if err != nil {
@@ -111,13 +97,18 @@ func initDHT(ctx context.Context, h host.Host) *dht.IpfsDHT {
 	return kadDHT
 }
 
-func streamConsoleTo(ctx context.Context, topic *pubsub.Topic) {
+func streamConsoleTo(ctx context.Context, topic *pubsub.Topic, discovery *DiscoveryService) {
 	reader := bufio.NewReader(os.Stdin)
 	for {
 		s, err := reader.ReadString('\n')
 		if err != nil {
 			panic(fmt.Sprintf("read input failed, err = %v \n", err))
 		}
+		// 输入 /peers 查看节点发现的状态
+		if strings.TrimSpace(s) == "/peers" {
+			fmt.Println(discovery.State())
+			continue
+		}
 		if err := topic.Publish(ctx, []byte(s)); err != nil {
 			fmt.Printf("### Publish error: %v \n", err)
 		}
@@ -1,11 +1,14 @@
 package account
 
 import (
+	"errors"
 	"fmt"
 	db2 "github.com/czh0526/libp2p-examples/pubsub/my-chat/db"
 	"github.com/czh0526/libp2p-examples/pubsub/my-chat/db/model"
 	libp2p_crypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"gorm.io/gorm"
+	"os"
 )
 
 type Account struct {
@@ -54,16 +57,10 @@ func LoadAccounts() (map[string]*Account, error) {
 }
 
 func NewAccount(nickname string, phone string, passphrase string) ([]byte, string, error) {
-	accounts, err := LoadAccounts()
-	if err != nil {
+	if err := checkNickname(nickname); err != nil {
 		return nil, "", err
 	}
 
-	_, exists := accounts[nickname]
-	if exists {
-		return nil, "", fmt.Errorf("nickname %s already exists", nickname)
-	}
-
 	privateKeyDer, id, err := generatePrivateKeyFile(passphrase)
 	if err != nil {
 		return nil, "", err
@@ -105,6 +102,112 @@ func GetPrivateKey(accountId string, passphrase string) (libp2p_crypto.PrivKey,
 	return loadPrivateKey(accountId, passphrase)
 }
 
+// ImportAccount 使用已有的 DER 格式 ECDSA 私钥创建账户
+func ImportAccount(nickname string, phone string, passphrase string, privateKeyDer []byte) (string, error) {
+	if err := checkNickname(nickname); err != nil {
+		return "", err
+	}
+
+	privateKey, err := libp2p_crypto.UnmarshalECDSAPrivateKey(privateKeyDer)
+	if err != nil {
+		return "", fmt.Errorf("unmarshal priv key failed, err = %v", err)
+	}
+	pid, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("generate id failed, err = %v", err)
+	}
+	id := pid.String()
+
+	accounts, err := LoadAccounts()
+	if err != nil {
+		return "", err
+	}
+	if existing, ok := accounts[id]; ok {
+		return "", fmt.Errorf("key already belongs to account `%s`", existing.Nickname)
+	}
+
+	if err = writePrivateKeyFile(id, privateKeyDer, passphrase); err != nil {
+		return "", err
+	}
+
+	accountModel := (&Account{
+		Id:       id,
+		Phone:    phone,
+		Nickname: nickname,
+	}).SaveToModel()
+	if err = createAccount(accountModel); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// ExportPrivateKey 解密账户的私钥，返回 DER 格式
+func ExportPrivateKey(accountId string, passphrase string) ([]byte, error) {
+	return loadPrivateKeyDer(accountId, passphrase)
+}
+
+// ChangePassphrase 用新口令重新加密账户的私钥
+func ChangePassphrase(accountId string, oldPassphrase string, newPassphrase string) error {
+	privateKeyDer, err := loadPrivateKeyDer(accountId, oldPassphrase)
+	if err != nil {
+		return err
+	}
+
+	return writePrivateKeyFile(accountId, privateKeyDer, newPassphrase)
+}
+
+// DeleteAccount 删除账户、私钥文件，以及账户拥有的好友、群和聊天记录。
+// 需要提供正确的口令。
+func DeleteAccount(accountId string, passphrase string) error {
+	if _, err := loadPrivateKeyDer(accountId, passphrase); err != nil {
+		return err
+	}
+
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	ownedModels := []interface{}{
+		&model.Friend{}, &model.FriendRequest{}, &model.Group{}, &model.GroupMember{}, &model.Message{},
+		&model.PeerAddr{},
+	}
+	for _, ownedModel := range ownedModels {
+		if err = tx.Where("owner = ?", accountId).Delete(ownedModel).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Where("id = ?", accountId).Delete(&model.Account{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
+
+	privateKeyFilename, err := getPrivateKeyFile(accountId)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(privateKeyFilename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove private key file failed, err = %v", err)
+	}
+
+	return nil
+}
+
+func checkNickname(nickname string) error {
+	_, err := GetAccount(nickname)
+	if err == nil {
+		return fmt.Errorf("nickname %s already exists", nickname)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
 func createAccount(accountModel *model.Account) error {
 	db, err := db2.GetDB()
 	if err != nil {
@@ -1,10 +1,27 @@
 package account
 
 import (
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/config"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
 )
 
+// TestMain 让测试使用临时的主目录，不影响真实的数据
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "my-chat-account-test")
+	if err != nil {
+		panic(err)
+	}
+	if err = config.Init(&config.Options{HomeDir: home}); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(home)
+	os.Exit(code)
+}
+
 func TestCreateAccount(t *testing.T) {
 	_, _, err := NewAccount("蔡志宏", "13520746670", "123456")
 	assert.NoError(t, err)
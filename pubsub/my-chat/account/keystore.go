@@ -0,0 +1,152 @@
+package account
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"strconv"
+)
+
+const (
+	// 未加密的私钥
+	PEM_TYPE_PLAIN = "EC PRIVATE KEY"
+	// 旧版本使用 AES-CBC 加密的私钥，只用于迁移
+	PEM_TYPE_LEGACY = "ENCRYPTED EC PRIVATE KEY"
+	// 新版本的 keystore
+	PEM_TYPE_KEYSTORE = "MY-CHAT ENCRYPTED PRIVATE KEY"
+)
+
+const (
+	KeystoreVersion = 1
+
+	keystoreKDF    = "scrypt"
+	keystoreCipher = "aes-256-gcm"
+
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+	saltLen      = 16
+
+	// 文件中 KDF 参数的上限，防止篡改过的文件让解密耗尽内存和 CPU
+	maxScryptN = 1 << 20
+	maxScryptR = 8
+	maxScryptP = 1
+)
+
+var (
+	ErrWrongPassphrase   = errors.New("wrong passphrase")
+	ErrMalformedKeystore = errors.New("malformed keystore")
+)
+
+// encryptKeystore 使用 scrypt 从口令派生密钥，再用 AES-GCM 加密 DER 格式的私钥。
+// KDF 参数、盐和 nonce 记录在 PEM 头中，版本号参与认证。
+func encryptKeystore(derBytes, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{
+		"Version": strconv.Itoa(KeystoreVersion),
+		"KDF":     keystoreKDF,
+		"N":       strconv.Itoa(scryptN),
+		"R":       strconv.Itoa(scryptR),
+		"P":       strconv.Itoa(scryptP),
+		"Salt":    hex.EncodeToString(salt),
+		"Cipher":  keystoreCipher,
+		"Nonce":   hex.EncodeToString(nonce),
+	}
+	ciphertext := gcm.Seal(nil, nonce, derBytes, additionalData(headers))
+
+	return pem.EncodeToMemory(
+		&pem.Block{
+			Type:    PEM_TYPE_KEYSTORE,
+			Headers: headers,
+			Bytes:   ciphertext,
+		},
+	), nil
+}
+
+// decryptKeystore 解密新版本的 keystore，口令错误时返回 ErrWrongPassphrase
+func decryptKeystore(block *pem.Block, passphrase []byte) ([]byte, error) {
+	headers := block.Headers
+	if headers["Version"] != strconv.Itoa(KeystoreVersion) {
+		return nil, fmt.Errorf("%w: unsupported version `%s`", ErrMalformedKeystore, headers["Version"])
+	}
+	if headers["KDF"] != keystoreKDF || headers["Cipher"] != keystoreCipher {
+		return nil, fmt.Errorf("%w: unsupported kdf `%s` or cipher `%s`",
+			ErrMalformedKeystore, headers["KDF"], headers["Cipher"])
+	}
+
+	n, errN := strconv.Atoi(headers["N"])
+	r, errR := strconv.Atoi(headers["R"])
+	p, errP := strconv.Atoi(headers["P"])
+	salt, errSalt := hex.DecodeString(headers["Salt"])
+	nonce, errNonce := hex.DecodeString(headers["Nonce"])
+	if err := errors.Join(errN, errR, errP, errSalt, errNonce); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedKeystore, err)
+	}
+	if n > maxScryptN || r > maxScryptR || p > maxScryptP {
+		return nil, fmt.Errorf("%w: kdf params N=%d, R=%d, P=%d too large", ErrMalformedKeystore, n, r, p)
+	}
+	if len(salt) != saltLen {
+		return nil, fmt.Errorf("%w: bad salt size %d", ErrMalformedKeystore, len(salt))
+	}
+
+	key, err := scrypt.Key(passphrase, salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedKeystore, err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: bad nonce size %d", ErrMalformedKeystore, len(nonce))
+	}
+
+	// GCM 认证失败说明口令错误（或文件被篡改）
+	derBytes, err := gcm.Open(nil, nonce, block.Bytes, additionalData(headers))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return derBytes, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData 把 PEM 头中的参数纳入认证范围，防止被降级或替换
+func additionalData(headers map[string]string) []byte {
+	return []byte(fmt.Sprintf("%s|v=%s|kdf=%s|n=%s|r=%s|p=%s|salt=%s|cipher=%s",
+		PEM_TYPE_KEYSTORE, headers["Version"], headers["KDF"],
+		headers["N"], headers["R"], headers["P"], headers["Salt"], headers["Cipher"]))
+}
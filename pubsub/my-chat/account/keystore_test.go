@@ -0,0 +1,144 @@
+package account
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// encryptLegacyPem 按照旧版本的格式加密私钥，用于测试迁移
+func encryptLegacyPem(t *testing.T, derBytes, passphrase []byte) []byte {
+	block, err := aes.NewCipher(deriveLegacyKey(passphrase))
+	assert.NoError(t, err)
+
+	iv := make([]byte, aes.BlockSize)
+	_, err = rand.Read(iv)
+	assert.NoError(t, err)
+
+	padLen := aes.BlockSize - len(derBytes)%aes.BlockSize
+	padded := append(append([]byte{}, derBytes...), bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  PEM_TYPE_LEGACY,
+		Bytes: append(iv, ciphertext...),
+	})
+}
+
+func TestKeystore_RoundTrip(t *testing.T) {
+	privateKeyDer, _, err := createEcdsaPrivateKey()
+	assert.NoError(t, err)
+
+	keystorePem, err := encryptToPem(privateKeyDer, []byte("123456"))
+	assert.NoError(t, err)
+
+	block, _ := pem.Decode(keystorePem)
+	assert.Equal(t, PEM_TYPE_KEYSTORE, block.Type)
+	assert.Equal(t, "1", block.Headers["Version"])
+
+	privateKeyDer2, err := decryptFromPem(keystorePem, "123456")
+	assert.NoError(t, err)
+	assert.Equal(t, privateKeyDer, privateKeyDer2)
+}
+
+func TestKeystore_WrongPassphrase(t *testing.T) {
+	privateKeyDer, _, err := createEcdsaPrivateKey()
+	assert.NoError(t, err)
+
+	keystorePem, err := encryptToPem(privateKeyDer, []byte("123456"))
+	assert.NoError(t, err)
+
+	_, err = decryptFromPem(keystorePem, "654321")
+	assert.ErrorIs(t, err, ErrWrongPassphrase)
+}
+
+func TestKeystore_TamperedHeader(t *testing.T) {
+	privateKeyDer, _, err := createEcdsaPrivateKey()
+	assert.NoError(t, err)
+
+	keystorePem, err := encryptToPem(privateKeyDer, []byte("123456"))
+	assert.NoError(t, err)
+
+	// 篡改 KDF 参数会导致认证失败
+	block, _ := pem.Decode(keystorePem)
+	block.Headers["P"] = "2"
+	_, err = decryptFromPem(pem.EncodeToMemory(block), "123456")
+	assert.Error(t, err)
+}
+
+func TestKeystore_KDFLimits(t *testing.T) {
+	privateKeyDer, _, err := createEcdsaPrivateKey()
+	assert.NoError(t, err)
+
+	keystorePem, err := encryptToPem(privateKeyDer, []byte("123456"))
+	assert.NoError(t, err)
+
+	// 超出上限的参数在派生密钥之前就被拒绝
+	tests := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{"N", "N", "2097152"},
+		{"R", "R", "9"},
+		{"P", "P", "2"},
+		{"short salt", "Salt", "00112233"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, _ := pem.Decode(keystorePem)
+			block.Headers[tt.header] = tt.value
+			_, err := decryptFromPem(pem.EncodeToMemory(block), "123456")
+			assert.ErrorIs(t, err, ErrMalformedKeystore)
+		})
+	}
+}
+
+func TestLegacy_Decrypt(t *testing.T) {
+	privateKeyDer, _, err := createEcdsaPrivateKey()
+	assert.NoError(t, err)
+
+	legacyPem := encryptLegacyPem(t, privateKeyDer, []byte("123456"))
+
+	privateKeyDer2, err := decryptFromPem(legacyPem, "123456")
+	assert.NoError(t, err)
+	assert.Equal(t, privateKeyDer, privateKeyDer2)
+
+	_, err = decryptFromPem(legacyPem, "654321")
+	assert.ErrorIs(t, err, ErrWrongPassphrase)
+}
+
+func TestMigrateLegacyKey(t *testing.T) {
+	privateKeyDer, id, err := createEcdsaPrivateKey()
+	assert.NoError(t, err)
+
+	privateKeyFilename, err := getPrivateKeyFile(id)
+	assert.NoError(t, err)
+	err = os.WriteFile(privateKeyFilename, encryptLegacyPem(t, privateKeyDer, []byte("123456")), 0600)
+	assert.NoError(t, err)
+	defer os.Remove(privateKeyFilename)
+
+	migrated, err := MigrateLegacyKey(id, "123456")
+	assert.NoError(t, err)
+	assert.True(t, migrated)
+
+	content, err := os.ReadFile(privateKeyFilename)
+	assert.NoError(t, err)
+	block, _ := pem.Decode(content)
+	assert.Equal(t, PEM_TYPE_KEYSTORE, block.Type)
+
+	// 已经是新格式，不需要再迁移
+	migrated, err = MigrateLegacyKey(id, "123456")
+	assert.NoError(t, err)
+	assert.False(t, migrated)
+
+	_, err = loadPrivateKey(id, "123456")
+	assert.NoError(t, err)
+}
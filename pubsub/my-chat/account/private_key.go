@@ -58,22 +58,9 @@ func generatePrivateKeyFile(passphrase string) ([]byte, string, error) {
 		return nil, "", fmt.Errorf("create private key failed, err = %v", err)
 	}
 
-	// 使用{passphrase}，将`DER格式的私钥`加密为`pem格式的私钥`
-	encryptedPrivateKeyPem, err := encryptToPem(privateKeyDer, []byte(passphrase))
-	if err != nil {
-		return nil, "", fmt.Errorf("encrypt private key failed, err = %v", err)
-	}
-
-	// 生成私钥文件
-	privateKeyFilename, err := getPrivateKeyFile(id)
-	if err != nil {
-		return nil, "", fmt.Errorf("create private file failed, err = %v", err)
-	}
-
-	// 写入私钥文件
-	err = os.WriteFile(privateKeyFilename, encryptedPrivateKeyPem, 0644)
-	if err != nil {
-		return nil, "", fmt.Errorf("write priv key failed, err = %v", err)
+	// 使用{passphrase}，将`DER格式的私钥`加密为`pem格式的私钥`，并写入私钥文件
+	if err = writePrivateKeyFile(id, privateKeyDer, passphrase); err != nil {
+		return nil, "", err
 	}
 
 	return privateKeyDer, id, nil
@@ -82,59 +69,55 @@ func generatePrivateKeyFile(passphrase string) ([]byte, string, error) {
 // getPrivateKeyFile 根据给定的 ID 生成私钥文件的路径。
 func getPrivateKeyFile(id string) (string, error) {
 	privateKeyFilename := fmt.Sprintf("%s.pem", id)
-	privateKeyPath := filepath.Join(config.HomeDir, config.AccountDir, privateKeyFilename)
+	if err := config.Ensure(); err != nil {
+		return "", err
+	}
+	privateKeyPath := filepath.Join(config.KeyDir, privateKeyFilename)
 	return privateKeyPath, nil
 }
 
-// encryptToPem 将 DER 格式的私钥使用 AES 加密算法和给定的密码加密为 PEM 格式的私钥。
+// encryptToPem 将 DER 格式的私钥使用给定的密码加密为 PEM 格式的 keystore。
+// 密码为空时不加密。
 func encryptToPem(derBytes, passphrase []byte) ([]byte, error) {
 	if len(passphrase) == 0 {
 		return pem.EncodeToMemory(
 			&pem.Block{
-				Type:  "EC PRIVATE KEY",
+				Type:  PEM_TYPE_PLAIN,
 				Bytes: derBytes,
 			},
 		), nil
 	}
 
-	block, err := aes.NewCipher(deriveKey(passphrase))
-	if err != nil {
-		return nil, err
-	}
+	return encryptKeystore(derBytes, passphrase)
+}
 
-	iv := make([]byte, aes.BlockSize)
-	if _, err := rand.Read(iv); err != nil {
-		return nil, err
+// decryptFromPem 将 PEM 格式的私钥使用给定的密码解密为 DER 格式的私钥，
+// 兼容旧版本 AES-CBC 加密的私钥。
+func decryptFromPem(encryptedPem []byte, passphrase string) ([]byte, error) {
+	block, _ := pem.Decode(encryptedPem)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no pem block found", ErrMalformedKeystore)
 	}
 
-	stream := cipher.NewCBCEncrypter(block, iv)
-	padLen := aes.BlockSize - len(derBytes)%aes.BlockSize
-	padding := bytes.Repeat([]byte{byte(padLen)}, padLen)
-	derBytes = append(derBytes, padding...)
-
-	ciphertext := make([]byte, len(derBytes))
-	stream.CryptBlocks(ciphertext, derBytes)
-
-	encryptedPEM := pem.EncodeToMemory(
-		&pem.Block{
-			Type:  "ENCRYPTED EC PRIVATE KEY",
-			Bytes: append(iv, ciphertext...),
-		},
-	)
-
-	return encryptedPEM, nil
+	switch block.Type {
+	case PEM_TYPE_PLAIN:
+		return block.Bytes, nil
+	case PEM_TYPE_KEYSTORE:
+		return decryptKeystore(block, []byte(passphrase))
+	case PEM_TYPE_LEGACY:
+		return decryptLegacy(block.Bytes, []byte(passphrase))
+	default:
+		return nil, fmt.Errorf("%w: unknown pem type `%s`", ErrMalformedKeystore, block.Type)
+	}
 }
 
-// decryptFromPem 将加密的 PEM 格式的私钥使用 AES 解密算法和给定的密码解密为 DER 格式的私钥。
-func decryptFromPem(encryptedPem []byte, passphrase string) ([]byte, error) {
-
-	encryptedBlock, _ := pem.Decode(encryptedPem)
-	encryptedPrivateKey := encryptedBlock.Bytes
-	if len(passphrase) == 0 {
-		return encryptedPrivateKey, nil
+// decryptLegacy 解密旧版本的私钥：口令补零到 32 字节作为 AES 密钥，CBC 模式，PKCS#7 填充
+func decryptLegacy(encryptedPrivateKey, passphrase []byte) ([]byte, error) {
+	if len(encryptedPrivateKey) < 2*aes.BlockSize || len(encryptedPrivateKey)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: bad legacy ciphertext length %d", ErrMalformedKeystore, len(encryptedPrivateKey))
 	}
 
-	block, err := aes.NewCipher(deriveKey([]byte(passphrase)))
+	block, err := aes.NewCipher(deriveLegacyKey(passphrase))
 	if err != nil {
 		return nil, err
 	}
@@ -146,30 +129,148 @@ func decryptFromPem(encryptedPem []byte, passphrase string) ([]byte, error) {
 	decryptedBytes := make([]byte, len(encryptedPrivateKey))
 	stream.CryptBlocks(decryptedBytes, encryptedPrivateKey)
 
-	decryptedBytes = bytes.TrimRight(decryptedBytes, "\x00")
-	return decryptedBytes, nil
+	// 旧格式没有认证，只能通过填充和私钥结构判断口令是否正确
+	derBytes, ok := pkcs7Unpad(decryptedBytes)
+	if !ok {
+		return nil, ErrWrongPassphrase
+	}
+	if _, err = x509.ParseECPrivateKey(derBytes); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	return derBytes, nil
+}
+
+func pkcs7Unpad(data []byte) ([]byte, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+	padLen := int(data[len(data)-1])
+	if padLen == 0 || padLen > aes.BlockSize || padLen > len(data) {
+		return nil, false
+	}
+	if !bytes.Equal(data[len(data)-padLen:], bytes.Repeat([]byte{byte(padLen)}, padLen)) {
+		return nil, false
+	}
+	return data[:len(data)-padLen], true
 }
 
-func deriveKey(password []byte) []byte {
+func deriveLegacyKey(password []byte) []byte {
 	key := make([]byte, 32)
 	copy(key, password)
 	return key
 }
 
-func loadPrivateKey(id string, passphrase string) (libp2p_crypto.PrivKey, error) {
+// GetKeyFormat 返回账户私钥文件的格式：plain、keystore 或 legacy
+func GetKeyFormat(id string) (string, error) {
 	privateKeyFilename, err := getPrivateKeyFile(id)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	content, err := os.ReadFile(privateKeyFilename)
 	if err != nil {
-		return nil, fmt.Errorf("read private key failed, err = %v", err)
+		return "", fmt.Errorf("read private key failed, err = %v", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return "", ErrMalformedKeystore
+	}
+	switch block.Type {
+	case PEM_TYPE_PLAIN:
+		return "plain", nil
+	case PEM_TYPE_KEYSTORE:
+		return fmt.Sprintf("keystore v%s", block.Headers["Version"]), nil
+	case PEM_TYPE_LEGACY:
+		return "legacy", nil
+	default:
+		return "", fmt.Errorf("%w: unknown pem type `%s`", ErrMalformedKeystore, block.Type)
+	}
+}
+
+// GetPrivateKeyFile 返回账户私钥文件的路径
+func GetPrivateKeyFile(id string) (string, error) {
+	return getPrivateKeyFile(id)
+}
+
+// ParsePrivateKey 解析外部的私钥，返回 DER 格式的 ECDSA 私钥。
+// 支持 PEM 格式（明文、keystore、旧版本加密格式）和 libp2p 序列化的私钥（例如 utils.GeneratePrivateKey 生成的 host1.pem）。
+func ParsePrivateKey(content []byte, passphrase string) ([]byte, error) {
+	if block, _ := pem.Decode(content); block != nil {
+		return decryptFromPem(content, passphrase)
+	}
+
+	privateKey, err := libp2p_crypto.UnmarshalPrivateKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("neither pem nor libp2p private key, err = %v", err)
+	}
+	if privateKey.Type() != libp2p_crypto.ECDSA {
+		return nil, fmt.Errorf("only ECDSA keys are supported, got %s", privateKey.Type())
+	}
+
+	// ECDSA 私钥的 Raw 就是 DER 格式
+	return privateKey.Raw()
+}
+
+func isLegacyPem(content []byte) bool {
+	block, _ := pem.Decode(content)
+	return block != nil && block.Type == PEM_TYPE_LEGACY
+}
+
+// MigrateLegacyKey 将旧版本的私钥文件用同一个口令重新加密为新格式，
+// 文件已经是新格式时什么也不做。
+func MigrateLegacyKey(id string, passphrase string) (bool, error) {
+	privateKeyFilename, err := getPrivateKeyFile(id)
+	if err != nil {
+		return false, err
+	}
+
+	content, err := os.ReadFile(privateKeyFilename)
+	if err != nil {
+		return false, fmt.Errorf("read private key failed, err = %v", err)
+	}
+	if !isLegacyPem(content) {
+		return false, nil
 	}
 
 	privateKeyDer, err := decryptFromPem(content, passphrase)
 	if err != nil {
-		return nil, fmt.Errorf("decrypt private key failed, err = %v", err)
+		return false, fmt.Errorf("decrypt legacy private key failed, err = %w", err)
+	}
+
+	if err = writePrivateKeyFile(id, privateKeyDer, passphrase); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writePrivateKeyFile 加密私钥并写入文件，先写临时文件再替换，避免写坏原文件
+func writePrivateKeyFile(id string, privateKeyDer []byte, passphrase string) error {
+	encryptedPrivateKeyPem, err := encryptToPem(privateKeyDer, []byte(passphrase))
+	if err != nil {
+		return fmt.Errorf("encrypt private key failed, err = %v", err)
+	}
+
+	privateKeyFilename, err := getPrivateKeyFile(id)
+	if err != nil {
+		return err
+	}
+
+	tmpFilename := privateKeyFilename + ".tmp"
+	if err = os.WriteFile(tmpFilename, encryptedPrivateKeyPem, 0600); err != nil {
+		return fmt.Errorf("write priv key failed, err = %v", err)
+	}
+	if err = os.Rename(tmpFilename, privateKeyFilename); err != nil {
+		return fmt.Errorf("replace priv key failed, err = %v", err)
+	}
+	return nil
+}
+
+func loadPrivateKey(id string, passphrase string) (libp2p_crypto.PrivKey, error) {
+	privateKeyDer, err := loadPrivateKeyDer(id, passphrase)
+	if err != nil {
+		return nil, err
 	}
 
 	privateKey, err := libp2p_crypto.UnmarshalECDSAPrivateKey(privateKeyDer)
@@ -179,3 +280,30 @@ func loadPrivateKey(id string, passphrase string) (libp2p_crypto.PrivKey, error)
 
 	return privateKey, nil
 }
+
+// loadPrivateKeyDer 读取并解密私钥文件，返回 DER 格式的私钥
+func loadPrivateKeyDer(id string, passphrase string) ([]byte, error) {
+	privateKeyFilename, err := getPrivateKeyFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(privateKeyFilename)
+	if err != nil {
+		return nil, fmt.Errorf("read private key failed, err = %v", err)
+	}
+
+	privateKeyDer, err := decryptFromPem(content, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt private key failed, err = %w", err)
+	}
+
+	// 口令正确，顺便把旧版本的私钥文件迁移为新格式
+	if isLegacyPem(content) {
+		if err = writePrivateKeyFile(id, privateKeyDer, passphrase); err != nil {
+			return nil, fmt.Errorf("migrate legacy priv key failed, err = %v", err)
+		}
+	}
+
+	return privateKeyDer, nil
+}
@@ -0,0 +1,833 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/global"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/history"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/gorilla/websocket"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"gorm.io/gorm"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAPIAddr = "127.0.0.1:7890"
+
+	// 请求体的最大长度
+	maxRequestSize = 64 * 1024
+	// 每个 websocket 客户端缓存的事件数，客户端处理不过来时断开
+	apiEventBufSize = 128
+	apiWriteTimeout = 10 * time.Second
+)
+
+// 推送给 websocket 客户端的事件类型
+const (
+	EVENT_ROOM_MESSAGE     = "room_message"
+	EVENT_DIRECT_MESSAGE   = "direct_message"
+	EVENT_DIRECT_REQUEST   = "direct_request"
+	EVENT_FRIEND_HANDSHAKE = "friend_handshake"
+)
+
+type APIEvent struct {
+	Type string      `json:"type"`
+	Room string      `json:"room,omitempty"`
+	Data interface{} `json:"data"`
+}
+
+type RoomInfo struct {
+	Name  string       `json:"name"`
+	Topic string       `json:"topic"`
+	Group *links.Group `json:"group,omitempty"`
+	Peers int          `json:"peers"`
+}
+
+type PeerInfo struct {
+	Id        string `json:"id"`
+	Nickname  string `json:"nickname,omitempty"`
+	Friend    bool   `json:"friend"`
+	Direction string `json:"direction"`
+	Transport string `json:"transport"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// APIServer 是 my-chat 的无界面模式，通过本地的 HTTP 接口操作聊天室、好友和群，
+// 通过 websocket 推送收到的消息。与界面共用 ChatRoom 和 links 的实现。
+type APIServer struct {
+	ctx       context.Context
+	ps        *pubsub.PubSub
+	dm        *DMService
+	friends   *FriendService
+	discovery *DiscoveryService
+	token     string
+
+	// 保护聊天室列表
+	mu    sync.Mutex
+	rooms []*ChatRoom
+
+	clientsMu sync.Mutex
+	clients   map[*websocket.Conn]chan *APIEvent
+	upgrader  websocket.Upgrader
+}
+
+// NewAPIServer 创建接口服务，{token}为空时生成随机的 token，通过 Token 得到
+func NewAPIServer(chatrooms []*ChatRoom, dm *DMService, friends *FriendService,
+	discovery *DiscoveryService, token string) (*APIServer, error) {
+	if len(token) == 0 {
+		var err error
+		if token, err = newAPIToken(); err != nil {
+			return nil, err
+		}
+	}
+	srv := &APIServer{
+		ctx:       dm.ctx,
+		dm:        dm,
+		friends:   friends,
+		discovery: discovery,
+		token:     token,
+		clients:   make(map[*websocket.Conn]chan *APIEvent),
+	}
+	if len(chatrooms) > 0 {
+		srv.ps = chatrooms[0].ps
+	}
+	for _, chatroom := range chatrooms {
+		srv.addRoom(chatroom)
+	}
+	return srv, nil
+}
+
+// Run 在{addr}上提供接口，直到{ctx}结束
+func (srv *APIServer) Run(ctx context.Context, addr string) error {
+	server := &http.Server{Addr: addr, Handler: srv.handler(addr)}
+	go srv.forwardEvents(ctx)
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+
+	log.Printf("[api] listen on %s", addr)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// handler 返回所有的接口，{addr}是监听的地址，用来检查 Host 请求头
+func (srv *APIServer) handler(addr string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/rooms", srv.handleRooms)
+	mux.HandleFunc("/api/rooms/", srv.handleRoom)
+	mux.HandleFunc("/api/peers", srv.handlePeers)
+	mux.HandleFunc("/api/friends", srv.handleFriends)
+	mux.HandleFunc("/api/friends/", srv.handleFriend)
+	mux.HandleFunc("/api/friend-requests", srv.handleFriendRequests)
+	mux.HandleFunc("/api/friend-requests/", srv.handleFriendRequest)
+	mux.HandleFunc("/api/direct-requests", srv.handleDirectRequests)
+	mux.HandleFunc("/api/direct-requests/", srv.handleDirectRequest)
+	mux.HandleFunc("/api/groups", srv.handleGroups)
+	mux.HandleFunc("/api/groups/", srv.handleGroup)
+	mux.HandleFunc("/api/events", srv.handleEvents)
+
+	listenHost, _, _ := net.SplitHostPort(addr)
+	return srv.authenticate(listenHost, mux)
+}
+
+// Token 返回访问接口需要的 token
+func (srv *APIServer) Token() string {
+	return srv.token
+}
+
+// authenticate 拦截其他网页借用浏览器发来的请求：
+//   - 请求必须携带 `Authorization: Bearer <token>`，浏览器中的 websocket 不能设置请求头，也可以用 `?token=<token>`
+//   - Host 必须是 IP、localhost 或者监听的地址，防止 DNS rebinding
+//   - POST 等请求必须是 `Content-Type: application/json`，跨域时浏览器会先发预检请求；
+//     DELETE 没有请求体，跨域时浏览器本来就会预检
+func (srv *APIServer) authenticate(listenHost string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host, listenHost) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host `%s` not allowed", r.Host))
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if len(token) == 0 {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(srv.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+
+		if needsJSON(r.Method) && !isJSONRequest(r) {
+			writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("content type must be application/json"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost 判断请求头中的{host}是否指向本机的接口。
+// DNS rebinding 时 Host 是攻击者的域名，所以只接受 IP、localhost 和监听的主机名。
+func allowedHost(host string, listenHost string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if len(host) == 0 {
+		return false
+	}
+	if net.ParseIP(host) != nil || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	return len(listenHost) > 0 && strings.EqualFold(host, listenHost)
+}
+
+// needsJSON 判断{method}的请求是否必须是 JSON
+func needsJSON(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		return false
+	}
+	return true
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// newAPIToken 生成随机的 token
+func newAPIToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate api token failed, err = %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// handleRooms 处理 GET /api/rooms 和 POST /api/rooms {"name"}
+func (srv *APIServer) handleRooms(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		srv.mu.Lock()
+		rooms := make([]*RoomInfo, 0, len(srv.rooms))
+		for _, chatroom := range srv.rooms {
+			rooms = append(rooms, newRoomInfo(chatroom))
+		}
+		srv.mu.Unlock()
+		writeJSON(w, http.StatusOK, rooms)
+
+	case http.MethodPost:
+		req := &struct {
+			Name string `json:"name"`
+		}{}
+		if err := readJSON(w, r, req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		chatroom, err := srv.joinRoom(req.Name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newRoomInfo(chatroom))
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleRoom 处理 /api/rooms/{name}、/api/rooms/{name}/messages 和 /api/rooms/{name}/peers
+func (srv *APIServer) handleRoom(w http.ResponseWriter, r *http.Request) {
+	segments, err := pathSegments(r, "/api/rooms/")
+	if err != nil || len(segments) == 0 || len(segments) > 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path `%s`", r.URL.Path))
+		return
+	}
+
+	chatroom := srv.findRoom(segments[0])
+	if chatroom == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("room `%s` not joined", segments[0]))
+		return
+	}
+
+	action := ""
+	if len(segments) == 2 {
+		action = segments[1]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+		if err = srv.leaveRoom(chatroom); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "messages" && r.Method == http.MethodGet:
+		before, limit := pageParams(r)
+		messages, err := history.LoadRoomMessages(srv.myId(), chatroom.topicId, before, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, messages)
+
+	case action == "messages" && r.Method == http.MethodPost:
+		req := &struct {
+			Message string `json:"message"`
+		}{}
+		if err = readJSON(w, r, req); err != nil || len(req.Message) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("message is required"))
+			return
+		}
+		id, err := chatroom.Publish(req.Message)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id": id})
+
+	case action == "peers" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, srv.peerInfos(chatroom.ListPeers()))
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path `%s %s`", r.Method, r.URL.Path))
+	}
+}
+
+// handlePeers 处理 GET /api/peers，返回所有已连接的节点
+func (srv *APIServer) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, srv.peerInfos(srv.dm.host.Network().Peers()))
+}
+
+// handleFriends 处理 GET /api/friends 和 POST /api/friends {"peerId", "nickname"}
+func (srv *APIServer) handleFriends(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, global.ListMyFriends())
+
+	case http.MethodPost:
+		req := &struct {
+			PeerId   string `json:"peerId"`
+			Nickname string `json:"nickname"`
+		}{}
+		if err := readJSON(w, r, req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		request, err := srv.friends.SendRequest(req.PeerId, req.Nickname)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, request)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleFriend 处理 DELETE /api/friends/{id} 和 /api/friends/{id}/messages
+func (srv *APIServer) handleFriend(w http.ResponseWriter, r *http.Request) {
+	segments, err := pathSegments(r, "/api/friends/")
+	if err != nil || len(segments) == 0 || len(segments) > 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path `%s`", r.URL.Path))
+		return
+	}
+
+	friend, ok := global.FindMyFriend(segments[0])
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("`%s` is not your friend", segments[0]))
+		return
+	}
+
+	action := ""
+	if len(segments) == 2 {
+		action = segments[1]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+		if err = links.RemoveFriend(srv.myId(), friend.Id); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		global.RemoveMyFriend(friend.Id)
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "messages" && r.Method == http.MethodGet:
+		before, limit := pageParams(r)
+		messages, err := history.LoadPeerMessages(srv.myId(), friend.Id, before, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, messages)
+
+	case action == "messages" && r.Method == http.MethodPost:
+		req := &struct {
+			Message string `json:"message"`
+		}{}
+		if err = readJSON(w, r, req); err != nil || len(req.Message) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("message is required"))
+			return
+		}
+		ack, err := srv.dm.Send(friend.Id, req.Message)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ack)
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path `%s %s`", r.Method, r.URL.Path))
+	}
+}
+
+// handleFriendRequests 处理 GET /api/friend-requests，返回待处理的好友请求
+func (srv *APIServer) handleFriendRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	requests, err := srv.friends.PendingRequests()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, requests)
+}
+
+// handleFriendRequest 处理 POST /api/friend-requests/{id or nickname}/accept 和 .../reject
+func (srv *APIServer) handleFriendRequest(w http.ResponseWriter, r *http.Request) {
+	segments, err := pathSegments(r, "/api/friend-requests/")
+	if err != nil || len(segments) != 2 || r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path `%s %s`", r.Method, r.URL.Path))
+		return
+	}
+
+	switch segments[1] {
+	case "accept":
+		friend, err := srv.friends.Accept(segments[0])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, friend)
+	case "reject":
+		request, err := srv.friends.Reject(segments[0])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, request)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path `%s %s`", r.Method, r.URL.Path))
+	}
+}
+
+// handleDirectRequests 处理 GET /api/direct-requests，返回陌生人发来的、还没有处理的私聊
+func (srv *APIServer) handleDirectRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	requests := make(map[string][]*DirectMessage)
+	for pid, msgs := range srv.dm.PendingRequests() {
+		requests[pid.String()] = msgs
+	}
+	writeJSON(w, http.StatusOK, requests)
+}
+
+// handleDirectRequest 处理 POST /api/direct-requests/{peer id}/accept 和 .../drop
+func (srv *APIServer) handleDirectRequest(w http.ResponseWriter, r *http.Request) {
+	segments, err := pathSegments(r, "/api/direct-requests/")
+	if err != nil || len(segments) != 2 || r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path `%s %s`", r.Method, r.URL.Path))
+		return
+	}
+	pid, err := peer.Decode(segments[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid peer id `%s`", segments[0]))
+		return
+	}
+
+	switch segments[1] {
+	case "accept":
+		writeJSON(w, http.StatusOK, srv.dm.AcceptRequests(pid))
+	case "drop":
+		writeJSON(w, http.StatusOK, map[string]int{"dropped": srv.dm.DropRequests(pid)})
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path `%s %s`", r.Method, r.URL.Path))
+	}
+}
+
+// handleGroups 处理 GET /api/groups 和 POST /api/groups，
+// POST 的请求带有 id 时加入别人创建的群，否则创建新群
+func (srv *APIServer) handleGroups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		groups, err := global.GetMyGroups(srv.myId())
+		groupList := make([]*links.Group, 0, len(groups))
+		for _, group := range groups {
+			groupList = append(groupList, group)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, groupList)
+
+	case http.MethodPost:
+		req := &struct {
+			Id         string `json:"id"`
+			Name       string `json:"name"`
+			Topic      string `json:"topic"`
+			InviteOnly bool   `json:"inviteOnly"`
+		}{}
+		if err := readJSON(w, r, req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		groupType := links.GROUP_TYPE_OPEN
+		if req.InviteOnly {
+			groupType = links.GROUP_TYPE_INVITE_ONLY
+		}
+
+		var group *links.Group
+		var err error
+		if len(req.Id) == 0 {
+			group, err = links.CreateGroup(srv.myId(), req.Name, groupType)
+		} else {
+			group = &links.Group{Id: req.Id, Name: req.Name, Type: groupType, Topic: req.Topic}
+			// 公开群的主题就是群名
+			if len(group.Topic) == 0 && !req.InviteOnly {
+				group.Topic = req.Name
+			}
+			err = links.JoinGroup(srv.myId(), group)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		global.AddMyGroup(group)
+		writeJSON(w, http.StatusOK, group)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleGroup 处理 DELETE /api/groups/{id} 和 POST /api/groups/{id}/members {"peerId"}
+func (srv *APIServer) handleGroup(w http.ResponseWriter, r *http.Request) {
+	segments, err := pathSegments(r, "/api/groups/")
+	if err != nil || len(segments) == 0 || len(segments) > 2 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path `%s`", r.URL.Path))
+		return
+	}
+
+	group, err := links.GetGroup(srv.myId(), segments[0])
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		// 退出群之前先退出群的聊天室
+		for _, chatroom := range srv.listRooms() {
+			if chatroom.group != nil && chatroom.group.Id == group.Id {
+				if err = srv.leaveRoom(chatroom); err != nil {
+					writeError(w, http.StatusInternalServerError, err)
+					return
+				}
+			}
+		}
+		if err = links.LeaveGroup(srv.myId(), group.Id); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		global.RemoveMyGroup(group.Id)
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(segments) == 2 && segments[1] == "members" && r.Method == http.MethodPost:
+		req := &struct {
+			PeerId string `json:"peerId"`
+		}{}
+		if err = readJSON(w, r, req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if _, err = peer.Decode(req.PeerId); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid peer id `%s`", req.PeerId))
+			return
+		}
+		if err = links.AddGroupMember(srv.myId(), group.Id, req.PeerId); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		for _, chatroom := range srv.listRooms() {
+			if chatroom.group != nil && chatroom.group.Id == group.Id {
+				chatroom.AddMember(req.PeerId)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path `%s %s`", r.Method, r.URL.Path))
+	}
+}
+
+// handleEvents 把连接升级为 websocket，推送收到的聊天室消息、私聊和好友请求
+func (srv *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := srv.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("[api] upgrade websocket failed, err = %v", err)
+		return
+	}
+
+	events := make(chan *APIEvent, apiEventBufSize)
+	srv.clientsMu.Lock()
+	srv.clients[conn] = events
+	srv.clientsMu.Unlock()
+	log.Printf("[api] websocket client %s connected", conn.RemoteAddr())
+
+	// 客户端不会发送数据，读到错误说明连接已经关闭
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				srv.removeClient(conn)
+				return
+			}
+		}
+	}()
+
+	for event := range events {
+		_ = conn.SetWriteDeadline(time.Now().Add(apiWriteTimeout))
+		if err = conn.WriteJSON(event); err != nil {
+			log.Printf("[api] write to websocket client %s failed, err = %v", conn.RemoteAddr(), err)
+			srv.removeClient(conn)
+			break
+		}
+	}
+	_ = conn.Close()
+}
+
+func (srv *APIServer) removeClient(conn *websocket.Conn) {
+	srv.clientsMu.Lock()
+	defer srv.clientsMu.Unlock()
+
+	if events, ok := srv.clients[conn]; ok {
+		delete(srv.clients, conn)
+		close(events)
+	}
+}
+
+// broadcast 把事件推送给所有的 websocket 客户端，处理不过来的客户端会被断开
+func (srv *APIServer) broadcast(event *APIEvent) {
+	srv.clientsMu.Lock()
+	defer srv.clientsMu.Unlock()
+
+	for conn, events := range srv.clients {
+		select {
+		case events <- event:
+		default:
+			log.Printf("[api] websocket client %s is too slow, disconnect", conn.RemoteAddr())
+			delete(srv.clients, conn)
+			close(events)
+		}
+	}
+}
+
+// forwardEvents 推送私聊和好友请求，聊天室的消息由 addRoom 推送
+func (srv *APIServer) forwardEvents(ctx context.Context) {
+	for {
+		select {
+		case m := <-srv.dm.Messages:
+			srv.broadcast(&APIEvent{Type: EVENT_DIRECT_MESSAGE, Data: m})
+		case m := <-srv.dm.Requests:
+			srv.broadcast(&APIEvent{Type: EVENT_DIRECT_REQUEST, Data: m})
+		case hs := <-srv.friends.Events:
+			srv.broadcast(&APIEvent{Type: EVENT_FRIEND_HANDSHAKE, Data: hs})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (srv *APIServer) addRoom(chatroom *ChatRoom) {
+	srv.mu.Lock()
+	srv.rooms = append(srv.rooms, chatroom)
+	srv.mu.Unlock()
+
+	go func() {
+		for m := range chatroom.Messages {
+			srv.broadcast(&APIEvent{Type: EVENT_ROOM_MESSAGE, Room: chatroom.roomName, Data: m})
+		}
+	}()
+}
+
+// joinRoom 加入聊天室，已经加入时直接返回
+func (srv *APIServer) joinRoom(name string) (*ChatRoom, error) {
+	if len(name) == 0 {
+		return nil, fmt.Errorf("room name is required")
+	}
+	if chatroom := srv.findRoom(name); chatroom != nil {
+		return chatroom, nil
+	}
+
+	chatroom, err := JoinRoomByName(srv.ctx, srv.ps, srv.dm.key, srv.dm.Nick(), srv.myId(), name)
+	if err != nil {
+		return nil, fmt.Errorf("join `%s` failed, err = %v", name, err)
+	}
+	srv.addRoom(chatroom)
+
+	if srv.discovery != nil {
+		if err = srv.discovery.AddRendezvous(chatroom.topicId); err != nil {
+			log.Printf("[api] discover peers of `%s` failed, err = %v", name, err)
+		}
+	}
+	return chatroom, nil
+}
+
+func (srv *APIServer) leaveRoom(chatroom *ChatRoom) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for i, joined := range srv.rooms {
+		if joined == chatroom {
+			if err := chatroom.Leave(); err != nil {
+				return err
+			}
+			srv.rooms = append(srv.rooms[:i], srv.rooms[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (srv *APIServer) findRoom(name string) *ChatRoom {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for _, chatroom := range srv.rooms {
+		if chatroom.roomName == name {
+			return chatroom
+		}
+	}
+	return nil
+}
+
+func (srv *APIServer) listRooms() []*ChatRoom {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]*ChatRoom(nil), srv.rooms...)
+}
+
+func (srv *APIServer) peerInfos(peers []peer.ID) []*PeerInfo {
+	infos := make([]*PeerInfo, 0, len(peers))
+	for _, pid := range peers {
+		row := newPeerRow(srv.dm.host, pid, "")
+		infos = append(infos, &PeerInfo{
+			Id:        pid.String(),
+			Nickname:  row.nick,
+			Friend:    row.friend,
+			Direction: row.direction,
+			Transport: row.transport,
+			LatencyMs: row.latency.Milliseconds(),
+		})
+	}
+	return infos
+}
+
+func (srv *APIServer) myId() string {
+	return srv.dm.host.ID().String()
+}
+
+func newRoomInfo(chatroom *ChatRoom) *RoomInfo {
+	return &RoomInfo{
+		Name:  chatroom.roomName,
+		Topic: chatroom.topicId,
+		Group: chatroom.group,
+		Peers: len(chatroom.ListPeers()),
+	}
+}
+
+// pathSegments 返回{prefix}之后的路径，按 `/` 分段并解码
+func pathSegments(r *http.Request, prefix string) ([]string, error) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/")
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+		segments[i] = decoded
+	}
+	return segments, nil
+}
+
+// pageParams 解析翻页参数 ?before=<毫秒时间戳>&limit=<条数>
+func pageParams(r *http.Request) (int64, int) {
+	before := time.Now().UnixMilli()
+	if v, err := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64); err == nil {
+		before = v
+	}
+	limit := HistoryPageSize
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 10*HistoryPageSize {
+		limit = v
+	}
+	return before, limit
+}
+
+// readJSON 解析请求体，Content-Type 已经在 authenticate 中检查过
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if !isJSONRequest(r) {
+		return fmt.Errorf("content type must be application/json")
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body, err = %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[api] write response failed, err = %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
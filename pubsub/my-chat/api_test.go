@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/config"
+	db2 "github.com/czh0526/libp2p-examples/pubsub/my-chat/db"
+	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPathSegments(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/rooms/my%20room/messages", nil)
+	segments, err := pathSegments(r, "/api/rooms/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"my room", "messages"}, segments)
+
+	// 房间名中的 `/` 需要转义
+	r = httptest.NewRequest("DELETE", "/api/rooms/a%2Fb", nil)
+	segments, err = pathSegments(r, "/api/rooms/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/b"}, segments)
+}
+
+func TestPageParams(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/rooms/r/messages?before=100&limit=10", nil)
+	before, limit := pageParams(r)
+	assert.Equal(t, int64(100), before)
+	assert.Equal(t, 10, limit)
+
+	// 超出范围的 limit 使用默认值
+	r = httptest.NewRequest("GET", "/api/rooms/r/messages?limit=100000", nil)
+	_, limit = pageParams(r)
+	assert.Equal(t, HistoryPageSize, limit)
+}
+
+const testAPIToken = "test-token"
+
+// newTestAPIServer 创建一个只有本地节点的接口服务，数据库放在临时目录中
+func newTestAPIServer(t *testing.T) *APIServer {
+	assert.NoError(t, config.Init(&config.Options{HomeDir: t.TempDir()}))
+	t.Cleanup(func() { db2.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	key, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	assert.NoError(t, err)
+	h, err := libp2p.New(libp2p.Identity(key), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	assert.NoError(t, err)
+
+	dm := NewDMService(ctx, h, key, "alice", nil)
+	srv, err := NewAPIServer(nil, dm, NewFriendService(ctx, h, key, "alice"), nil, testAPIToken)
+	assert.NoError(t, err)
+	srv.ps = ps
+	return srv
+}
+
+// serveAPI 发出一个带 token 的请求，{body}不为空时作为 JSON 请求体
+func serveAPI(srv *APIServer, method string, target string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Host = DefaultAPIAddr
+	r.Header.Set("Authorization", "Bearer "+testAPIToken)
+	if len(body) > 0 {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	srv.handler(DefaultAPIAddr).ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIRejectsUnauthorizedRequests(t *testing.T) {
+	srv := newTestAPIServer(t)
+	handler := srv.handler(DefaultAPIAddr)
+
+	for _, token := range []string{"", "Bearer wrong"} {
+		r := httptest.NewRequest("GET", "/api/rooms", nil)
+		r.Host = DefaultAPIAddr
+		r.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	// DNS rebinding 时 Host 是其他的域名
+	r := httptest.NewRequest("GET", "/api/rooms?token="+testAPIToken, nil)
+	r.Host = "evil.example.com:7890"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// 表单请求不需要预检，必须拒绝
+	r = httptest.NewRequest("POST", "/api/rooms", strings.NewReader(`{"name":"lobby"}`))
+	r.Host = DefaultAPIAddr
+	r.Header.Set("Authorization", "Bearer "+testAPIToken)
+	r.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Nil(t, srv.findRoom("lobby"))
+
+	// query 中的 token 也可以访问
+	r = httptest.NewRequest("GET", "/api/rooms?token="+testAPIToken, nil)
+	r.Host = "localhost:7890"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAPIJoinAndLeaveRoom(t *testing.T) {
+	srv := newTestAPIServer(t)
+
+	w := serveAPI(srv, "POST", "/api/rooms", `{"name":"lobby"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	room := new(RoomInfo)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), room))
+	assert.Equal(t, "lobby", room.Name)
+	chatroom := srv.findRoom("lobby")
+	assert.NotNil(t, chatroom)
+
+	// 名称不能为空
+	w = serveAPI(srv, "POST", "/api/rooms", `{"name":""}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serveAPI(srv, "DELETE", "/api/rooms/lobby", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Nil(t, srv.findRoom("lobby"))
+	// 再次退出不会重复关闭
+	assert.NoError(t, chatroom.Leave())
+
+	w = serveAPI(srv, "DELETE", "/api/rooms/lobby", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPIPostMessage(t *testing.T) {
+	srv := newTestAPIServer(t)
+	assert.Equal(t, http.StatusOK, serveAPI(srv, "POST", "/api/rooms", `{"name":"lobby"}`).Code)
+
+	for _, body := range []string{`{"message":""}`, `{"text":"hello"}`, `not json`} {
+		w := serveAPI(srv, "POST", "/api/rooms/lobby/messages", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	w := serveAPI(srv, "POST", "/api/rooms/lobby/messages", `{"message":"hello"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := map[string]string{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotEmpty(t, resp["id"])
+
+	// 没有加入的聊天室
+	w = serveAPI(srv, "POST", "/api/rooms/other/messages", `{"message":"hello"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAPIDisconnectSlowClient(t *testing.T) {
+	srv := newTestAPIServer(t)
+	server := httptest.NewServer(srv.handler(DefaultAPIAddr))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/events?token=" + testAPIToken
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	clients := func() int {
+		srv.clientsMu.Lock()
+		defer srv.clientsMu.Unlock()
+		return len(srv.clients)
+	}
+	assert.Eventually(t, func() bool { return clients() == 1 }, 3*time.Second, 10*time.Millisecond)
+
+	// 客户端不读取，填满 socket 的缓冲区之后事件堆积在管道中
+	event := &APIEvent{Type: EVENT_ROOM_MESSAGE, Room: "lobby", Data: strings.Repeat("x", 64*1024)}
+	for i := 0; i < 4096 && clients() > 0; i++ {
+		srv.broadcast(event)
+	}
+	assert.Equal(t, 0, clients())
+}
@@ -2,9 +2,18 @@ package main
 
 import (
 	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/common"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/config"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"log"
+	"time"
 )
 
+// 默认的引导节点，总是与配置文件中的节点一起使用
 var (
 	BOOTSTRAP_PEERS = []ma.Multiaddr{
 		convertPeers("/ip4/1.13.245.16/tcp/8080/p2p/QmWiG7ExhxNokqzghHrxC25m3W8gVEftgcrZsJKhPv1Y74"),
@@ -19,3 +28,64 @@ func convertPeers(address string) ma.Multiaddr {
 
 	return multiAddr
 }
+
+// bootstrapPeers 返回默认节点，以及配置文件中配置的和学到的引导节点
+func bootstrapPeers(settings *config.Settings) []peer.AddrInfo {
+	var peers []peer.AddrInfo
+	for _, peerAddr := range BOOTSTRAP_PEERS {
+		peerInfo, err := peer.AddrInfoFromP2pAddr(peerAddr)
+		if err != nil {
+			log.Printf("parse bootstrap peer address failed, err = %v \n", err)
+			continue
+		}
+		peers = append(peers, *peerInfo)
+	}
+	return append(peers, settings.BootstrapPeers()...)
+}
+
+// rememberBootstrapPeers 把 DHT 路由表中有公网地址的节点作为学到的引导节点记入配置文件，
+// 它们在本次会话中响应过 DHT 请求，适合作为下次启动的引导节点。用户配置的节点保持不变。
+func rememberBootstrapPeers(h host.Host, kadDHT *dht.IpfsDHT) error {
+	settings, err := config.LoadSettings()
+	if err != nil {
+		return err
+	}
+
+	added := 0
+	for _, pid := range kadDHT.RoutingTable().ListPeers() {
+		if added >= config.MaxBootstrapPeers {
+			break
+		}
+
+		addr := publicAddr(h, pid)
+		if addr == nil {
+			continue
+		}
+		p2pAddr := addr.Encapsulate(ma.StringCast("/p2p/" + pid.String()))
+		ok, err := settings.LearnBootstrap(p2pAddr.String())
+		if err != nil {
+			log.Printf("add bootstrap peer `%s` failed, err = %v", p2pAddr, err)
+			continue
+		}
+		if ok {
+			added++
+		}
+	}
+
+	if added == 0 {
+		return nil
+	}
+	log.Printf("remember %d new bootstrap peers", added)
+	settings.Timestamp = common.GetTimestampString(time.Now())
+	return config.SaveSettings(settings)
+}
+
+// publicAddr 返回节点的第一个公网地址
+func publicAddr(h host.Host, pid peer.ID) ma.Multiaddr {
+	for _, addr := range h.Peerstore().Addrs(pid) {
+		if manet.IsPublicAddr(addr) {
+			return addr
+		}
+	}
+	return nil
+}
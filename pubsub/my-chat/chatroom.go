@@ -2,14 +2,42 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/global"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/history"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/google/uuid"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"log"
+	"strings"
+	"sync"
+	"time"
 )
 
 const ChatRoomBufSize = 128
 
+// 聊天室消息的类型，旧版本的消息没有 Type，按文本消息处理。
+// 文本消息发送时也不带 Type，旧版本的节点可以正常校验签名；
+// 其它类型的消息在旧版本的节点上签名校验失败，会被直接丢弃。
+const (
+	CHAT_TYPE_TEXT     = "text"
+	CHAT_TYPE_TYPING   = "typing"
+	CHAT_TYPE_PRESENCE = "presence"
+	CHAT_TYPE_READ     = "read"
+	CHAT_TYPE_FILE     = "file"
+)
+
+const (
+	PRESENCE_ONLINE  = "online"
+	PRESENCE_AWAY    = "away"
+	PRESENCE_OFFLINE = "offline"
+)
+
+// 在线状态的心跳间隔，超过 3 个间隔没有心跳的节点视为离线
+const PresenceInterval = 30 * time.Second
+
 type ChatRoom struct {
 	Messages chan *ChatMessage
 
@@ -19,28 +47,102 @@ type ChatRoom struct {
 	sub   *pubsub.Subscription
 
 	roomName string
+	topicId  string
+	group    *links.Group
 	self     peer.ID
+	key      crypto.PrivKey
 	nick     string
+
+	// 保护昵称、在线状态和群成员，心跳协程和校验器也会读取
+	mu sync.Mutex
+	// 邀请群的成员，为 nil 时不限制发送者
+	members  map[string]bool
+	presence string
+	// 聊天室中的节点能够读懂的格式，使用 protobuf 发送之前检查
+	wires map[string]*peerWire
+	leaveCh  chan struct{}
+
+	// 界面和接口都可能退出聊天室，只退出一次
+	leaveOnce sync.Once
+	leaveErr  error
 }
 
 type ChatMessage struct {
+	Id         string `json:",omitempty"`
+	Type       string `json:",omitempty"`
 	Message    string
 	SenderID   string
 	SenderNick string
+	Timestamp  int64 `json:",omitempty"`
+	// 在线状态，Type 为 presence 时有效
+	Status string `json:",omitempty"`
+	// 已读的消息 id，Type 为 read 时有效
+	ReadIds []string `json:",omitempty"`
+	// 文件通知，Type 为 file 时有效
+	File *FileOffer `json:",omitempty"`
+	// 发送者能读懂的编码格式，Type 为 presence 时有效
+	Formats []string `json:",omitempty"`
+	PubKey  []byte   `json:",omitempty"`
+	Sign    []byte   `json:",omitempty"`
+
+	// 收到的消息的编码格式
+	wire string
+}
+
+// IsText 判断是否是文本消息
+func (cm *ChatMessage) IsText() bool {
+	return len(cm.Type) == 0 || cm.Type == CHAT_TYPE_TEXT
 }
 
 // 构建一个本地的聊天室镜像
 func JoinChatRoom(ctx context.Context, ps *pubsub.PubSub,
-	selfID peer.ID, nickname string, roomName string) (*ChatRoom, error) {
+	privKey crypto.PrivKey, nickname string, roomName string) (*ChatRoom, error) {
+	return joinTopic(ctx, ps, privKey, nickname, roomName, roomName, nil)
+}
 
-	// 加入聊天室主题
-	topic, err := ps.Join(topicName(roomName))
+// 加入一个群的聊天室，邀请群只接收成员发出的消息
+func JoinGroupChatRoom(ctx context.Context, ps *pubsub.PubSub,
+	privKey crypto.PrivKey, nickname string, group *links.Group) (*ChatRoom, error) {
+
+	var members map[string]bool
+	if group.IsInviteOnly() {
+		selfID, err := peer.IDFromPrivateKey(privKey)
+		if err != nil {
+			return nil, err
+		}
+		members, err = links.LoadGroupMembers(selfID.String(), group.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chatroom, err := joinTopic(ctx, ps, privKey, nickname, group.Name, group.Topic, members)
 	if err != nil {
 		return nil, err
 	}
+	chatroom.group = group
+	return chatroom, nil
+}
 
-	// 订阅聊天室主题
-	sub, err := topic.Subscribe()
+// JoinRoomByName 按名称加入聊天室，与我的群同名时加入群的聊天室
+func JoinRoomByName(ctx context.Context, ps *pubsub.PubSub,
+	privKey crypto.PrivKey, nickname string, myId string, name string) (*ChatRoom, error) {
+	groups, err := global.GetMyGroups(myId)
+	if err != nil {
+		log.Printf("[chatroom] load groups failed, err = %v", err)
+	}
+	for _, group := range groups {
+		if group.Name == name {
+			return JoinGroupChatRoom(ctx, ps, privKey, nickname, group)
+		}
+	}
+	return JoinChatRoom(ctx, ps, privKey, nickname, name)
+}
+
+func joinTopic(ctx context.Context, ps *pubsub.PubSub, privKey crypto.PrivKey,
+	nickname string, roomName string, topicId string, members map[string]bool) (*ChatRoom, error) {
+
+	selfID, err := peer.IDFromPrivateKey(privKey)
 	if err != nil {
 		return nil, err
 	}
@@ -48,15 +150,38 @@ func JoinChatRoom(ctx context.Context, ps *pubsub.PubSub,
 	chatroom := &ChatRoom{
 		ctx:      ctx,
 		ps:       ps,
-		topic:    topic,
-		sub:      sub,
 		self:     selfID,
+		key:      privKey,
 		nick:     nickname,
 		roomName: roomName,
+		topicId:  topicId,
+		members:  members,
+		presence: PRESENCE_ONLINE,
+		leaveCh:  make(chan struct{}),
 		Messages: make(chan *ChatMessage, ChatRoomBufSize),
 	}
 
+	// 校验消息签名，丢弃伪造、重放和非成员发出的消息，这些消息也不会被转发
+	err = ps.RegisterTopicValidator(topicName(topicId), newChatValidator(roomName, chatroom.isMember))
+	if err != nil {
+		return nil, err
+	}
+
+	// 加入聊天室主题
+	chatroom.topic, err = ps.Join(topicName(topicId))
+	if err != nil {
+		_ = ps.UnregisterTopicValidator(topicName(topicId))
+		return nil, err
+	}
+
+	// 订阅聊天室主题
+	chatroom.sub, err = chatroom.topic.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+
 	go chatroom.readLoop()
+	go chatroom.presenceLoop()
 	return chatroom, nil
 }
 
@@ -72,38 +197,211 @@ func (chatroom *ChatRoom) readLoop() {
 			close(chatroom.Messages)
 			return
 		}
-		log.Printf("[chatroom] receive msg: %s", msg.Data)
 
 		// 过滤掉自己发出的消息
 		if msg.ReceivedFrom == chatroom.self {
 			continue
 		}
 
-		// 放入消息管道
-		cm := new(ChatMessage)
-		err = json.Unmarshal(msg.Data, cm)
-		if err != nil {
+		// 放入消息管道，消息已经在校验器中解析过了
+		cm, ok := msg.ValidatorData.(*ChatMessage)
+		if !ok {
 			continue
 		}
+		log.Printf("[chatroom] receive %s msg(`%s`) from %s: %s", cm.wire, cm.Id, cm.SenderID, cm.Message)
+		chatroom.rememberFormats(cm)
+		if cm.IsText() || cm.Type == CHAT_TYPE_FILE {
+			chatroom.saveMessage(cm, history.STATE_RECEIVED)
+		}
+
 		chatroom.Messages <- cm
-		log.Printf("[chatroom] put msg(`%s`) into chan", msg.Data)
+		log.Printf("[chatroom] put msg(`%s`) into chan", cm.Id)
 	}
 }
 
-func (chatroom *ChatRoom) Publish(message string) error {
-	cm := ChatMessage{
-		Message:    message,
+// Publish 发送一条文本消息，返回消息的 id
+func (chatroom *ChatRoom) Publish(message string) (string, error) {
+	cm := chatroom.newMessage("")
+	cm.Message = message
+
+	err := chatroom.publish(cm)
+	if err != nil {
+		chatroom.saveMessage(cm, history.STATE_FAILED)
+		return "", err
+	}
+
+	chatroom.saveMessage(cm, history.STATE_SENT)
+	return cm.Id, nil
+}
+
+// SendFile 在聊天室中发出文件通知，返回消息的 id
+func (chatroom *ChatRoom) SendFile(offer *FileOffer) (string, error) {
+	cm := chatroom.newMessage(CHAT_TYPE_FILE)
+	cm.Message = fileOfferText(offer)
+	cm.File = offer
+
+	err := chatroom.publish(cm)
+	if err != nil {
+		chatroom.saveMessage(cm, history.STATE_FAILED)
+		return "", err
+	}
+
+	chatroom.saveMessage(cm, history.STATE_SENT)
+	return cm.Id, nil
+}
+
+// HasPeer 判断节点是否订阅了聊天室的主题
+func (chatroom *ChatRoom) HasPeer(pid peer.ID) bool {
+	for _, p := range chatroom.ListPeers() {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// SendTyping 通知聊天室自己正在输入
+func (chatroom *ChatRoom) SendTyping() error {
+	return chatroom.publish(chatroom.newMessage(CHAT_TYPE_TYPING))
+}
+
+// SendReadReceipt 通知聊天室自己已经读过{ids}这些消息
+func (chatroom *ChatRoom) SendReadReceipt(ids []string) error {
+	cm := chatroom.newMessage(CHAT_TYPE_READ)
+	cm.ReadIds = ids
+	return chatroom.publish(cm)
+}
+
+// SetPresence 修改自己的在线状态，并立即通知聊天室
+func (chatroom *ChatRoom) SetPresence(status string) error {
+	chatroom.mu.Lock()
+	chatroom.presence = status
+	chatroom.mu.Unlock()
+	return chatroom.sendPresence(status)
+}
+
+func (chatroom *ChatRoom) sendPresence(status string) error {
+	cm := chatroom.newMessage(CHAT_TYPE_PRESENCE)
+	cm.Status = status
+	cm.Formats = supportedWireFormats
+	return chatroom.publish(cm)
+}
+
+// presenceLoop 加入聊天室时和之后定期发送在线状态
+func (chatroom *ChatRoom) presenceLoop() {
+	ticker := time.NewTicker(PresenceInterval)
+	defer ticker.Stop()
+
+	for {
+		chatroom.mu.Lock()
+		status := chatroom.presence
+		chatroom.mu.Unlock()
+		if err := chatroom.sendPresence(status); err != nil {
+			log.Printf("[chatroom] send presence to `%s` failed, err = %v", chatroom.roomName, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-chatroom.leaveCh:
+			return
+		case <-chatroom.ctx.Done():
+			return
+		}
+	}
+}
+
+func (chatroom *ChatRoom) newMessage(msgType string) *ChatMessage {
+	chatroom.mu.Lock()
+	defer chatroom.mu.Unlock()
+
+	return &ChatMessage{
+		Id:         uuid.New().String(),
+		Type:       msgType,
 		SenderID:   chatroom.self.String(),
 		SenderNick: chatroom.nick,
+		Timestamp:  time.Now().UnixMilli(),
 	}
-	msgBytes, err := json.Marshal(cm)
+}
+
+func (chatroom *ChatRoom) publish(cm *ChatMessage) error {
+	if wireFormat == WIRE_PROTOBUF {
+		if peers := chatroom.peersWithout(WIRE_PROTOBUF); len(peers) > 0 {
+			return fmt.Errorf("%d peer(s) in `%s` can only read json: %s",
+				len(peers), chatroom.roomName, strings.Join(peers, ", "))
+		}
+	}
+
+	msgBytes, err := encodeChatMessage(chatroom.key, cm, wireFormat)
 	if err != nil {
 		return err
 	}
-
 	return chatroom.topic.Publish(chatroom.ctx, msgBytes)
 }
 
+// saveMessage 将聊天室消息写入历史记录
+func (chatroom *ChatRoom) saveMessage(cm *ChatMessage, state int32) {
+	err := history.SaveMessage(&history.Message{
+		Id:         cm.Id,
+		Owner:      chatroom.self.String(),
+		Room:       chatroom.topicId,
+		SenderID:   cm.SenderID,
+		SenderNick: cm.SenderNick,
+		Content:    cm.Message,
+		Timestamp:  cm.Timestamp,
+		State:      state,
+	})
+	if err != nil {
+		log.Printf("[chatroom] save msg(`%s`) failed, err = %v", cm.Id, err)
+	}
+}
+
+// isMember 判断{pid}发出的消息是否可以接收，邀请群只接收成员和自己发出的消息
+func (chatroom *ChatRoom) isMember(pid peer.ID) bool {
+	if pid == chatroom.self {
+		return true
+	}
+	chatroom.mu.Lock()
+	defer chatroom.mu.Unlock()
+	return chatroom.members == nil || chatroom.members[pid.String()]
+}
+
+// AddMember 邀请群增加成员后，立即接收新成员的消息
+func (chatroom *ChatRoom) AddMember(memberId string) {
+	chatroom.mu.Lock()
+	defer chatroom.mu.Unlock()
+	if chatroom.members != nil {
+		chatroom.members[memberId] = true
+	}
+}
+
 func (chatroom *ChatRoom) ListPeers() []peer.ID {
-	return chatroom.ps.ListPeers(topicName(chatroom.roomName))
+	return chatroom.topic.ListPeers()
+}
+
+// SetNick 修改聊天室消息中使用的昵称
+func (chatroom *ChatRoom) SetNick(nickname string) {
+	chatroom.mu.Lock()
+	defer chatroom.mu.Unlock()
+	chatroom.nick = nickname
+}
+
+// Leave 通知聊天室自己离线，然后取消订阅并退出聊天室主题，重复调用时返回第一次的结果
+func (chatroom *ChatRoom) Leave() error {
+	chatroom.leaveOnce.Do(func() {
+		chatroom.leaveErr = chatroom.leave()
+	})
+	return chatroom.leaveErr
+}
+
+func (chatroom *ChatRoom) leave() error {
+	close(chatroom.leaveCh)
+	if err := chatroom.sendPresence(PRESENCE_OFFLINE); err != nil {
+		log.Printf("[chatroom] send offline to `%s` failed, err = %v", chatroom.roomName, err)
+	}
+
+	chatroom.sub.Cancel()
+	if err := chatroom.topic.Close(); err != nil {
+		return err
+	}
+	return chatroom.ps.UnregisterTopicValidator(topicName(chatroom.topicId))
 }
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/global"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// command 是输入框中以 `/` 开头的命令
+type command struct {
+	name  string
+	usage string
+	help  string
+	// 命令的参数个数，maxArgs < 0 表示不限制
+	minArgs int
+	maxArgs int
+	run     func(ui *ChatUI, args []string)
+}
+
+// newCommands 返回所有的命令，/help 按照这个顺序显示
+func newCommands() []*command {
+	return []*command{
+		{name: "/help", usage: "/help", help: "show this help", run: (*ChatUI).cmdHelp},
+		{name: "/nick", usage: "/nick <name>", help: "change your nickname for this session",
+			minArgs: 1, maxArgs: 1, run: (*ChatUI).cmdNick},
+		{name: "/join", usage: "/join <room>", help: "join a room or one of your groups",
+			minArgs: 1, maxArgs: 1, run: (*ChatUI).cmdJoin},
+		{name: "/leave", usage: "/leave", help: "leave the current room", run: (*ChatUI).cmdLeave},
+		{name: "/msg", usage: "/msg <friend> <text>", help: "send a direct message to a friend",
+			minArgs: 2, maxArgs: -1, run: (*ChatUI).cmdMsg},
+		{name: "/friends", usage: "/friends", help: "list your friends", run: (*ChatUI).cmdFriends},
+		{name: "/add", usage: "/add <peer id> [nick]", help: "send a friend request",
+			minArgs: 1, maxArgs: 2, run: (*ChatUI).cmdAdd},
+		{name: "/accept", usage: "/accept <peer id or nickname>", help: "accept a friend request",
+			minArgs: 1, maxArgs: 1, run: (*ChatUI).cmdAccept},
+		{name: "/reject", usage: "/reject <peer id or nickname>", help: "reject a friend request",
+			minArgs: 1, maxArgs: 1, run: (*ChatUI).cmdReject},
+		{name: "/requests", usage: "/requests", help: "list pending friend requests and messages from strangers",
+			run: (*ChatUI).cmdRequests},
+		{name: "/allow", usage: "/allow <peer>", help: "accept queued messages from a stranger",
+			minArgs: 1, maxArgs: 1, run: (*ChatUI).cmdAllow},
+		{name: "/drop", usage: "/drop <peer>", help: "drop queued messages from a stranger",
+			minArgs: 1, maxArgs: 1, run: (*ChatUI).cmdDrop},
+		{name: "/send", usage: "/send <path> [friend]", help: "offer a file to the current room or a friend",
+			minArgs: 1, maxArgs: 2, run: (*ChatUI).cmdSend},
+		{name: "/files", usage: "/files", help: "list files offered to you", run: (*ChatUI).cmdFiles},
+		{name: "/download", usage: "/download <file id>", help: "accept and download an offered file",
+			minArgs: 1, maxArgs: 1, run: (*ChatUI).cmdDownload},
+		{name: "/peers", usage: "/peers", help: "list peers in the current room", run: (*ChatUI).cmdPeers},
+		{name: "/whois", usage: "/whois <peer>", help: "show details of a peer",
+			minArgs: 1, maxArgs: 1, run: (*ChatUI).cmdWhois},
+		{name: "/history", usage: "/history", help: "load older messages of the current room", run: (*ChatUI).cmdHistory},
+		{name: "/clear", usage: "/clear", help: "clear the current room", run: (*ChatUI).cmdClear},
+		{name: "/quit", usage: "/quit", help: "quit my-chat", run: (*ChatUI).cmdQuit},
+	}
+}
+
+// handleCommand 执行以 `/` 开头的命令，不是命令时返回 false
+func (ui *ChatUI) handleCommand(input string) bool {
+	if !strings.HasPrefix(input, "/") {
+		return false
+	}
+
+	fields := strings.Fields(input)
+	cmd := ui.findCommand(fields[0])
+	if cmd == nil {
+		ui.printError(fmt.Sprintf("unknown command `%s`, type /help for help", fields[0]))
+		return true
+	}
+
+	args := fields[1:]
+	// /msg 的消息内容保留原来的空格
+	if cmd.maxArgs < 0 && len(args) >= cmd.minArgs {
+		args = strings.SplitN(strings.TrimSpace(strings.TrimPrefix(input, fields[0])), " ", cmd.minArgs)
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		ui.printError("usage: " + cmd.usage)
+		return true
+	}
+
+	cmd.run(ui, args)
+	return true
+}
+
+func (ui *ChatUI) findCommand(name string) *command {
+	for _, cmd := range ui.commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// printInfo 在当前聊天室中显示命令的输出
+func (ui *ChatUI) printInfo(msg string) {
+	fmt.Fprintln(ui.infoW(), withColor("yellow", msg))
+}
+
+func (ui *ChatUI) printError(msg string) {
+	fmt.Fprintln(ui.infoW(), withColor("red", msg))
+}
+
+// post 把后台命令的结果交给事件循环，只有事件循环可以访问聊天室列表
+func (ui *ChatUI) post(f func()) {
+	select {
+	case ui.resultCh <- f:
+	case <-ui.ctx.Done():
+	}
+}
+
+func (ui *ChatUI) infoW() io.Writer {
+	if rv := ui.currentRoom(); rv != nil {
+		return rv.box
+	}
+	return ui.dmW
+}
+
+func (ui *ChatUI) cmdHelp(args []string) {
+	for _, cmd := range ui.commands {
+		ui.printInfo(fmt.Sprintf("%-32s %s", cmd.usage, cmd.help))
+	}
+	ui.printInfo("Tab completes commands, rooms, nicknames and peer ids; with an empty input it switches to the peers panel")
+}
+
+// cmdNick 修改本次会话中使用的昵称，账号的昵称保持不变
+func (ui *ChatUI) cmdNick(args []string) {
+	nickname := args[0]
+	ui.nick = nickname
+	for _, rv := range ui.rooms {
+		rv.room.SetNick(nickname)
+	}
+	ui.dm.SetNick(nickname)
+	ui.friends.SetNick(nickname)
+	ui.app.QueueUpdateDraw(func() {
+		ui.input.SetLabel(nickname + " > ")
+	})
+	ui.printInfo(fmt.Sprintf("you are now known as <%s>", nickname))
+}
+
+// cmdJoin 加入一个聊天室，与我的群同名时加入群的聊天室
+func (ui *ChatUI) cmdJoin(args []string) {
+	name := args[0]
+	for i, rv := range ui.rooms {
+		if rv.room.roomName == name {
+			ui.switchRoom(i - ui.current)
+			return
+		}
+	}
+
+	chatroom, err := JoinRoomByName(ui.ctx, ui.ps, ui.dm.key, ui.nick, ui.self.String(), name)
+	if err != nil {
+		ui.printError(fmt.Sprintf("join `%s` failed: %v", name, err))
+		return
+	}
+
+	ui.addRoom(chatroom)
+	ui.switchRoom(len(ui.rooms) - 1 - ui.current)
+	ui.printInfo(fmt.Sprintf("joined room `%s`", name))
+
+	if ui.discovery != nil {
+		if err = ui.discovery.AddRendezvous(chatroom.topicId); err != nil {
+			log.Printf("[ui] discover peers of `%s` failed, err = %v", name, err)
+		}
+	}
+}
+
+// cmdLeave 退出当前聊天室，群的成员关系保持不变
+func (ui *ChatUI) cmdLeave(args []string) {
+	rv := ui.currentRoom()
+	if rv == nil {
+		return
+	}
+	if len(ui.rooms) == 1 {
+		ui.printError("can not leave the last room")
+		return
+	}
+
+	if err := rv.room.Leave(); err != nil {
+		ui.printError(fmt.Sprintf("leave `%s` failed: %v", rv.room.roomName, err))
+		return
+	}
+	ui.rooms = append(ui.rooms[:ui.current], ui.rooms[ui.current+1:]...)
+	ui.app.QueueUpdateDraw(func() {
+		ui.roomPages.RemovePage(rv.room.topicId)
+	})
+	if ui.current >= len(ui.rooms) {
+		ui.current = len(ui.rooms) - 1
+	}
+	ui.switchRoom(0)
+	ui.refreshPeers()
+	ui.printInfo(fmt.Sprintf("left room `%s`", rv.room.roomName))
+}
+
+// cmdMsg 处理 `/msg <friend> <text>` 命令
+func (ui *ChatUI) cmdMsg(args []string) {
+	friend, ok := global.FindMyFriend(args[0])
+	if !ok {
+		fmt.Fprintln(ui.dmW, withColor("red", fmt.Sprintf("`%s` is not your friend", args[0])))
+		return
+	}
+
+	text := args[1]
+	go func() {
+		ack, err := ui.dm.Send(friend.Id, text)
+		if err != nil {
+			log.Printf("[ui] send direct msg failed, err = %v", err)
+			ui.post(func() {
+				fmt.Fprintln(ui.dmW, withColor("red", fmt.Sprintf("send to <%s> failed: %v", friend.Nickname, err)))
+			})
+			return
+		}
+
+		ui.post(func() {
+			prompt := withColor("yellow", fmt.Sprintf("<%s -> %s>:", ui.dm.Nick(), friend.Nickname))
+			fmt.Fprintf(ui.dmW, "%s %s (%s)\n", prompt, text, ack.Status)
+		})
+	}()
+}
+
+// cmdSend 在当前聊天室中发出文件通知，指定好友时只发给好友
+func (ui *ChatUI) cmdSend(args []string) {
+	path := args[0]
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+
+	if len(args) == 2 {
+		friend, ok := global.FindMyFriend(args[1])
+		if !ok {
+			ui.printError(fmt.Sprintf("`%s` is not your friend", args[1]))
+			return
+		}
+		friendId, err := peer.Decode(friend.Id)
+		if err != nil {
+			ui.printError(fmt.Sprintf("invalid friend id `%s`", friend.Id))
+			return
+		}
+
+		go func() {
+			offer, err := ui.files.Share(path, func(pid peer.ID) bool { return pid == friendId })
+			if err != nil {
+				ui.post(func() { ui.printError(fmt.Sprintf("share `%s` failed: %v", path, err)) })
+				return
+			}
+			ack, err := ui.dm.SendFile(friend.Id, offer)
+			if err != nil {
+				ui.post(func() {
+					fmt.Fprintln(ui.dmW, withColor("red", fmt.Sprintf("send to <%s> failed: %v", friend.Nickname, err)))
+				})
+				return
+			}
+			ui.post(func() {
+				prompt := withColor("yellow", fmt.Sprintf("<%s -> %s>:", ui.dm.Nick(), friend.Nickname))
+				fmt.Fprintf(ui.dmW, "%s %s (%s)\n", prompt, fileOfferText(offer), ack.Status)
+			})
+		}()
+		return
+	}
+
+	rv := ui.currentRoom()
+	if rv == nil {
+		return
+	}
+	go func() {
+		offer, err := ui.files.Share(path, rv.room.HasPeer)
+		if err != nil {
+			ui.post(func() { ui.printError(fmt.Sprintf("share `%s` failed: %v", path, err)) })
+			return
+		}
+		_, err = rv.room.SendFile(offer)
+		ui.post(func() {
+			if err != nil {
+				ui.printError(fmt.Sprintf("send `%s` failed: %v", offer.Name, err))
+				return
+			}
+			ui.displaySelfMessage(rv, fileOfferText(offer))
+		})
+	}()
+}
+
+// cmdFiles 列出收到的文件通知
+func (ui *ChatUI) cmdFiles(args []string) {
+	offers := ui.files.ListOffers()
+	if len(offers) == 0 {
+		ui.printInfo("no files offered to you")
+		return
+	}
+	for _, ro := range offers {
+		line := fmt.Sprintf("%s  %s  %s  from %s  %s", shortFileId(ro.Offer.Id), ro.Offer.Name,
+			formatSize(ro.Offer.Size), ui.displayName(ro.From.String()), ro.State)
+		if len(ro.Path) > 0 {
+			line += "  " + ro.Path
+		}
+		ui.printInfo(line)
+	}
+}
+
+// cmdDownload 接收文件，{args[0]}可以是文件 id 的前缀
+func (ui *ChatUI) cmdDownload(args []string) {
+	offer, err := ui.files.FindOffer(args[0])
+	if err != nil {
+		ui.printError(err.Error())
+		return
+	}
+	if err = ui.files.Download(offer.Id); err != nil {
+		ui.printError(err.Error())
+		return
+	}
+	ui.printInfo(fmt.Sprintf("downloading `%s` (%s)", offer.Name, formatSize(offer.Size)))
+}
+
+func (ui *ChatUI) cmdFriends(args []string) {
+	friends := global.ListMyFriends()
+	if len(friends) == 0 {
+		ui.printInfo("you have no friends yet, use /add to send a friend request")
+		return
+	}
+
+	for _, friend := range friends {
+		status := "offline"
+		if pid, err := peer.Decode(friend.Id); err == nil && len(ui.dm.host.Network().ConnsToPeer(pid)) > 0 {
+			status = "online"
+		}
+		ui.printInfo(fmt.Sprintf("%s %s (%s)", friend.Nickname, friend.Id, status))
+	}
+}
+
+// cmdAdd 发送好友请求，{nick}是对方成为好友后使用的昵称
+func (ui *ChatUI) cmdAdd(args []string) {
+	target, nickname := args[0], ""
+	if len(args) > 1 {
+		nickname = args[1]
+	}
+
+	go func() {
+		if _, err := ui.friends.SendRequest(target, nickname); err != nil {
+			log.Printf("[ui] /add %s failed, err = %v", target, err)
+			ui.post(func() {
+				fmt.Fprintln(ui.dmW, withColor("red", fmt.Sprintf("/add %s failed: %v", target, err)))
+			})
+			return
+		}
+		ui.post(func() {
+			fmt.Fprintln(ui.dmW, withColor("yellow", fmt.Sprintf("friend request sent to %s", target)))
+		})
+	}()
+}
+
+func (ui *ChatUI) cmdAccept(args []string) {
+	target := args[0]
+	go func() {
+		friend, err := ui.friends.Accept(target)
+		if err != nil {
+			log.Printf("[ui] /accept %s failed, err = %v", target, err)
+			ui.post(func() {
+				fmt.Fprintln(ui.dmW, withColor("red", fmt.Sprintf("/accept %s failed: %v", target, err)))
+			})
+			return
+		}
+		ui.post(func() {
+			fmt.Fprintln(ui.dmW, withColor("yellow", fmt.Sprintf("<%s> is now your friend", friend.Nickname)))
+		})
+	}()
+}
+
+func (ui *ChatUI) cmdReject(args []string) {
+	target := args[0]
+	go func() {
+		request, err := ui.friends.Reject(target)
+		if err != nil {
+			log.Printf("[ui] /reject %s failed, err = %v", target, err)
+			ui.post(func() {
+				fmt.Fprintln(ui.dmW, withColor("red", fmt.Sprintf("/reject %s failed: %v", target, err)))
+			})
+			return
+		}
+		ui.post(func() {
+			fmt.Fprintln(ui.dmW, withColor("yellow", fmt.Sprintf("rejected friend request from <%s>", request.Nickname)))
+		})
+	}()
+}
+
+func (ui *ChatUI) cmdRequests(args []string) {
+	ui.listFriendRequests()
+	ui.listDirectRequests()
+}
+
+// cmdAllow 接受陌生人发来的、缓存中的消息
+func (ui *ChatUI) cmdAllow(args []string) {
+	pid, ok := ui.resolvePeer(args[0])
+	if !ok {
+		ui.printError(fmt.Sprintf("unknown peer `%s`", args[0]))
+		return
+	}
+	msgs := ui.dm.AcceptRequests(pid)
+	fmt.Fprintln(ui.dmW, withColor("yellow", fmt.Sprintf("accepted %d message(s) from %s", len(msgs), shortID(pid))))
+}
+
+// cmdDrop 丢弃陌生人发来的、缓存中的消息
+func (ui *ChatUI) cmdDrop(args []string) {
+	pid, ok := ui.resolvePeer(args[0])
+	if !ok {
+		ui.printError(fmt.Sprintf("unknown peer `%s`", args[0]))
+		return
+	}
+	count := ui.dm.DropRequests(pid)
+	fmt.Fprintln(ui.dmW, withColor("yellow", fmt.Sprintf("dropped %d message(s) from %s", count, shortID(pid))))
+}
+
+func (ui *ChatUI) cmdPeers(args []string) {
+	rows := ui.peerRows()
+	if len(rows) == 0 {
+		ui.printInfo("no peers in this room")
+		return
+	}
+	for _, row := range rows {
+		ui.printInfo(fmt.Sprintf("%s %s", row, row.id))
+	}
+}
+
+// cmdWhois 显示节点的昵称、连接和延迟，{args[0]}可以是 id、短 id 或昵称
+func (ui *ChatUI) cmdWhois(args []string) {
+	pid, ok := ui.resolvePeer(args[0])
+	if !ok {
+		ui.printError(fmt.Sprintf("unknown peer `%s`", args[0]))
+		return
+	}
+
+	h := ui.dm.host
+	nick := ui.nicks[pid.String()]
+	friend, isFriend := global.FindMyFriend(pid.String())
+	if isFriend {
+		nick = friend.Nickname
+	}
+	ui.printInfo(fmt.Sprintf("peer:    %s", pid))
+	ui.printInfo(fmt.Sprintf("nick:    %s (friend: %v)", nick, isFriend))
+	if status := ui.peerStatus(pid.String()); len(status) > 0 {
+		ui.printInfo(fmt.Sprintf("status:  %s", status))
+	}
+
+	var rooms, formats []string
+	for _, rv := range ui.rooms {
+		for _, p := range rv.room.ListPeers() {
+			if p == pid {
+				rooms = append(rooms, rv.room.roomName)
+				break
+			}
+		}
+		if f := rv.room.PeerFormats(pid.String()); f != nil {
+			formats = f
+		}
+	}
+	ui.printInfo(fmt.Sprintf("rooms:   %s", strings.Join(rooms, ", ")))
+	if len(formats) > 0 {
+		ui.printInfo(fmt.Sprintf("formats: %s", strings.Join(formats, ", ")))
+	}
+
+	if agent, err := h.Peerstore().Get(pid, "AgentVersion"); err == nil {
+		ui.printInfo(fmt.Sprintf("agent:   %v", agent))
+	}
+	if latency := h.Peerstore().LatencyEWMA(pid); latency > 0 {
+		ui.printInfo(fmt.Sprintf("latency: %dms", latency.Milliseconds()))
+	}
+	for _, conn := range h.Network().ConnsToPeer(pid) {
+		ui.printInfo(fmt.Sprintf("conn:    %s %s %s", connDirection(conn), connTransport(conn), conn.RemoteMultiaddr()))
+	}
+}
+
+// resolvePeer 按照 id、短 id、好友昵称或者从消息中得知的昵称查找节点
+func (ui *ChatUI) resolvePeer(name string) (peer.ID, bool) {
+	if pid, err := peer.Decode(name); err == nil {
+		return pid, true
+	}
+	if friend, ok := global.FindMyFriend(name); ok {
+		if pid, err := peer.Decode(friend.Id); err == nil {
+			return pid, true
+		}
+	}
+	for id, nick := range ui.nicks {
+		if nick == name {
+			if pid, err := peer.Decode(id); err == nil {
+				return pid, true
+			}
+		}
+	}
+	for _, pid := range ui.dm.host.Network().Peers() {
+		if shortID(pid) == name {
+			return pid, true
+		}
+	}
+	return "", false
+}
+
+func (ui *ChatUI) cmdHistory(args []string) {
+	ui.loadHistory(PANE_ROOM)
+}
+
+func (ui *ChatUI) cmdClear(args []string) {
+	if rv := ui.currentRoom(); rv != nil {
+		rv.box.Clear()
+		// 清屏后 /history 从最近的消息开始重新加载
+		rv.oldest = time.Now().UnixMilli()
+	}
+}
+
+func (ui *ChatUI) cmdQuit(args []string) {
+	ui.app.Stop()
+}
+
+// complete 补全输入框中的最后一个词：命令名、聊天室名、昵称或者节点 id
+func (ui *ChatUI) complete(text string) (string, []string) {
+	fields := strings.Split(text, " ")
+	last := fields[len(fields)-1]
+
+	var candidates []string
+	if len(fields) == 1 {
+		if !strings.HasPrefix(last, "/") {
+			candidates = ui.nickCandidates()
+		}
+		for _, cmd := range ui.commands {
+			candidates = append(candidates, cmd.name)
+		}
+	} else {
+		switch fields[0] {
+		case "/join":
+			candidates = ui.roomCandidates()
+		case "/msg":
+			for _, friend := range global.ListMyFriends() {
+				candidates = append(candidates, friend.Nickname)
+			}
+		case "/download":
+			for _, ro := range ui.files.ListOffers() {
+				candidates = append(candidates, shortFileId(ro.Offer.Id))
+			}
+		default:
+			candidates = append(ui.nickCandidates(), ui.peerCandidates()...)
+		}
+	}
+
+	matches := matchPrefix(candidates, last)
+	switch len(matches) {
+	case 0:
+		return text, nil
+	case 1:
+		fields[len(fields)-1] = matches[0]
+		return strings.Join(fields, " ") + " ", nil
+	default:
+		fields[len(fields)-1] = commonPrefix(matches)
+		return strings.Join(fields, " "), matches
+	}
+}
+
+func (ui *ChatUI) roomCandidates() []string {
+	var rooms []string
+	for _, rv := range ui.rooms {
+		rooms = append(rooms, rv.room.roomName)
+	}
+	groups, err := global.GetMyGroups(ui.self.String())
+	if err == nil {
+		for _, group := range groups {
+			rooms = append(rooms, group.Name)
+		}
+	}
+	return rooms
+}
+
+func (ui *ChatUI) nickCandidates() []string {
+	var nicks []string
+	for _, nick := range ui.nicks {
+		nicks = append(nicks, nick)
+	}
+	for _, friend := range global.ListMyFriends() {
+		nicks = append(nicks, friend.Nickname)
+	}
+	return nicks
+}
+
+func (ui *ChatUI) peerCandidates() []string {
+	var peers []string
+	for _, pid := range ui.dm.host.Network().Peers() {
+		peers = append(peers, pid.String())
+	}
+	return peers
+}
+
+// matchPrefix 返回以{prefix}开头的候选项，去重并排序
+func matchPrefix(candidates []string, prefix string) []string {
+	seen := make(map[string]bool)
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) && !seen[candidate] {
+			seen[candidate] = true
+			matches = append(matches, candidate)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
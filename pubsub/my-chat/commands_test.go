@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCompleteCommand(t *testing.T) {
+	ui := &ChatUI{commands: newCommands()}
+
+	completed, matches := ui.complete("/jo")
+	assert.Equal(t, "/join ", completed)
+	assert.Empty(t, matches)
+
+	// 多个候选项时补全到公共前缀
+	completed, matches = ui.complete("/h")
+	assert.Equal(t, "/h", completed)
+	assert.Equal(t, []string{"/help", "/history"}, matches)
+
+	completed, matches = ui.complete("/xyz")
+	assert.Equal(t, "/xyz", completed)
+	assert.Empty(t, matches)
+}
+
+func TestCommonPrefix(t *testing.T) {
+	assert.Equal(t, "/re", commonPrefix([]string{"/reject", "/requests"}))
+	// 按字符而不是字节截断
+	assert.Equal(t, "小", commonPrefix([]string{"小明", "小红"}))
+}
@@ -2,11 +2,14 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/czh0526/libp2p-examples/pubsub/my-chat/common"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 const (
@@ -14,12 +17,36 @@ const (
 )
 
 type Settings struct {
-	Timestamp string   `json:"timestamp"`
+	Timestamp string `json:"timestamp"`
+	// 用户配置的引导节点，与默认节点一起使用
 	Bootstrap []string `json:"bootstrap"`
+	// 运行时从 DHT 学到的引导节点，与用户配置的分开保存
+	LearnedBootstrap []string `json:"learnedBootstrap,omitempty"`
+	// 数据库文件和私钥目录，相对路径相对于主目录
+	DBPath string `json:"dbPath,omitempty"`
+	KeyDir string `json:"keyDir,omitempty"`
+	// 收发文件的大小上限（字节），为 0 时使用默认值
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
+	// 接收文件的目录，相对路径相对于主目录
+	DownloadDir string `json:"downloadDir,omitempty"`
+}
+
+// 默认的文件大小上限
+const DefaultMaxFileSize = 100 * 1024 * 1024
+
+// FileSizeLimit 返回收发文件的大小上限
+func (s *Settings) FileSizeLimit() int64 {
+	if s.MaxFileSize > 0 {
+		return s.MaxFileSize
+	}
+	return DefaultMaxFileSize
 }
 
 func getSettingsFile() (string, error) {
-	return filepath.Join(HomeDir, "data", SettingFilename), nil
+	if len(HomeDir) == 0 {
+		return "", fmt.Errorf("home directory is not initialized")
+	}
+	return filepath.Join(HomeDir, DefaultDataDir, SettingFilename), nil
 }
 
 func LoadSettings() (*Settings, error) {
@@ -29,16 +56,20 @@ func LoadSettings() (*Settings, error) {
 		return nil, err
 	}
 
-	// 读文件
+	settings := Settings{
+		Timestamp: common.GetTimestampString(time.Now()),
+		Bootstrap: []string{},
+	}
+
+	// 读文件，文件不存在时使用默认配置
 	content, err := os.ReadFile(settingsFilename)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return &settings, nil
+		}
 		return nil, err
 	}
 
-	settings := Settings{
-		Timestamp: common.GetTimestampString(time.Now()),
-		Bootstrap: []string{},
-	}
 	if len(content) > 0 {
 		err = json.Unmarshal(content, &settings)
 		if err != nil {
@@ -66,3 +97,108 @@ func SaveSettings(settings *Settings) error {
 
 	return nil
 }
+
+// 最多记录的学到的引导节点数，超出时丢弃最早学到的
+const MaxBootstrapPeers = 16
+
+// AddBootstrap 添加一个引导节点，地址必须包含 /p2p/<peer id>。
+// 已经存在时返回 false。
+func (s *Settings) AddBootstrap(address string) (bool, error) {
+	_, addr, err := parseBootstrap(address)
+	if err != nil {
+		return false, err
+	}
+
+	if contains(s.Bootstrap, addr) {
+		return false, nil
+	}
+	s.Bootstrap = append(s.Bootstrap, addr)
+	return true, nil
+}
+
+// LearnBootstrap 记住一个学到的引导节点，已经配置过或者学到过时返回 false
+func (s *Settings) LearnBootstrap(address string) (bool, error) {
+	_, addr, err := parseBootstrap(address)
+	if err != nil {
+		return false, err
+	}
+
+	if contains(s.Bootstrap, addr) || contains(s.LearnedBootstrap, addr) {
+		return false, nil
+	}
+	if len(s.LearnedBootstrap) >= MaxBootstrapPeers {
+		s.LearnedBootstrap = s.LearnedBootstrap[len(s.LearnedBootstrap)-MaxBootstrapPeers+1:]
+	}
+	s.LearnedBootstrap = append(s.LearnedBootstrap, addr)
+	return true, nil
+}
+
+// RemoveBootstrap 删除引导节点，{address}可以是完整的地址，也可以是节点 id（删除该节点的所有地址）。
+// 配置的和学到的节点都会被删除，返回删除的条数。
+func (s *Settings) RemoveBootstrap(address string) int {
+	var removed int
+	s.Bootstrap, removed = removeBootstrap(s.Bootstrap, address)
+	if s.Bootstrap == nil {
+		s.Bootstrap = []string{}
+	}
+
+	var learned int
+	s.LearnedBootstrap, learned = removeBootstrap(s.LearnedBootstrap, address)
+	return removed + learned
+}
+
+func removeBootstrap(addresses []string, address string) ([]string, int) {
+	pid, pidErr := peer.Decode(address)
+	_, addr, addrErr := parseBootstrap(address)
+
+	var remain []string
+	for _, existing := range addresses {
+		if addrErr == nil && existing == addr {
+			continue
+		}
+		if pidErr == nil {
+			if info, _, err := parseBootstrap(existing); err == nil && info.ID == pid {
+				continue
+			}
+		}
+		remain = append(remain, existing)
+	}
+	return remain, len(addresses) - len(remain)
+}
+
+// BootstrapPeers 解析配置的和学到的引导节点，忽略格式错误和重复的地址
+func (s *Settings) BootstrapPeers() []peer.AddrInfo {
+	var peers []peer.AddrInfo
+	seen := make(map[string]bool)
+	for _, address := range append(append([]string(nil), s.Bootstrap...), s.LearnedBootstrap...) {
+		info, addr, err := parseBootstrap(address)
+		if err != nil || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		peers = append(peers, *info)
+	}
+	return peers
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBootstrap 解析引导节点地址，返回节点信息和规范化后的地址
+func parseBootstrap(address string) (*peer.AddrInfo, string, error) {
+	addr, err := ma.NewMultiaddr(address)
+	if err != nil {
+		return nil, "", fmt.Errorf("malformed multiaddr `%s`, err = %v", address, err)
+	}
+	info, err := peer.AddrInfoFromP2pAddr(addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("multiaddr `%s` must end with /p2p/<peer id>, err = %v", address, err)
+	}
+	return info, addr.String(), nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testPeer = "QmWiG7ExhxNokqzghHrxC25m3W8gVEftgcrZsJKhPv1Y74"
+
+func bootstrapAddr(i int) string {
+	return fmt.Sprintf("/ip4/1.2.3.%d/tcp/4001/p2p/%s", i, testPeer)
+}
+
+func TestLearnBootstrap(t *testing.T) {
+	s := &Settings{Bootstrap: []string{bootstrapAddr(0)}}
+
+	// 学到的节点不会改变用户的配置
+	ok, err := s.LearnBootstrap(bootstrapAddr(1))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{bootstrapAddr(0)}, s.Bootstrap)
+	assert.Equal(t, []string{bootstrapAddr(1)}, s.LearnedBootstrap)
+
+	// 已经配置过的节点不再记录
+	ok, err = s.LearnBootstrap(bootstrapAddr(0))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// 超出上限时丢弃最早学到的
+	for i := 2; i <= MaxBootstrapPeers+1; i++ {
+		_, err = s.LearnBootstrap(bootstrapAddr(i))
+		assert.NoError(t, err)
+	}
+	assert.Len(t, s.LearnedBootstrap, MaxBootstrapPeers)
+	assert.Equal(t, bootstrapAddr(2), s.LearnedBootstrap[0])
+	assert.Len(t, s.BootstrapPeers(), MaxBootstrapPeers+1)
+}
+
+func TestRemoveBootstrap(t *testing.T) {
+	s := &Settings{Bootstrap: []string{bootstrapAddr(0)}, LearnedBootstrap: []string{bootstrapAddr(1)}}
+	assert.Equal(t, 1, s.RemoveBootstrap(bootstrapAddr(1)))
+	assert.Empty(t, s.LearnedBootstrap)
+
+	_, err := s.LearnBootstrap(bootstrapAddr(1))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, s.RemoveBootstrap(testPeer))
+	assert.Equal(t, []string{}, s.Bootstrap)
+}
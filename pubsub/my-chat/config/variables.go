@@ -2,32 +2,174 @@ package config
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
-func init() {
-	pwd, err := os.Getwd()
+// 环境变量，优先级低于命令行参数，高于配置文件
+const (
+	ENV_HOME    = "MY_CHAT_HOME"
+	ENV_DB      = "MY_CHAT_DB"
+	ENV_KEY_DIR = "MY_CHAT_KEY_DIR"
+	// 无界面模式下 HTTP 接口的访问令牌
+	ENV_API_TOKEN = "MY_CHAT_API_TOKEN"
+)
+
+// 主目录下的默认布局，与早期直接在源码目录下运行时的布局一致
+const (
+	DefaultDataDir = "data"
+	DefaultKeyDir  = "data/account"
+	DefaultDBPath  = "db/my-chat.db"
+	// 接收文件的目录，第一次接收文件时创建
+	DefaultDownloadDir = "downloads"
+)
+
+var (
+	// 主目录，存放配置文件，默认的数据库和私钥也在这里
+	HomeDir string
+	// 数据库文件
+	DBPath string
+	// 私钥目录
+	KeyDir string
+	// 接收文件的目录
+	DownloadDir string
+
+	initMu      sync.Mutex
+	initialized bool
+)
+
+// Options 是命令行参数中与目录相关的部分，为空表示未指定
+type Options struct {
+	HomeDir string
+	DBPath  string
+	KeyDir  string
+}
+
+// Init 确定主目录、数据库和私钥目录，并创建需要的目录。
+// 每一项的优先级：命令行参数 > 环境变量 > 配置文件 > 默认值。
+// 主目录本身不能由配置文件指定，默认是 $XDG_DATA_HOME/my-chat。
+func Init(opts *Options) error {
+	initMu.Lock()
+	defer initMu.Unlock()
+
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	home := firstNonEmpty(opts.HomeDir, os.Getenv(ENV_HOME))
+	if len(home) == 0 {
+		var err error
+		if home, err = DefaultHomeDir(); err != nil {
+			return err
+		}
+		// 早期版本把数据放在运行目录下，默认主目录还没有数据时继续使用
+		if legacy, ok := legacyHomeDir(home); ok {
+			log.Printf("[config] found data of old version in `%s`, use it as home directory; "+
+				"move `%s` and `%s` into `%s` to migrate", legacy, DefaultDBPath, DefaultKeyDir, home)
+			home = legacy
+		}
+	}
+	home, err := filepath.Abs(home)
 	if err != nil {
-		panic("cannot get current working directory")
+		return fmt.Errorf("resolve home directory `%s` failed, err = %v", home, err)
 	}
-	HomeDir = pwd
+	if err = createDir(filepath.Join(home, DefaultDataDir)); err != nil {
+		return err
+	}
+	HomeDir = home
 
-	if err = createDir(AccountDir); err != nil {
-		panic(err)
+	settings, err := LoadSettings()
+	if err != nil {
+		return fmt.Errorf("load settings failed, err = %v", err)
 	}
-	if err = createDir(FriendsDir); err != nil {
-		panic(err)
+
+	DBPath = resolvePath(home, firstNonEmpty(opts.DBPath, os.Getenv(ENV_DB), settings.DBPath, DefaultDBPath))
+	KeyDir = resolvePath(home, firstNonEmpty(opts.KeyDir, os.Getenv(ENV_KEY_DIR), settings.KeyDir, DefaultKeyDir))
+	DownloadDir = resolvePath(home, firstNonEmpty(settings.DownloadDir, DefaultDownloadDir))
+
+	if err = createDir(filepath.Dir(DBPath)); err != nil {
+		return err
 	}
-	if err = createDir(GroupsDir); err != nil {
-		panic(err)
+	if err = createDir(KeyDir); err != nil {
+		return err
 	}
+
+	initialized = true
+	return nil
+}
+
+// Ensure 在没有调用过 Init 时，只使用环境变量和默认值完成初始化
+func Ensure() error {
+	initMu.Lock()
+	done := initialized
+	initMu.Unlock()
+
+	if done {
+		return nil
+	}
+	return Init(nil)
+}
+
+// DefaultHomeDir 返回 XDG 风格的默认主目录
+func DefaultHomeDir() (string, error) {
+	if dataHome := os.Getenv("XDG_DATA_HOME"); len(dataHome) > 0 {
+		return filepath.Join(dataHome, "my-chat"), nil
+	}
+
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find user home directory, err = %v", err)
+	}
+	return filepath.Join(userHome, ".local", "share", "my-chat"), nil
+}
+
+// legacyHomeDir 查找早期版本的数据：运行目录下的 db/my-chat.db 或者 data/account 中的私钥。
+// 早期的布局与主目录下的默认布局相同，所以可以直接把运行目录当作主目录。
+// {home}中已经有数据时不再使用旧的数据。
+func legacyHomeDir(home string) (string, bool) {
+	if hasData(home) {
+		return "", false
+	}
+	cwd, err := os.Getwd()
+	if err != nil || cwd == home {
+		return "", false
+	}
+	return cwd, hasData(cwd)
+}
+
+// hasData 判断{dir}中是否有默认布局的数据库或者私钥
+func hasData(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, DefaultDBPath)); err == nil {
+		return true
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, DefaultKeyDir))
+	return err == nil && len(entries) > 0
+}
+
+// resolvePath 相对路径相对于主目录
+func resolvePath(home string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(home, path)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if len(v) > 0 {
+			return v
+		}
+	}
+	return ""
 }
 
 func createDir(path string) error {
 	_, err := os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			err = os.MkdirAll(path, os.ModePerm)
+			err = os.MkdirAll(path, 0700)
 			if err != nil {
 				return fmt.Errorf("create directory `%s` failed, err = %v", path, err)
 			}
@@ -38,10 +180,3 @@ func createDir(path string) error {
 
 	return nil
 }
-
-var (
-	HomeDir    string
-	AccountDir = "data/account"
-	FriendsDir = "data/friends"
-	GroupsDir  = "data/groups"
-)
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chdir 切换运行目录，测试结束后恢复
+func chdir(t *testing.T, dir string) {
+	cwd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { _ = os.Chdir(cwd) })
+}
+
+func writeFile(t *testing.T, path string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	assert.NoError(t, os.WriteFile(path, []byte("data"), 0600))
+}
+
+func initDefault(t *testing.T) string {
+	dataHome := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataHome)
+	t.Setenv(ENV_HOME, "")
+	t.Setenv(ENV_DB, "")
+	t.Setenv(ENV_KEY_DIR, "")
+	return filepath.Join(dataHome, "my-chat")
+}
+
+func TestInitUsesLegacyLayout(t *testing.T) {
+	defaultHome := initDefault(t)
+	legacy, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NoError(t, err)
+	writeFile(t, filepath.Join(legacy, "data", "account", "key"))
+	chdir(t, legacy)
+
+	assert.NoError(t, Init(nil))
+	assert.Equal(t, legacy, HomeDir)
+	assert.Equal(t, filepath.Join(legacy, "db", "my-chat.db"), DBPath)
+	assert.Equal(t, filepath.Join(legacy, "data", "account"), KeyDir)
+
+	// 默认主目录已经有数据时，不再使用运行目录下的数据
+	writeFile(t, filepath.Join(defaultHome, DefaultDBPath))
+	assert.NoError(t, Init(nil))
+	assert.Equal(t, defaultHome, HomeDir)
+}
+
+func TestInitWithoutLegacyLayout(t *testing.T) {
+	defaultHome := initDefault(t)
+	chdir(t, t.TempDir())
+
+	assert.NoError(t, Init(nil))
+	assert.Equal(t, defaultHome, HomeDir)
+	assert.Equal(t, filepath.Join(defaultHome, DefaultDBPath), DBPath)
+}
@@ -30,6 +30,7 @@ func newFriend(db *gorm.DB, opts ...gen.DOOption) friend {
 	_friend.ID = field.NewString(tableName, "id")
 	_friend.Nickname = field.NewString(tableName, "nickname")
 	_friend.Owner = field.NewString(tableName, "owner")
+	_friend.PubKey = field.NewBytes(tableName, "pub_key")
 
 	_friend.fillFieldMap()
 
@@ -43,6 +44,7 @@ type friend struct {
 	ID       field.String
 	Nickname field.String
 	Owner    field.String
+	PubKey   field.Bytes
 
 	fieldMap map[string]field.Expr
 }
@@ -62,6 +64,7 @@ func (f *friend) updateTableName(table string) *friend {
 	f.ID = field.NewString(table, "id")
 	f.Nickname = field.NewString(table, "nickname")
 	f.Owner = field.NewString(table, "owner")
+	f.PubKey = field.NewBytes(table, "pub_key")
 
 	f.fillFieldMap()
 
@@ -86,10 +89,11 @@ func (f *friend) GetFieldByName(fieldName string) (field.OrderExpr, bool) {
 }
 
 func (f *friend) fillFieldMap() {
-	f.fieldMap = make(map[string]field.Expr, 3)
+	f.fieldMap = make(map[string]field.Expr, 4)
 	f.fieldMap["id"] = f.ID
 	f.fieldMap["nickname"] = f.Nickname
 	f.fieldMap["owner"] = f.Owner
+	f.fieldMap["pub_key"] = f.PubKey
 }
 
 func (f friend) clone(db *gorm.DB) friend {
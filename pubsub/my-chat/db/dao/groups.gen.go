@@ -31,6 +31,7 @@ func newGroup(db *gorm.DB, opts ...gen.DOOption) group {
 	_group.Name = field.NewString(tableName, "name")
 	_group.Type = field.NewInt32(tableName, "type")
 	_group.Owner = field.NewString(tableName, "owner")
+	_group.Topic = field.NewString(tableName, "topic")
 
 	_group.fillFieldMap()
 
@@ -45,6 +46,7 @@ type group struct {
 	Name  field.String
 	Type  field.Int32
 	Owner field.String
+	Topic field.String
 
 	fieldMap map[string]field.Expr
 }
@@ -65,6 +67,7 @@ func (g *group) updateTableName(table string) *group {
 	g.Name = field.NewString(table, "name")
 	g.Type = field.NewInt32(table, "type")
 	g.Owner = field.NewString(table, "owner")
+	g.Topic = field.NewString(table, "topic")
 
 	g.fillFieldMap()
 
@@ -89,11 +92,12 @@ func (g *group) GetFieldByName(fieldName string) (field.OrderExpr, bool) {
 }
 
 func (g *group) fillFieldMap() {
-	g.fieldMap = make(map[string]field.Expr, 4)
+	g.fieldMap = make(map[string]field.Expr, 5)
 	g.fieldMap["id"] = g.ID
 	g.fieldMap["name"] = g.Name
 	g.fieldMap["type"] = g.Type
 	g.fieldMap["owner"] = g.Owner
+	g.fieldMap["topic"] = g.Topic
 }
 
 func (g group) clone(db *gorm.DB) group {
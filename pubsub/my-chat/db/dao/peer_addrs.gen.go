@@ -0,0 +1,339 @@
+// Code generated by gorm.io/gen. DO NOT EDIT.
+// Code generated by gorm.io/gen. DO NOT EDIT.
+// Code generated by gorm.io/gen. DO NOT EDIT.
+
+package dao
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"gorm.io/gen"
+	"gorm.io/gen/field"
+
+	"gorm.io/plugin/dbresolver"
+
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/db/model"
+)
+
+func newPeerAddr(db *gorm.DB, opts ...gen.DOOption) peerAddr {
+	_peerAddr := peerAddr{}
+
+	_peerAddr.peerAddrDo.UseDB(db, opts...)
+	_peerAddr.peerAddrDo.UseModel(&model.PeerAddr{})
+
+	tableName := _peerAddr.peerAddrDo.TableName()
+	_peerAddr.ALL = field.NewAsterisk(tableName)
+	_peerAddr.Owner = field.NewString(tableName, "owner")
+	_peerAddr.PeerID = field.NewString(tableName, "peer_id")
+	_peerAddr.Addrs = field.NewString(tableName, "addrs")
+	_peerAddr.LastSeen = field.NewInt64(tableName, "last_seen")
+
+	_peerAddr.fillFieldMap()
+
+	return _peerAddr
+}
+
+type peerAddr struct {
+	peerAddrDo peerAddrDo
+
+	ALL      field.Asterisk
+	Owner    field.String
+	PeerID   field.String
+	Addrs    field.String
+	LastSeen field.Int64
+
+	fieldMap map[string]field.Expr
+}
+
+func (p peerAddr) Table(newTableName string) *peerAddr {
+	p.peerAddrDo.UseTable(newTableName)
+	return p.updateTableName(newTableName)
+}
+
+func (p peerAddr) As(alias string) *peerAddr {
+	p.peerAddrDo.DO = *(p.peerAddrDo.As(alias).(*gen.DO))
+	return p.updateTableName(alias)
+}
+
+func (p *peerAddr) updateTableName(table string) *peerAddr {
+	p.ALL = field.NewAsterisk(table)
+	p.Owner = field.NewString(table, "owner")
+	p.PeerID = field.NewString(table, "peer_id")
+	p.Addrs = field.NewString(table, "addrs")
+	p.LastSeen = field.NewInt64(table, "last_seen")
+
+	p.fillFieldMap()
+
+	return p
+}
+
+func (p *peerAddr) WithContext(ctx context.Context) *peerAddrDo { return p.peerAddrDo.WithContext(ctx) }
+
+func (p peerAddr) TableName() string { return p.peerAddrDo.TableName() }
+
+func (p peerAddr) Alias() string { return p.peerAddrDo.Alias() }
+
+func (p peerAddr) Columns(cols ...field.Expr) gen.Columns { return p.peerAddrDo.Columns(cols...) }
+
+func (p *peerAddr) GetFieldByName(fieldName string) (field.OrderExpr, bool) {
+	_f, ok := p.fieldMap[fieldName]
+	if !ok || _f == nil {
+		return nil, false
+	}
+	_oe, ok := _f.(field.OrderExpr)
+	return _oe, ok
+}
+
+func (p *peerAddr) fillFieldMap() {
+	p.fieldMap = make(map[string]field.Expr, 4)
+	p.fieldMap["owner"] = p.Owner
+	p.fieldMap["peer_id"] = p.PeerID
+	p.fieldMap["addrs"] = p.Addrs
+	p.fieldMap["last_seen"] = p.LastSeen
+}
+
+func (p peerAddr) clone(db *gorm.DB) peerAddr {
+	p.peerAddrDo.ReplaceConnPool(db.Statement.ConnPool)
+	return p
+}
+
+func (p peerAddr) replaceDB(db *gorm.DB) peerAddr {
+	p.peerAddrDo.ReplaceDB(db)
+	return p
+}
+
+type peerAddrDo struct{ gen.DO }
+
+func (p peerAddrDo) Debug() *peerAddrDo {
+	return p.withDO(p.DO.Debug())
+}
+
+func (p peerAddrDo) WithContext(ctx context.Context) *peerAddrDo {
+	return p.withDO(p.DO.WithContext(ctx))
+}
+
+func (p peerAddrDo) ReadDB() *peerAddrDo {
+	return p.Clauses(dbresolver.Read)
+}
+
+func (p peerAddrDo) WriteDB() *peerAddrDo {
+	return p.Clauses(dbresolver.Write)
+}
+
+func (p peerAddrDo) Session(config *gorm.Session) *peerAddrDo {
+	return p.withDO(p.DO.Session(config))
+}
+
+func (p peerAddrDo) Clauses(conds ...clause.Expression) *peerAddrDo {
+	return p.withDO(p.DO.Clauses(conds...))
+}
+
+func (p peerAddrDo) Returning(value interface{}, columns ...string) *peerAddrDo {
+	return p.withDO(p.DO.Returning(value, columns...))
+}
+
+func (p peerAddrDo) Not(conds ...gen.Condition) *peerAddrDo {
+	return p.withDO(p.DO.Not(conds...))
+}
+
+func (p peerAddrDo) Or(conds ...gen.Condition) *peerAddrDo {
+	return p.withDO(p.DO.Or(conds...))
+}
+
+func (p peerAddrDo) Select(conds ...field.Expr) *peerAddrDo {
+	return p.withDO(p.DO.Select(conds...))
+}
+
+func (p peerAddrDo) Where(conds ...gen.Condition) *peerAddrDo {
+	return p.withDO(p.DO.Where(conds...))
+}
+
+func (p peerAddrDo) Order(conds ...field.Expr) *peerAddrDo {
+	return p.withDO(p.DO.Order(conds...))
+}
+
+func (p peerAddrDo) Distinct(cols ...field.Expr) *peerAddrDo {
+	return p.withDO(p.DO.Distinct(cols...))
+}
+
+func (p peerAddrDo) Omit(cols ...field.Expr) *peerAddrDo {
+	return p.withDO(p.DO.Omit(cols...))
+}
+
+func (p peerAddrDo) Join(table schema.Tabler, on ...field.Expr) *peerAddrDo {
+	return p.withDO(p.DO.Join(table, on...))
+}
+
+func (p peerAddrDo) LeftJoin(table schema.Tabler, on ...field.Expr) *peerAddrDo {
+	return p.withDO(p.DO.LeftJoin(table, on...))
+}
+
+func (p peerAddrDo) RightJoin(table schema.Tabler, on ...field.Expr) *peerAddrDo {
+	return p.withDO(p.DO.RightJoin(table, on...))
+}
+
+func (p peerAddrDo) Group(cols ...field.Expr) *peerAddrDo {
+	return p.withDO(p.DO.Group(cols...))
+}
+
+func (p peerAddrDo) Having(conds ...gen.Condition) *peerAddrDo {
+	return p.withDO(p.DO.Having(conds...))
+}
+
+func (p peerAddrDo) Limit(limit int) *peerAddrDo {
+	return p.withDO(p.DO.Limit(limit))
+}
+
+func (p peerAddrDo) Offset(offset int) *peerAddrDo {
+	return p.withDO(p.DO.Offset(offset))
+}
+
+func (p peerAddrDo) Scopes(funcs ...func(gen.Dao) gen.Dao) *peerAddrDo {
+	return p.withDO(p.DO.Scopes(funcs...))
+}
+
+func (p peerAddrDo) Unscoped() *peerAddrDo {
+	return p.withDO(p.DO.Unscoped())
+}
+
+func (p peerAddrDo) Create(values ...*model.PeerAddr) error {
+	if len(values) == 0 {
+		return nil
+	}
+	return p.DO.Create(values)
+}
+
+func (p peerAddrDo) CreateInBatches(values []*model.PeerAddr, batchSize int) error {
+	return p.DO.CreateInBatches(values, batchSize)
+}
+
+// Save : !!! underlying implementation is different with GORM
+// The method is equivalent to executing the statement: db.Clauses(clause.OnConflict{UpdateAll: true}).Create(values)
+func (p peerAddrDo) Save(values ...*model.PeerAddr) error {
+	if len(values) == 0 {
+		return nil
+	}
+	return p.DO.Save(values)
+}
+
+func (p peerAddrDo) First() (*model.PeerAddr, error) {
+	if result, err := p.DO.First(); err != nil {
+		return nil, err
+	} else {
+		return result.(*model.PeerAddr), nil
+	}
+}
+
+func (p peerAddrDo) Take() (*model.PeerAddr, error) {
+	if result, err := p.DO.Take(); err != nil {
+		return nil, err
+	} else {
+		return result.(*model.PeerAddr), nil
+	}
+}
+
+func (p peerAddrDo) Last() (*model.PeerAddr, error) {
+	if result, err := p.DO.Last(); err != nil {
+		return nil, err
+	} else {
+		return result.(*model.PeerAddr), nil
+	}
+}
+
+func (p peerAddrDo) Find() ([]*model.PeerAddr, error) {
+	result, err := p.DO.Find()
+	return result.([]*model.PeerAddr), err
+}
+
+func (p peerAddrDo) FindInBatch(batchSize int, fc func(tx gen.Dao, batch int) error) (results []*model.PeerAddr, err error) {
+	buf := make([]*model.PeerAddr, 0, batchSize)
+	err = p.DO.FindInBatches(&buf, batchSize, func(tx gen.Dao, batch int) error {
+		defer func() { results = append(results, buf...) }()
+		return fc(tx, batch)
+	})
+	return results, err
+}
+
+func (p peerAddrDo) FindInBatches(result *[]*model.PeerAddr, batchSize int, fc func(tx gen.Dao, batch int) error) error {
+	return p.DO.FindInBatches(result, batchSize, fc)
+}
+
+func (p peerAddrDo) Attrs(attrs ...field.AssignExpr) *peerAddrDo {
+	return p.withDO(p.DO.Attrs(attrs...))
+}
+
+func (p peerAddrDo) Assign(attrs ...field.AssignExpr) *peerAddrDo {
+	return p.withDO(p.DO.Assign(attrs...))
+}
+
+func (p peerAddrDo) Joins(fields ...field.RelationField) *peerAddrDo {
+	for _, _f := range fields {
+		p = *p.withDO(p.DO.Joins(_f))
+	}
+	return &p
+}
+
+func (p peerAddrDo) Preload(fields ...field.RelationField) *peerAddrDo {
+	for _, _f := range fields {
+		p = *p.withDO(p.DO.Preload(_f))
+	}
+	return &p
+}
+
+func (p peerAddrDo) FirstOrInit() (*model.PeerAddr, error) {
+	if result, err := p.DO.FirstOrInit(); err != nil {
+		return nil, err
+	} else {
+		return result.(*model.PeerAddr), nil
+	}
+}
+
+func (p peerAddrDo) FirstOrCreate() (*model.PeerAddr, error) {
+	if result, err := p.DO.FirstOrCreate(); err != nil {
+		return nil, err
+	} else {
+		return result.(*model.PeerAddr), nil
+	}
+}
+
+func (p peerAddrDo) FindByPage(offset int, limit int) (result []*model.PeerAddr, count int64, err error) {
+	result, err = p.Offset(offset).Limit(limit).Find()
+	if err != nil {
+		return
+	}
+
+	if size := len(result); 0 < limit && 0 < size && size < limit {
+		count = int64(size + offset)
+		return
+	}
+
+	count, err = p.Offset(-1).Limit(-1).Count()
+	return
+}
+
+func (p peerAddrDo) ScanByPage(result interface{}, offset int, limit int) (count int64, err error) {
+	count, err = p.Count()
+	if err != nil {
+		return
+	}
+
+	err = p.Offset(offset).Limit(limit).Scan(result)
+	return
+}
+
+func (p peerAddrDo) Scan(result interface{}) (err error) {
+	return p.DO.Scan(result)
+}
+
+func (p peerAddrDo) Delete(models ...*model.PeerAddr) (result gen.ResultInfo, err error) {
+	return p.DO.Delete(models)
+}
+
+func (p *peerAddrDo) withDO(do gen.Dao) *peerAddrDo {
+	p.DO = *do.(*gen.DO)
+	return p
+}
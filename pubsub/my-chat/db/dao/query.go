@@ -17,29 +17,41 @@ import (
 
 func Use(db *gorm.DB, opts ...gen.DOOption) *Query {
 	return &Query{
-		db:      db,
-		Account: newAccount(db, opts...),
-		Friend:  newFriend(db, opts...),
-		Group:   newGroup(db, opts...),
+		db:            db,
+		Account:       newAccount(db, opts...),
+		Friend:        newFriend(db, opts...),
+		FriendRequest: newFriendRequest(db, opts...),
+		Group:         newGroup(db, opts...),
+		GroupMember:   newGroupMember(db, opts...),
+		Message:       newMessage(db, opts...),
+		PeerAddr:      newPeerAddr(db, opts...),
 	}
 }
 
 type Query struct {
 	db *gorm.DB
 
-	Account account
-	Friend  friend
-	Group   group
+	Account       account
+	Friend        friend
+	FriendRequest friendRequest
+	Group         group
+	GroupMember   groupMember
+	Message       message
+	PeerAddr      peerAddr
 }
 
 func (q *Query) Available() bool { return q.db != nil }
 
 func (q *Query) clone(db *gorm.DB) *Query {
 	return &Query{
-		db:      db,
-		Account: q.Account.clone(db),
-		Friend:  q.Friend.clone(db),
-		Group:   q.Group.clone(db),
+		db:            db,
+		Account:       q.Account.clone(db),
+		Friend:        q.Friend.clone(db),
+		FriendRequest: q.FriendRequest.clone(db),
+		Group:         q.Group.clone(db),
+		GroupMember:   q.GroupMember.clone(db),
+		Message:       q.Message.clone(db),
+		PeerAddr:      q.PeerAddr.clone(db),
 	}
 }
 
@@ -53,24 +65,36 @@ func (q *Query) WriteDB() *Query {
 
 func (q *Query) ReplaceDB(db *gorm.DB) *Query {
 	return &Query{
-		db:      db,
-		Account: q.Account.replaceDB(db),
-		Friend:  q.Friend.replaceDB(db),
-		Group:   q.Group.replaceDB(db),
+		db:            db,
+		Account:       q.Account.replaceDB(db),
+		Friend:        q.Friend.replaceDB(db),
+		FriendRequest: q.FriendRequest.replaceDB(db),
+		Group:         q.Group.replaceDB(db),
+		GroupMember:   q.GroupMember.replaceDB(db),
+		Message:       q.Message.replaceDB(db),
+		PeerAddr:      q.PeerAddr.replaceDB(db),
 	}
 }
 
 type queryCtx struct {
-	Account *accountDo
-	Friend  *friendDo
-	Group   *groupDo
+	Account       *accountDo
+	Friend        *friendDo
+	FriendRequest *friendRequestDo
+	Group         *groupDo
+	GroupMember   *groupMemberDo
+	Message       *messageDo
+	PeerAddr      *peerAddrDo
 }
 
 func (q *Query) WithContext(ctx context.Context) *queryCtx {
 	return &queryCtx{
-		Account: q.Account.WithContext(ctx),
-		Friend:  q.Friend.WithContext(ctx),
-		Group:   q.Group.WithContext(ctx),
+		Account:       q.Account.WithContext(ctx),
+		Friend:        q.Friend.WithContext(ctx),
+		FriendRequest: q.FriendRequest.WithContext(ctx),
+		Group:         q.Group.WithContext(ctx),
+		GroupMember:   q.GroupMember.WithContext(ctx),
+		Message:       q.Message.WithContext(ctx),
+		PeerAddr:      q.PeerAddr.WithContext(ctx),
 	}
 }
 
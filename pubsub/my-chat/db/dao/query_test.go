@@ -79,7 +79,11 @@ func Test_WithContext(t *testing.T) {
 	for _, ctx := range []context.Context{
 		qCtx.Account.UnderlyingDB().Statement.Context,
 		qCtx.Friend.UnderlyingDB().Statement.Context,
+		qCtx.FriendRequest.UnderlyingDB().Statement.Context,
 		qCtx.Group.UnderlyingDB().Statement.Context,
+		qCtx.GroupMember.UnderlyingDB().Statement.Context,
+		qCtx.Message.UnderlyingDB().Statement.Context,
+		qCtx.PeerAddr.UnderlyingDB().Statement.Context,
 	} {
 		if v := ctx.Value(key); v != value {
 			t.Errorf("get value from context fail, expect %q, got %q", value, v)
@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/config"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -13,11 +14,34 @@ func GetDB() (*gorm.DB, error) {
 		return globalDB, nil
 	}
 
-	var err error
-	globalDB, err = gorm.Open(sqlite.Open("./db/my-chat.db"))
+	if err := config.Ensure(); err != nil {
+		return nil, fmt.Errorf("init config failed, err = %v", err)
+	}
+
+	db, err := gorm.Open(sqlite.Open(config.DBPath))
 	if err != nil {
 		return nil, fmt.Errorf("open db failed, err = %v", err)
 	}
 
+	// 新的数据库需要建表，旧的数据库需要升级表结构
+	if err = migrate(db); err != nil {
+		return nil, err
+	}
+
+	globalDB = db
 	return globalDB, nil
 }
+
+// Close 关闭数据库，下次 GetDB 时按当前配置重新打开
+func Close() error {
+	if globalDB == nil {
+		return nil
+	}
+
+	sqlDB, err := globalDB.DB()
+	if err != nil {
+		return fmt.Errorf("get sql db failed, err = %v", err)
+	}
+	globalDB = nil
+	return sqlDB.Close()
+}
@@ -11,6 +11,7 @@ type Friend struct {
 	ID       string `gorm:"column:id" json:"id"`
 	Nickname string `gorm:"column:nickname" json:"nickname"`
 	Owner    string `gorm:"column:owner" json:"owner"`
+	PubKey   []byte `gorm:"column:pub_key" json:"pub_key"`
 }
 
 // TableName Friend's table name
@@ -12,6 +12,7 @@ type Group struct {
 	Name  string `gorm:"column:name" json:"name"`
 	Type  int32  `gorm:"column:type" json:"type"`
 	Owner string `gorm:"column:owner" json:"owner"`
+	Topic string `gorm:"column:topic" json:"topic"`
 }
 
 // TableName Group's table name
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+)
+
+// 表结构的升级步骤，与 gorm gen 生成 model 时使用的表结构保持一致。
+// 不使用 AutoMigrate：它会把 id 当作主键，而同一个 id 可以属于本地的多个账户。
+//
+// 数据库的版本记录在 PRAGMA user_version 中，打开数据库时依次执行更新的步骤。
+// 以前的版本没有记录 user_version，表结构停在哪一步都有可能，
+// 所以每个步骤都要能重复执行：建表用 IF NOT EXISTS，加列前检查列是否已经存在。
+// 修改表结构时只能在末尾追加新的步骤，不要修改已有的步骤。
+var migrations = []migration{
+	{version: 1, steps: []step{
+		createTable(`CREATE TABLE IF NOT EXISTS accounts
+(
+    id       text not null,
+    nickname text
+)`),
+		addColumn("accounts", "phone", "text"),
+		createTable(`CREATE TABLE IF NOT EXISTS friends
+(
+    id       text,
+    nickname text,
+    owner    text
+)`),
+		createTable(`CREATE TABLE IF NOT EXISTS groups
+(
+    id   text,
+    name text,
+    type integer
+)`),
+		addColumn("groups", "owner", "text"),
+	}},
+	// 聊天记录
+	{version: 2, steps: []step{
+		createTable(`CREATE TABLE IF NOT EXISTS messages
+(
+    id          text not null,
+    owner       text,
+    room        text,
+    peer        text,
+    sender_id   text,
+    sender_nick text,
+    content     text,
+    timestamp   bigint,
+    state       integer
+)`),
+	}},
+	// 群聊
+	{version: 3, steps: []step{
+		addColumn("groups", "topic", "text"),
+		createTable(`CREATE TABLE IF NOT EXISTS group_members
+(
+    group_id  text,
+    member_id text,
+    owner     text
+)`),
+	}},
+	// 好友申请
+	{version: 4, steps: []step{
+		createTable(`CREATE TABLE IF NOT EXISTS friend_requests
+(
+    id        text,
+    owner     text,
+    peer_id   text,
+    nickname  text,
+    direction integer,
+    state     integer,
+    timestamp bigint
+)`),
+	}},
+	// 好友的公钥，离线消息需要用它加密
+	{version: 5, steps: []step{
+		addColumn("friends", "pub_key", "blob"),
+		addColumn("friend_requests", "pub_key", "blob"),
+	}},
+	// 节点地址缓存
+	{version: 6, steps: []step{
+		createTable(`CREATE TABLE IF NOT EXISTS peer_addrs
+(
+    owner     text,
+    peer_id   text,
+    addrs     text,
+    last_seen bigint
+)`),
+	}},
+}
+
+// migration 把数据库升级到 version
+type migration struct {
+	version int
+	steps   []step
+}
+
+type step func(tx *gorm.DB) error
+
+func createTable(ddl string) step {
+	return func(tx *gorm.DB) error {
+		return tx.Exec(ddl).Error
+	}
+}
+
+// addColumn 在{table}中增加一列，列已经存在时什么也不做
+func addColumn(table, column, typ string) step {
+	return func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Raw("SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", table, column).
+			Scan(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		return tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, typ)).Error
+	}
+}
+
+// schemaVersion 返回数据库当前的版本
+func schemaVersion(db *gorm.DB) (int, error) {
+	var version int
+	err := db.Raw("PRAGMA user_version").Scan(&version).Error
+	return version, err
+}
+
+// migrate 执行比数据库当前版本新的步骤，每个版本在一个事务中完成
+func migrate(db *gorm.DB) error {
+	current, err := schemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("read schema version failed, err = %v", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			for _, s := range m.steps {
+				if err := s(tx); err != nil {
+					return err
+				}
+			}
+			return tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version)).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migrate schema to version %d failed, err = %v", m.version, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	assert.NoError(t, err)
+	return db
+}
+
+func hasColumn(t *testing.T, db *gorm.DB, table, column string) bool {
+	var count int64
+	err := db.Raw("SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count).Error
+	assert.NoError(t, err)
+	return count > 0
+}
+
+func assertLatest(t *testing.T, db *gorm.DB) {
+	version, err := schemaVersion(db)
+	assert.NoError(t, err)
+	assert.Equal(t, migrations[len(migrations)-1].version, version)
+
+	assert.True(t, hasColumn(t, db, "groups", "topic"))
+	assert.True(t, hasColumn(t, db, "friends", "pub_key"))
+	assert.True(t, hasColumn(t, db, "friend_requests", "pub_key"))
+	assert.True(t, hasColumn(t, db, "peer_addrs", "last_seen"))
+}
+
+func TestMigrateNewDB(t *testing.T) {
+	db := openTestDB(t)
+	assert.NoError(t, migrate(db))
+	assertLatest(t, db)
+
+	// 再次打开时不需要升级
+	assert.NoError(t, migrate(db))
+	assertLatest(t, db)
+}
+
+func TestMigrateLegacyDB(t *testing.T) {
+	// 最初提交的数据库，没有 user_version
+	db := openTestDB(t)
+	for _, ddl := range []string{
+		`CREATE TABLE accounts (id text not null, nickname text, phone text)`,
+		`CREATE TABLE groups (id text, name text, type integer, owner text)`,
+		`CREATE TABLE friends (id text, nickname text, owner text)`,
+		`INSERT INTO friends (id, nickname, owner) VALUES ('peer', 'bob', 'me')`,
+	} {
+		assert.NoError(t, db.Exec(ddl).Error)
+	}
+
+	assert.NoError(t, migrate(db))
+	assertLatest(t, db)
+
+	var nickname string
+	assert.NoError(t, db.Raw("SELECT nickname FROM friends WHERE id = 'peer'").Scan(&nickname).Error)
+	assert.Equal(t, "bob", nickname)
+}
+
+func TestMigratePartiallyUpgradedDB(t *testing.T) {
+	// 以前的版本直接建出了带 pub_key 的表，但是没有记录 user_version
+	db := openTestDB(t)
+	for _, ddl := range []string{
+		`CREATE TABLE friends (id text, nickname text, owner text, pub_key blob)`,
+		`CREATE TABLE groups (id text, name text, type integer, owner text, topic text)`,
+	} {
+		assert.NoError(t, db.Exec(ddl).Error)
+	}
+
+	assert.NoError(t, migrate(db))
+	assertLatest(t, db)
+}
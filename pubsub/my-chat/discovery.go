@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 节点发现方式，可以同时使用
+const (
+	DISCOVERY_DHT  = "dht"
+	DISCOVERY_MDNS = "mdns"
+)
+
+const (
+	// 默认保持连接的聊天室节点数
+	DefaultTargetPeers = 8
+
+	// 节点不足时的搜索间隔
+	discoverySearchInterval = 10 * time.Second
+	// 节点足够时的检查间隔
+	discoveryIdleInterval = time.Minute
+	reconnectTimeout      = 10 * time.Second
+)
+
+// DiscoveryState 是节点发现服务的状态，供界面显示
+type DiscoveryState struct {
+	Connected int
+	Target    int
+	LastFound time.Time
+}
+
+// DiscoveryService 持续在 rendezvous 上寻找聊天室的节点，
+// 已连接的节点少于目标数时继续搜索，节点断开后尝试重连。
+// 没有 DHT 时只通过 mDNS 发现局域网内的节点。
+type DiscoveryService struct {
+	ctx        context.Context
+	host       host.Host
+	kadDHT     *dht.IpfsDHT
+	rendezvous []string
+	target     int
+
+	wakeCh chan struct{}
+
+	mu               sync.Mutex
+	found            map[peer.ID]bool
+	lastFound        time.Time
+	routingDiscovery *drouting.RoutingDiscovery
+	mdnsEnabled      bool
+	mdnsServices     []mdns.Service
+}
+
+func NewDiscoveryService(ctx context.Context, h host.Host, kadDHT *dht.IpfsDHT,
+	rendezvous []string, target int) *DiscoveryService {
+	if target <= 0 {
+		target = DefaultTargetPeers
+	}
+	ds := &DiscoveryService{
+		ctx:        ctx,
+		host:       h,
+		kadDHT:     kadDHT,
+		rendezvous: rendezvous,
+		target:     target,
+		wakeCh:     make(chan struct{}, 1),
+		found:      make(map[peer.ID]bool),
+	}
+	h.Network().Notify(&network.NotifyBundle{
+		DisconnectedF: ds.onDisconnected,
+	})
+	return ds
+}
+
+// Run 连接引导节点，然后一直运行，直到{ctx}结束
+func (ds *DiscoveryService) Run(bootstrap []peer.AddrInfo) {
+	if ds.kadDHT == nil {
+		log.Println("DHT discovery is disabled")
+		return
+	}
+
+	initDHT(ds.ctx, ds.host, ds.kadDHT, bootstrap)
+	routingDiscovery := drouting.NewRoutingDiscovery(ds.kadDHT)
+	ds.mu.Lock()
+	ds.routingDiscovery = routingDiscovery
+	for _, ns := range ds.rendezvous {
+		// Advertise 会在广告过期前自动重新发布，直到{ctx}结束
+		dutil.Advertise(ds.ctx, routingDiscovery, ns)
+	}
+	ds.mu.Unlock()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ds.wakeCh:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-ds.ctx.Done():
+			return
+		}
+
+		interval := discoveryIdleInterval
+		if ds.State().Connected < ds.target {
+			ds.search(routingDiscovery)
+			if ds.State().Connected < ds.target {
+				interval = discoverySearchInterval
+			}
+		}
+		timer.Reset(interval)
+	}
+}
+
+// State 返回当前已连接的节点数、目标节点数和最近一次发现新节点的时间
+func (ds *DiscoveryService) State() DiscoveryState {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	connected := 0
+	for pid := range ds.found {
+		if ds.host.Network().Connectedness(pid) == network.Connected {
+			connected++
+		}
+	}
+	return DiscoveryState{
+		Connected: connected,
+		Target:    ds.target,
+		LastFound: ds.lastFound,
+	}
+}
+
+// search 在所有 rendezvous 上搜索节点，连接到足够的节点后停止
+func (ds *DiscoveryService) search(routingDiscovery *drouting.RoutingDiscovery) {
+	log.Println("searching for peers...")
+	ds.mu.Lock()
+	rendezvous := append([]string(nil), ds.rendezvous...)
+	ds.mu.Unlock()
+
+	for _, ns := range rendezvous {
+		peerChan, err := routingDiscovery.FindPeers(ds.ctx, ns)
+		if err != nil {
+			log.Printf("find peers on `%s` failed, err = %v \n", ns, err)
+			continue
+		}
+		for p := range peerChan {
+			if p.ID == ds.host.ID() || len(p.Addrs) == 0 {
+				continue
+			}
+			if ds.host.Network().Connectedness(p.ID) == network.Connected {
+				ds.addPeer(p.ID, false)
+				continue
+			}
+			if ds.State().Connected >= ds.target {
+				continue
+			}
+			if err := ds.connect(p); err != nil {
+				log.Printf("Failed connecting to peer(`%s`), err = %v\n", p.ID, err)
+				continue
+			}
+			log.Printf("Connected to peer(`%s`)\n", p.ID)
+			ds.addPeer(p.ID, true)
+		}
+	}
+}
+
+// StartMDNS 用 rendezvous 作为服务名，在局域网内广播并发现节点
+func (ds *DiscoveryService) StartMDNS() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.mdnsEnabled = true
+	for _, ns := range ds.rendezvous {
+		if err := ds.startMDNSService(ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *DiscoveryService) startMDNSService(ns string) error {
+	service := mdns.NewMdnsService(ds.host, mdnsServiceTag(ns), ds)
+	if err := service.Start(); err != nil {
+		return fmt.Errorf("start mdns service `%s` failed, err = %v", ns, err)
+	}
+	ds.mdnsServices = append(ds.mdnsServices, service)
+	return nil
+}
+
+// AddRendezvous 在新加入的聊天室上公布自己并寻找节点
+func (ds *DiscoveryService) AddRendezvous(ns string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for _, existing := range ds.rendezvous {
+		if existing == ns {
+			return nil
+		}
+	}
+	ds.rendezvous = append(ds.rendezvous, ns)
+
+	if ds.routingDiscovery != nil {
+		dutil.Advertise(ds.ctx, ds.routingDiscovery, ns)
+	}
+	if ds.mdnsEnabled {
+		if err := ds.startMDNSService(ns); err != nil {
+			return err
+		}
+	}
+	ds.wakeup()
+	return nil
+}
+
+// HandlePeerFound 实现 mdns.Notifee，连接局域网内发现的节点
+func (ds *DiscoveryService) HandlePeerFound(p peer.AddrInfo) {
+	if p.ID == ds.host.ID() {
+		return
+	}
+	if ds.host.Network().Connectedness(p.ID) == network.Connected {
+		ds.addPeer(p.ID, false)
+		return
+	}
+	if ds.State().Connected >= ds.target {
+		return
+	}
+
+	if err := ds.connect(p); err != nil {
+		log.Printf("Failed connecting to mdns peer(`%s`), err = %v\n", p.ID, err)
+		return
+	}
+	log.Printf("Connected to mdns peer(`%s`)\n", p.ID)
+	ds.addPeer(p.ID, true)
+}
+
+// Close 停止 mDNS 服务
+func (ds *DiscoveryService) Close() {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.mdnsEnabled = false
+	for _, service := range ds.mdnsServices {
+		_ = service.Close()
+	}
+	ds.mdnsServices = nil
+}
+
+func (ds *DiscoveryService) connect(p peer.AddrInfo) error {
+	ctx, cancel := context.WithTimeout(ds.ctx, reconnectTimeout)
+	defer cancel()
+	return ds.host.Connect(ctx, p)
+}
+
+func (ds *DiscoveryService) addPeer(pid peer.ID, isNew bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.found[pid] = true
+	if isNew {
+		ds.lastFound = time.Now()
+	}
+}
+
+// onDisconnected 在发现过的节点断开后，先用 peerstore 中的地址重连，失败后唤醒搜索
+func (ds *DiscoveryService) onDisconnected(n network.Network, conn network.Conn) {
+	pid := conn.RemotePeer()
+	ds.mu.Lock()
+	found := ds.found[pid]
+	ds.mu.Unlock()
+	// 同一个节点可能有多个连接，全部断开后才重连
+	if !found || n.Connectedness(pid) == network.Connected {
+		return
+	}
+
+	go func() {
+		addrs := ds.host.Peerstore().Addrs(pid)
+		if len(addrs) > 0 {
+			err := ds.connect(peer.AddrInfo{ID: pid, Addrs: addrs})
+			if err == nil {
+				log.Printf("Reconnected to peer(`%s`)\n", pid)
+				return
+			}
+			log.Printf("reconnect peer(`%s`) failed, err = %v \n", pid, err)
+		}
+
+		ds.mu.Lock()
+		delete(ds.found, pid)
+		ds.mu.Unlock()
+		ds.wakeup()
+	}()
+}
+
+func (ds *DiscoveryService) wakeup() {
+	select {
+	case ds.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+var invalidTagChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// mdnsServiceTag 把 rendezvous 转换成 mDNS 服务名，服务名只能包含字母、数字和连字符
+func mdnsServiceTag(rendezvous string) string {
+	tag := invalidTagChars.ReplaceAllString(strings.ToLower(rendezvous), "-")
+	tag = "my-chat-" + strings.Trim(tag, "-")
+	if len(tag) > 63 {
+		tag = tag[:63]
+	}
+	return tag
+}
+
+// parseDiscoveryModes 解析逗号分隔的节点发现方式
+func parseDiscoveryModes(modes string) (useDHT bool, useMDNS bool, err error) {
+	for _, mode := range strings.Split(modes, ",") {
+		switch strings.TrimSpace(mode) {
+		case DISCOVERY_DHT:
+			useDHT = true
+		case DISCOVERY_MDNS:
+			useMDNS = true
+		case "":
+		default:
+			return false, false, fmt.Errorf("unknown discovery mode `%s`", mode)
+		}
+	}
+	if !useDHT && !useMDNS {
+		return false, false, fmt.Errorf("at least one discovery mode is required")
+	}
+	return useDHT, useMDNS, nil
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/global"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/history"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/mailbox"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	DMProtocol   = "/my-chat/dm/1.0.0"
+	DMBufSize    = 128
+	dmAckTimeout = 10 * time.Second
+
+	// 一行消息或确认的最大长度，防止对端不发换行符耗尽内存
+	dmMaxMessageSize = 64 * 1024
+	dmMaxAckSize     = 4096
+
+	// 定期到存储转发节点取离线消息
+	mailboxPollInterval = time.Minute
+
+	// 陌生人的消息在处理之前缓存在内存中，每个节点和总数都有上限
+	dmMaxRequestsPerPeer = 16
+	dmMaxRequests        = 256
+)
+
+const (
+	DM_STATUS_DELIVERED = "delivered"
+	DM_STATUS_QUEUED    = "queued"
+	// 对方不在线，消息交给了存储转发节点
+	DM_STATUS_STORED = "stored"
+	// 对方缓存的陌生人消息太多，消息被丢弃
+	DM_STATUS_DROPPED = "dropped"
+)
+
+type DirectMessage struct {
+	Id         string
+	Message    string
+	SenderID   string
+	SenderNick string
+	Timestamp  int64
+	// 文件通知，Message 中是给旧版本看的文字
+	File *FileOffer `json:",omitempty"`
+	// 经过存储转发节点的消息需要签名，直连的流本身已经认证了对端
+	PubKey []byte `json:",omitempty"`
+	Sign   []byte `json:",omitempty"`
+}
+
+type DirectAck struct {
+	Id     string
+	Status string
+}
+
+// DMService 负责好友之间的一对一私聊
+type DMService struct {
+	Messages chan *DirectMessage
+	Requests chan *DirectMessage
+
+	ctx  context.Context
+	host host.Host
+	key  crypto.PrivKey
+	nick string
+
+	// 存储转发节点
+	mailboxes []peer.AddrInfo
+	fetchCh   chan struct{}
+
+	mu       sync.Mutex
+	requests map[peer.ID][]*DirectMessage
+	// requests 中的消息总数
+	requestCount int
+}
+
+func NewDMService(ctx context.Context, h host.Host, key crypto.PrivKey, nickname string,
+	mailboxes []peer.AddrInfo) *DMService {
+	dm := &DMService{
+		Messages:  make(chan *DirectMessage, DMBufSize),
+		Requests:  make(chan *DirectMessage, DMBufSize),
+		ctx:       ctx,
+		host:      h,
+		key:       key,
+		nick:      nickname,
+		mailboxes: mailboxes,
+		fetchCh:   make(chan struct{}, 1),
+		requests:  make(map[peer.ID][]*DirectMessage),
+	}
+	h.SetStreamHandler(DMProtocol, dm.onDirectMessage)
+
+	if len(mailboxes) > 0 {
+		// 与存储转发节点建立连接时，立即取离线消息
+		h.Network().Notify(&network.NotifyBundle{
+			ConnectedF: func(_ network.Network, conn network.Conn) {
+				if dm.isMailbox(conn.RemotePeer()) {
+					dm.triggerFetch()
+				}
+			},
+		})
+		go dm.fetchLoop()
+	}
+	return dm
+}
+
+func (dm *DMService) onDirectMessage(s network.Stream) {
+	defer s.Close()
+	remote := s.Conn().RemotePeer()
+
+	// 读取消息
+	_ = s.SetReadDeadline(time.Now().Add(dmAckTimeout))
+	line, err := bufio.NewReader(io.LimitReader(s, dmMaxMessageSize)).ReadBytes('\n')
+	if err != nil {
+		log.Printf("[dm] read message from %s failed, err = %v", remote, err)
+		s.Reset()
+		return
+	}
+
+	msg := new(DirectMessage)
+	if err = json.Unmarshal(line, msg); err != nil {
+		log.Printf("[dm] unmarshal message from %s failed, err = %v", remote, err)
+		s.Reset()
+		return
+	}
+
+	// 消息的发送者必须是流的对端
+	if msg.SenderID != remote.String() {
+		log.Printf("[dm] sender id(`%s`) mismatch with remote peer(`%s`)", msg.SenderID, remote)
+		s.Reset()
+		return
+	}
+
+	ack := &DirectAck{Id: msg.Id, Status: dm.deliver(remote, msg)}
+	if err = writeJSONLine(s, ack); err != nil {
+		log.Printf("[dm] send ack to %s failed, err = %v", remote, err)
+	}
+}
+
+// deliver 把收到的消息交给界面，非好友的消息作为`请求`缓存起来。
+// 界面处理不过来时不阻塞：好友的消息已经写入历史记录，陌生人的消息留在缓存中。
+func (dm *DMService) deliver(sender peer.ID, msg *DirectMessage) string {
+	if global.IsMyFriend(msg.SenderID) {
+		dm.saveMessage(msg, msg.SenderID, history.STATE_RECEIVED)
+		select {
+		case dm.Messages <- msg:
+		default:
+			log.Printf("[dm] messages chan is full, msg(`%s`) is only saved", msg.Id)
+		}
+		return DM_STATUS_DELIVERED
+	}
+
+	dm.mu.Lock()
+	if len(dm.requests[sender]) >= dmMaxRequestsPerPeer || dm.requestCount >= dmMaxRequests {
+		dm.mu.Unlock()
+		log.Printf("[dm] too many requests, drop msg(`%s`) from %s", msg.Id, sender)
+		return DM_STATUS_DROPPED
+	}
+	dm.requests[sender] = append(dm.requests[sender], msg)
+	dm.requestCount++
+	dm.mu.Unlock()
+
+	dm.saveMessage(msg, msg.SenderID, history.STATE_REQUEST)
+	select {
+	case dm.Requests <- msg:
+	default:
+		log.Printf("[dm] requests chan is full, msg(`%s`) is only queued", msg.Id)
+	}
+	return DM_STATUS_QUEUED
+}
+
+// SetNick 修改私聊消息中使用的昵称
+func (dm *DMService) SetNick(nickname string) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.nick = nickname
+}
+
+func (dm *DMService) Nick() string {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return dm.nick
+}
+
+// Send 向好友发送一条私聊消息，并等待对方的确认
+func (dm *DMService) Send(friendId string, message string) (*DirectAck, error) {
+	return dm.sendMessage(friendId, dm.newMessage(message))
+}
+
+// SendFile 向好友发出文件通知
+func (dm *DMService) SendFile(friendId string, offer *FileOffer) (*DirectAck, error) {
+	msg := dm.newMessage(fileOfferText(offer))
+	msg.File = offer
+	return dm.sendMessage(friendId, msg)
+}
+
+func (dm *DMService) newMessage(message string) *DirectMessage {
+	return &DirectMessage{
+		Id:         uuid.New().String(),
+		Message:    message,
+		SenderID:   dm.host.ID().String(),
+		SenderNick: dm.Nick(),
+		Timestamp:  time.Now().UnixMilli(),
+	}
+}
+
+func (dm *DMService) sendMessage(friendId string, msg *DirectMessage) (*DirectAck, error) {
+	dm.saveMessage(msg, friendId, history.STATE_SENDING)
+
+	ack, err := dm.send(friendId, msg)
+	if err != nil {
+		// 对方不在线时，交给存储转发节点
+		if len(dm.mailboxes) == 0 {
+			dm.updateState(msg.Id, history.STATE_FAILED)
+			return nil, err
+		}
+		log.Printf("[dm] send msg(`%s`) directly failed, try mailbox, err = %v", msg.Id, err)
+		if err = dm.storeOffline(friendId, msg); err != nil {
+			dm.updateState(msg.Id, history.STATE_FAILED)
+			return nil, err
+		}
+		dm.updateState(msg.Id, history.STATE_STORED)
+		return &DirectAck{Id: msg.Id, Status: DM_STATUS_STORED}, nil
+	}
+
+	dm.rememberPubKey(friendId)
+	switch ack.Status {
+	case DM_STATUS_QUEUED:
+		dm.updateState(msg.Id, history.STATE_QUEUED)
+	case DM_STATUS_DROPPED:
+		dm.updateState(msg.Id, history.STATE_FAILED)
+	default:
+		dm.updateState(msg.Id, history.STATE_DELIVERED)
+	}
+	return ack, nil
+}
+
+func (dm *DMService) send(friendId string, msg *DirectMessage) (*DirectAck, error) {
+	pid, err := peer.Decode(friendId)
+	if err != nil {
+		return nil, fmt.Errorf("decode peer id(`%s`) failed, err = %v", friendId, err)
+	}
+
+	ctx, cancel := context.WithTimeout(dm.ctx, dmAckTimeout)
+	defer cancel()
+
+	s, err := dm.host.NewStream(ctx, pid, DMProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("open stream to `%s` failed, err = %v", friendId, err)
+	}
+	defer s.Close()
+
+	if err = writeJSONLine(s, msg); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("send message to `%s` failed, err = %v", friendId, err)
+	}
+
+	// 等待确认
+	_ = s.SetReadDeadline(time.Now().Add(dmAckTimeout))
+	line, err := bufio.NewReader(io.LimitReader(s, dmMaxAckSize)).ReadBytes('\n')
+	if err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("wait ack from `%s` failed, err = %v", friendId, err)
+	}
+
+	ack := new(DirectAck)
+	if err = json.Unmarshal(line, ack); err != nil {
+		return nil, fmt.Errorf("unmarshal ack from `%s` failed, err = %v", friendId, err)
+	}
+	if ack.Id != msg.Id {
+		return nil, fmt.Errorf("ack id(`%s`) mismatch with message id(`%s`)", ack.Id, msg.Id)
+	}
+
+	return ack, nil
+}
+
+// storeOffline 用好友的公钥加密并签名消息，交给第一个可用的存储转发节点
+func (dm *DMService) storeOffline(friendId string, msg *DirectMessage) error {
+	pubKey, err := dm.friendPubKey(friendId)
+	if err != nil {
+		return err
+	}
+
+	if err = signDirectMessage(dm.key, msg); err != nil {
+		return fmt.Errorf("sign message failed, err = %v", err)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	payload, err := mailbox.Seal(pubKey, data)
+	if err != nil {
+		return fmt.Errorf("seal message failed, err = %v", err)
+	}
+
+	env := &mailbox.Envelope{Id: msg.Id, Recipient: friendId, Payload: payload}
+	for _, mb := range dm.mailboxes {
+		if err = dm.host.Connect(dm.ctx, mb); err != nil {
+			log.Printf("[dm] connect mailbox %s failed, err = %v", mb.ID, err)
+			continue
+		}
+		if err = mailbox.Store(dm.ctx, dm.host, mb.ID, env); err != nil {
+			log.Printf("[dm] store msg(`%s`) to mailbox %s failed, err = %v", msg.Id, mb.ID, err)
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("no mailbox accepted the message, last err = %v", err)
+}
+
+// friendPubKey 返回好友的公钥：优先使用好友握手时记录的，其次使用 peerstore 中的
+func (dm *DMService) friendPubKey(friendId string) (crypto.PubKey, error) {
+	if friend, ok := global.FindMyFriend(friendId); ok && len(friend.PubKey) > 0 {
+		return crypto.UnmarshalPublicKey(friend.PubKey)
+	}
+
+	pid, err := peer.Decode(friendId)
+	if err != nil {
+		return nil, err
+	}
+	if pubKey := dm.host.Peerstore().PubKey(pid); pubKey != nil {
+		return pubKey, nil
+	}
+	return nil, fmt.Errorf("public key of `%s` is unknown, can not encrypt offline message", friendId)
+}
+
+// rememberPubKey 直连成功后记录好友的公钥，以便对方离线时加密消息
+func (dm *DMService) rememberPubKey(friendId string) {
+	friend, ok := global.FindMyFriend(friendId)
+	if !ok || len(friend.PubKey) > 0 {
+		return
+	}
+
+	pid, err := peer.Decode(friendId)
+	if err != nil {
+		return
+	}
+	pubKey := dm.host.Peerstore().PubKey(pid)
+	if pubKey == nil {
+		return
+	}
+	pubKeyData, err := crypto.MarshalPublicKey(pubKey)
+	if err != nil {
+		return
+	}
+
+	if err = links.SetFriendPubKey(dm.host.ID().String(), friendId, pubKeyData); err != nil {
+		log.Printf("[dm] save public key of %s failed, err = %v", friendId, err)
+		return
+	}
+	global.SetMyFriendPubKey(friendId, pubKeyData)
+}
+
+func (dm *DMService) isMailbox(pid peer.ID) bool {
+	for _, mb := range dm.mailboxes {
+		if mb.ID == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func (dm *DMService) triggerFetch() {
+	select {
+	case dm.fetchCh <- struct{}{}:
+	default:
+	}
+}
+
+// fetchLoop 上线后以及与存储转发节点重新连接时，取回离线期间的消息
+func (dm *DMService) fetchLoop() {
+	ticker := time.NewTicker(mailboxPollInterval)
+	defer ticker.Stop()
+
+	dm.triggerFetch()
+	for {
+		select {
+		case <-dm.fetchCh:
+		case <-ticker.C:
+		case <-dm.ctx.Done():
+			return
+		}
+
+		for _, mb := range dm.mailboxes {
+			if err := dm.host.Connect(dm.ctx, mb); err != nil {
+				log.Printf("[dm] connect mailbox %s failed, err = %v", mb.ID, err)
+				continue
+			}
+			n, err := mailbox.Fetch(dm.ctx, dm.host, mb.ID, dm.onEnvelope)
+			if err != nil {
+				log.Printf("[dm] fetch from mailbox %s failed, err = %v", mb.ID, err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("[dm] fetched %d msgs from mailbox %s", n, mb.ID)
+			}
+		}
+	}
+}
+
+// onEnvelope 处理存储转发节点交来的消息，返回 true 表示节点可以删除这条消息。
+// 无法解开或签名错误的消息也会被确认，避免反复投递。
+func (dm *DMService) onEnvelope(env *mailbox.Envelope) bool {
+	data, err := mailbox.Open(dm.key, env.Payload)
+	if err != nil {
+		log.Printf("[dm] open envelope(`%s`) failed, err = %v", env.Id, err)
+		return true
+	}
+
+	msg := new(DirectMessage)
+	if err = json.Unmarshal(data, msg); err != nil {
+		log.Printf("[dm] unmarshal envelope(`%s`) failed, err = %v", env.Id, err)
+		return true
+	}
+	if err = authenticateDirectMessage(msg); err != nil {
+		log.Printf("[dm] reject envelope(`%s`), err = %v", env.Id, err)
+		return true
+	}
+
+	// 同一条消息可能被多个节点保管
+	exists, err := history.MessageExists(dm.host.ID().String(), msg.Id)
+	if err != nil {
+		log.Printf("[dm] check msg(`%s`) failed, err = %v", msg.Id, err)
+		return false
+	}
+	if exists {
+		return true
+	}
+
+	sender, _ := peer.Decode(msg.SenderID)
+	dm.deliver(sender, msg)
+	return true
+}
+
+// PendingRequests 返回陌生人发来的、尚未处理的消息
+func (dm *DMService) PendingRequests() map[peer.ID][]*DirectMessage {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	requests := make(map[peer.ID][]*DirectMessage, len(dm.requests))
+	for pid, msgs := range dm.requests {
+		requests[pid] = append([]*DirectMessage(nil), msgs...)
+	}
+	return requests
+}
+
+// AcceptRequests 接受{pid}发来的、缓存中的消息，返回这些消息
+func (dm *DMService) AcceptRequests(pid peer.ID) []*DirectMessage {
+	msgs := dm.takeRequests(pid)
+	for _, msg := range msgs {
+		dm.updateState(msg.Id, history.STATE_RECEIVED)
+	}
+	return msgs
+}
+
+// DropRequests 丢弃{pid}发来的、缓存中的消息，同时删除历史记录，返回丢弃的条数
+func (dm *DMService) DropRequests(pid peer.ID) int {
+	msgs := dm.takeRequests(pid)
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		ids = append(ids, msg.Id)
+	}
+	if err := history.DeleteMessages(dm.host.ID().String(), ids); err != nil {
+		log.Printf("[dm] delete requests from %s failed, err = %v", pid, err)
+	}
+	return len(msgs)
+}
+
+func (dm *DMService) takeRequests(pid peer.ID) []*DirectMessage {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	msgs := dm.requests[pid]
+	delete(dm.requests, pid)
+	dm.requestCount -= len(msgs)
+	return msgs
+}
+
+// saveMessage 将私聊消息写入历史记录，{peerId}为会话的对方
+func (dm *DMService) saveMessage(msg *DirectMessage, peerId string, state int32) {
+	err := history.SaveMessage(&history.Message{
+		Id:         msg.Id,
+		Owner:      dm.host.ID().String(),
+		Peer:       peerId,
+		SenderID:   msg.SenderID,
+		SenderNick: msg.SenderNick,
+		Content:    msg.Message,
+		Timestamp:  msg.Timestamp,
+		State:      state,
+	})
+	if err != nil {
+		log.Printf("[dm] save msg(`%s`) failed, err = %v", msg.Id, err)
+	}
+}
+
+func (dm *DMService) updateState(id string, state int32) {
+	if err := history.UpdateState(dm.host.ID().String(), id, state); err != nil {
+		log.Printf("[dm] update msg(`%s`) state failed, err = %v", id, err)
+	}
+}
+
+// signDirectMessage 对私聊消息签名，签名覆盖除 Sign 之外的所有字段
+func signDirectMessage(key crypto.PrivKey, msg *DirectMessage) error {
+	return signJSON(key, msg, &msg.PubKey, &msg.Sign)
+}
+
+// authenticateDirectMessage 校验私聊消息的签名，以及公钥和发送者 id 是否匹配
+func authenticateDirectMessage(msg *DirectMessage) error {
+	return verifyJSON(msg, &msg.Sign, msg.SenderID, msg.PubKey)
+}
+
+func writeJSONLine(s network.Stream, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Write(append(data, '\n'))
+	return err
+}
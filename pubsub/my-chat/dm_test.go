@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newStrangerID(t *testing.T) peer.ID {
+	key, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	assert.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(key)
+	assert.NoError(t, err)
+	return pid
+}
+
+func strangerMessage(pid peer.ID, i int) *DirectMessage {
+	return &DirectMessage{Id: fmt.Sprintf("%s-%d", pid, i), Message: "hi", SenderID: pid.String()}
+}
+
+func TestDeliverLimitsRequests(t *testing.T) {
+	dm := newTestAPIServer(t).dm
+
+	// 界面不读取 Requests，deliver 也不能阻塞
+	stranger := newStrangerID(t)
+	for i := 0; i < dmMaxRequestsPerPeer; i++ {
+		assert.Equal(t, DM_STATUS_QUEUED, dm.deliver(stranger, strangerMessage(stranger, i)))
+	}
+	assert.Equal(t, DM_STATUS_DROPPED, dm.deliver(stranger, strangerMessage(stranger, dmMaxRequestsPerPeer)))
+	assert.Len(t, dm.PendingRequests()[stranger], dmMaxRequestsPerPeer)
+
+	// 总数的上限
+	for dm.requestCount < dmMaxRequests {
+		pid := newStrangerID(t)
+		for i := 0; i < dmMaxRequestsPerPeer && dm.requestCount < dmMaxRequests; i++ {
+			assert.Equal(t, DM_STATUS_QUEUED, dm.deliver(pid, strangerMessage(pid, i)))
+		}
+	}
+	other := newStrangerID(t)
+	assert.Equal(t, DM_STATUS_DROPPED, dm.deliver(other, strangerMessage(other, 0)))
+
+	// 处理之后腾出空间
+	assert.Len(t, dm.AcceptRequests(stranger), dmMaxRequestsPerPeer)
+	assert.Empty(t, dm.PendingRequests()[stranger])
+	assert.Equal(t, DM_STATUS_QUEUED, dm.deliver(other, strangerMessage(other, 0)))
+	assert.Equal(t, 1, dm.DropRequests(other))
+	assert.Equal(t, dmMaxRequests-dmMaxRequestsPerPeer, dm.requestCount)
+}
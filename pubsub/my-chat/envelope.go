@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"log"
+	"sync"
+	"time"
+)
+
+// 消息时间戳允许的偏差，超出这个窗口的消息会被丢弃
+const MessageTimeWindow = 5 * time.Minute
+
+// signChatMessage 对消息签名，签名覆盖除 Sign 之外的所有字段
+func signChatMessage(key crypto.PrivKey, cm *ChatMessage) error {
+	return signJSON(key, cm, &cm.PubKey, &cm.Sign)
+}
+
+// authenticateChatMessage 校验消息的签名，以及公钥和发送者 id 是否匹配
+func authenticateChatMessage(cm *ChatMessage) error {
+	return verifyJSON(cm, &cm.Sign, cm.SenderID, cm.PubKey)
+}
+
+// signJSON 对{v}的 JSON 编码签名，{pubKey}和{sign}是{v}中的字段：
+// 先填入公钥、清空签名再编码，所以签名覆盖除{sign}之外的所有字段
+func signJSON(key crypto.PrivKey, v interface{}, pubKey *[]byte, sign *[]byte) error {
+	pubKeyData, err := crypto.MarshalPublicKey(key.GetPublic())
+	if err != nil {
+		return fmt.Errorf("marshal public key failed, err = %v", err)
+	}
+	*pubKey = pubKeyData
+	*sign = nil
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	*sign, err = key.Sign(data)
+	return err
+}
+
+// verifyJSON 校验 signJSON 生成的签名，以及公钥是否属于{senderId}，{sign}在校验之后恢复
+func verifyJSON(v interface{}, sign *[]byte, senderId string, pubKey []byte) error {
+	signature := *sign
+	if len(signature) == 0 {
+		return fmt.Errorf("message is not signed")
+	}
+
+	*sign = nil
+	data, err := json.Marshal(v)
+	*sign = signature
+	if err != nil {
+		return fmt.Errorf("marshal message failed, err = %v", err)
+	}
+
+	pid, err := peer.Decode(senderId)
+	if err != nil {
+		return fmt.Errorf("decode sender id failed, err = %v", err)
+	}
+
+	return verifyData(data, signature, pid, pubKey)
+}
+
+func verifyData(data []byte, signature []byte, peerId peer.ID, pubKeyData []byte) error {
+	key, err := crypto.UnmarshalPublicKey(pubKeyData)
+	if err != nil {
+		return fmt.Errorf("unmarshal public key failed, err = %v", err)
+	}
+
+	idFromKey, err := peer.IDFromPublicKey(key)
+	if err != nil {
+		return fmt.Errorf("extract peer id from public key failed, err = %v", err)
+	}
+	if idFromKey != peerId {
+		return fmt.Errorf("public key does not belong to `%s`", peerId)
+	}
+
+	ok, err := key.Verify(data, signature)
+	if err != nil {
+		return fmt.Errorf("verify signature failed, err = %v", err)
+	}
+	if !ok {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// seenMessages 记录时间窗口内见过的消息 id，用于识别重放的消息
+type seenMessages struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newSeenMessages() *seenMessages {
+	return &seenMessages{seen: make(map[string]time.Time)}
+}
+
+// add 记录消息 id，如果已经见过则返回 false
+func (s *seenMessages) add(id string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 清理时间窗口以外的记录，它们会被时间戳校验拦下
+	for seenId, expire := range s.seen {
+		if now.After(expire) {
+			delete(s.seen, seenId)
+		}
+	}
+
+	if _, ok := s.seen[id]; ok {
+		return false
+	}
+	s.seen[id] = now.Add(2 * MessageTimeWindow)
+	return true
+}
+
+// newChatValidator 创建聊天室主题的校验器，丢弃未签名、签名错误和重放的消息，
+// 以及{isMember}返回 false 的节点发出的消息
+func newChatValidator(roomName string, isMember func(peer.ID) bool) pubsub.ValidatorEx {
+	seen := newSeenMessages()
+
+	return func(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		cm, err := decodeChatMessage(msg.Data)
+		if err != nil {
+			log.Printf("[validator] room(`%s`) reject msg, err = %v", roomName, err)
+			return pubsub.ValidationReject
+		}
+
+		// 消息的签名者必须是 pubsub 消息的作者
+		if cm.SenderID != msg.GetFrom().String() {
+			log.Printf("[validator] room(`%s`) reject msg(`%s`), sender `%s` is not author `%s`",
+				roomName, cm.Id, cm.SenderID, msg.GetFrom())
+			return pubsub.ValidationReject
+		}
+
+		// 邀请群拒绝非成员的消息，拒绝的消息不会被转发给其他成员
+		if !isMember(msg.GetFrom()) {
+			log.Printf("[validator] room(`%s`) reject msg(`%s`) from non-member `%s`", roomName, cm.Id, msg.GetFrom())
+			return pubsub.ValidationReject
+		}
+
+		now := time.Now()
+		sent := time.UnixMilli(cm.Timestamp)
+		if sent.Before(now.Add(-MessageTimeWindow)) || sent.After(now.Add(MessageTimeWindow)) {
+			log.Printf("[validator] room(`%s`) ignore msg(`%s`), timestamp %s out of window",
+				roomName, cm.Id, sent.Format(time.RFC3339))
+			return pubsub.ValidationIgnore
+		}
+
+		if len(cm.Id) == 0 || !seen.add(cm.Id, now) {
+			log.Printf("[validator] room(`%s`) ignore replayed msg(`%s`)", roomName, cm.Id)
+			return pubsub.ValidationIgnore
+		}
+
+		msg.ValidatorData = cm
+		return pubsub.ValidationAccept
+	}
+}
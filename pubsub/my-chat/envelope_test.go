@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pubsubpb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newSignedMessage(t *testing.T) (*ChatMessage, crypto.PrivKey) {
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	assert.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(privKey)
+	assert.NoError(t, err)
+
+	cm := &ChatMessage{
+		Id:         "1",
+		Message:    "hello",
+		SenderID:   id.String(),
+		SenderNick: "alice",
+		Timestamp:  time.Now().UnixMilli(),
+	}
+	assert.NoError(t, signChatMessage(privKey, cm))
+	return cm, privKey
+}
+
+func TestAuthenticateChatMessage(t *testing.T) {
+	cm, _ := newSignedMessage(t)
+	assert.NoError(t, authenticateChatMessage(cm))
+}
+
+func TestAuthenticateChatMessage_Tampered(t *testing.T) {
+	cm, _ := newSignedMessage(t)
+	cm.SenderNick = "mallory"
+	assert.Error(t, authenticateChatMessage(cm))
+}
+
+func TestAuthenticateChatMessage_ForgedSender(t *testing.T) {
+	cm, _ := newSignedMessage(t)
+	other, _ := newSignedMessage(t)
+
+	// 用自己的密钥冒充别人
+	cm.SenderID = other.SenderID
+	assert.Error(t, authenticateChatMessage(cm))
+}
+
+func TestAuthenticateChatMessage_Unsigned(t *testing.T) {
+	cm, _ := newSignedMessage(t)
+	cm.Sign = nil
+	assert.Error(t, authenticateChatMessage(cm))
+}
+
+func TestSeenMessages(t *testing.T) {
+	seen := newSeenMessages()
+	now := time.Now()
+
+	assert.True(t, seen.add("1", now))
+	assert.False(t, seen.add("1", now))
+	assert.True(t, seen.add("1", now.Add(3*MessageTimeWindow)))
+}
+
+// legacyChatMessage 是加入消息类型之前的消息格式
+type legacyChatMessage struct {
+	Id         string `json:",omitempty"`
+	Message    string
+	SenderID   string
+	SenderNick string
+	Timestamp  int64  `json:",omitempty"`
+	PubKey     []byte `json:",omitempty"`
+	Sign       []byte `json:",omitempty"`
+}
+
+func TestAuthenticateChatMessage_Legacy(t *testing.T) {
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	assert.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(privKey)
+	assert.NoError(t, err)
+	pubKey, err := crypto.MarshalPublicKey(privKey.GetPublic())
+	assert.NoError(t, err)
+
+	// 旧版本的节点签名并发送的消息
+	legacy := &legacyChatMessage{
+		Id:         "1",
+		Message:    "hello",
+		SenderID:   id.String(),
+		SenderNick: "alice",
+		Timestamp:  time.Now().UnixMilli(),
+		PubKey:     pubKey,
+	}
+	data, err := json.Marshal(legacy)
+	assert.NoError(t, err)
+	legacy.Sign, err = privKey.Sign(data)
+	assert.NoError(t, err)
+	data, err = json.Marshal(legacy)
+	assert.NoError(t, err)
+
+	cm := new(ChatMessage)
+	assert.NoError(t, json.Unmarshal(data, cm))
+	assert.NoError(t, authenticateChatMessage(cm))
+	assert.True(t, cm.IsText())
+}
+
+func TestAuthenticateChatMessage_Control(t *testing.T) {
+	cm, privKey := newSignedMessage(t)
+	cm.Type = CHAT_TYPE_READ
+	cm.ReadIds = []string{"a", "b"}
+	assert.NoError(t, signChatMessage(privKey, cm))
+	assert.NoError(t, authenticateChatMessage(cm))
+	assert.False(t, cm.IsText())
+
+	cm.ReadIds = []string{"a"}
+	assert.Error(t, authenticateChatMessage(cm))
+}
+
+func TestChatValidator_NonMember(t *testing.T) {
+	cm, _ := newSignedMessage(t)
+	data, err := json.Marshal(cm)
+	assert.NoError(t, err)
+	author, err := peer.Decode(cm.SenderID)
+	assert.NoError(t, err)
+	newMsg := func() *pubsub.Message {
+		return &pubsub.Message{Message: &pubsubpb.Message{Data: data, From: []byte(author)}}
+	}
+
+	// 邀请群拒绝非成员的消息，拒绝的消息不会被转发
+	members := map[string]bool{}
+	isMember := func(pid peer.ID) bool { return members[pid.String()] }
+	validator := newChatValidator("group", isMember)
+	assert.Equal(t, pubsub.ValidationReject, validator(context.Background(), author, newMsg()))
+
+	members[cm.SenderID] = true
+	validator = newChatValidator("group", isMember)
+	assert.Equal(t, pubsub.ValidationAccept, validator(context.Background(), author, newMsg()))
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/global"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	FriendProtocol   = "/my-chat/friend/1.0.0"
+	FriendBufSize    = 32
+	friendAckTimeout = 10 * time.Second
+	// 握手消息只有昵称、公钥和签名，一行不会太长
+	friendMaxLineSize = 4096
+
+	// 最多保存的别人发来的、尚未处理的好友请求，防止陌生节点刷请求
+	maxPendingFriendRequests = 64
+)
+
+// 握手消息的类型
+const (
+	FRIEND_REQUEST = "request"
+	FRIEND_ACCEPT  = "accept"
+	FRIEND_REJECT  = "reject"
+)
+
+// 握手消息的确认状态
+const (
+	FRIEND_STATUS_RECEIVED = "received"
+	FRIEND_STATUS_INVALID  = "invalid"
+)
+
+// FriendHandshake 是好友请求及其应答，请求和应答共用同一个 Id
+type FriendHandshake struct {
+	Id         string
+	Type       string
+	SenderID   string
+	SenderNick string
+	Timestamp  int64
+	PubKey     []byte `json:",omitempty"`
+	Sign       []byte `json:",omitempty"`
+}
+
+// FriendService 负责添加好友的握手：发送请求，接受或拒绝对方的请求
+type FriendService struct {
+	Events chan *FriendHandshake
+
+	ctx  context.Context
+	host host.Host
+	key  crypto.PrivKey
+
+	mu   sync.Mutex
+	nick string
+}
+
+func NewFriendService(ctx context.Context, h host.Host, key crypto.PrivKey, nickname string) *FriendService {
+	fs := &FriendService{
+		Events: make(chan *FriendHandshake, FriendBufSize),
+		ctx:    ctx,
+		host:   h,
+		key:    key,
+		nick:   nickname,
+	}
+	h.SetStreamHandler(FriendProtocol, fs.onHandshake)
+	return fs
+}
+
+func (fs *FriendService) onHandshake(s network.Stream) {
+	defer s.Close()
+	remote := s.Conn().RemotePeer()
+
+	_ = s.SetReadDeadline(time.Now().Add(friendAckTimeout))
+	line, err := bufio.NewReader(io.LimitReader(s, friendMaxLineSize)).ReadBytes('\n')
+	if err != nil {
+		log.Printf("[friend] read handshake from %s failed, err = %v", remote, err)
+		s.Reset()
+		return
+	}
+
+	hs := new(FriendHandshake)
+	if err = json.Unmarshal(line, hs); err != nil {
+		log.Printf("[friend] unmarshal handshake from %s failed, err = %v", remote, err)
+		s.Reset()
+		return
+	}
+
+	ack := &DirectAck{Id: hs.Id, Status: FRIEND_STATUS_RECEIVED}
+	if err = fs.handleHandshake(remote, hs); err != nil {
+		log.Printf("[friend] handle %s(`%s`) from %s failed, err = %v", hs.Type, hs.Id, remote, err)
+		ack.Status = FRIEND_STATUS_INVALID
+	}
+
+	if err = writeJSONLine(s, ack); err != nil {
+		log.Printf("[friend] send ack to %s failed, err = %v", remote, err)
+	}
+}
+
+func (fs *FriendService) handleHandshake(remote peer.ID, hs *FriendHandshake) error {
+	// 握手消息必须由流的对端签名
+	if hs.SenderID != remote.String() {
+		return fmt.Errorf("sender id(`%s`) mismatch with remote peer(`%s`)", hs.SenderID, remote)
+	}
+	if err := authenticateHandshake(hs); err != nil {
+		return err
+	}
+	sent := time.UnixMilli(hs.Timestamp)
+	if time.Since(sent) > MessageTimeWindow || time.Until(sent) > MessageTimeWindow {
+		return fmt.Errorf("timestamp %s out of window", sent.Format(time.RFC3339))
+	}
+
+	myId := fs.host.ID().String()
+	switch hs.Type {
+	case FRIEND_REQUEST:
+		// 已经是好友（例如对方丢失了数据），直接回复接受
+		if global.IsMyFriend(hs.SenderID) {
+			go fs.reply(&links.FriendRequest{Id: hs.Id, PeerId: hs.SenderID}, FRIEND_ACCEPT)
+			return nil
+		}
+
+		if err := checkPendingRequests(myId, hs.SenderID); err != nil {
+			return err
+		}
+		err := links.SaveFriendRequest(&links.FriendRequest{
+			Id:        hs.Id,
+			Owner:     myId,
+			PeerId:    hs.SenderID,
+			Nickname:  hs.SenderNick,
+			Direction: links.FRIEND_REQUEST_INCOMING,
+			State:     links.FRIEND_REQUEST_PENDING,
+			Timestamp: hs.Timestamp,
+			PubKey:    hs.PubKey,
+		})
+		if err != nil {
+			return err
+		}
+
+	case FRIEND_ACCEPT, FRIEND_REJECT:
+		// 应答必须对应一个我发出的、尚未处理的请求
+		request, err := links.GetPendingRequest(myId, hs.SenderID, links.FRIEND_REQUEST_OUTGOING)
+		if err != nil {
+			return fmt.Errorf("find pending request failed, err = %v", err)
+		}
+		if request.Id != hs.Id {
+			return fmt.Errorf("no pending request with id `%s`", hs.Id)
+		}
+
+		if hs.Type == FRIEND_REJECT {
+			if err = links.RejectFriendRequest(myId, request.Id); err != nil {
+				return err
+			}
+			break
+		}
+
+		// 发送请求时指定了昵称的，使用指定的昵称
+		nickname := hs.SenderNick
+		if len(request.Nickname) > 0 {
+			nickname = request.Nickname
+		}
+		friend := &links.Friend{Id: hs.SenderID, Nickname: nickname, PubKey: hs.PubKey}
+		if err = links.AcceptFriendRequest(myId, request, friend); err != nil {
+			return err
+		}
+		global.AddMyFriend(friend)
+
+	default:
+		return fmt.Errorf("unknown handshake type `%s`", hs.Type)
+	}
+
+	// 界面处理不过来时不阻塞，请求已经保存，可以用 /requests 查看
+	select {
+	case fs.Events <- hs:
+	default:
+		log.Printf("[friend] events chan is full, drop %s(`%s`) event", hs.Type, hs.Id)
+	}
+	return nil
+}
+
+// checkPendingRequests 别人发来的待处理请求太多时拒绝新的请求，{peerId}已有的请求可以被替换
+func checkPendingRequests(myId string, peerId string) error {
+	count, err := links.CountPendingRequests(myId, links.FRIEND_REQUEST_INCOMING)
+	if err != nil {
+		return err
+	}
+	if count < maxPendingFriendRequests {
+		return nil
+	}
+	if _, err = links.GetPendingRequest(myId, peerId, links.FRIEND_REQUEST_INCOMING); err == nil {
+		return nil
+	}
+	return fmt.Errorf("too many pending friend requests")
+}
+
+// SendRequest 向{peerId}发送好友请求，请求在对方处理之前一直保存在本地。
+// {nickname}是对方成为好友后使用的昵称，为空时使用对方自己的昵称。
+func (fs *FriendService) SendRequest(peerId string, nickname string) (*links.FriendRequest, error) {
+	pid, err := peer.Decode(peerId)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer id `%s`, err = %v", peerId, err)
+	}
+	if pid == fs.host.ID() {
+		return nil, fmt.Errorf("can not add myself as a friend")
+	}
+	if global.IsMyFriend(peerId) {
+		return nil, fmt.Errorf("`%s` is already your friend", peerId)
+	}
+
+	hs, err := fs.newHandshake(uuid.New().String(), FRIEND_REQUEST)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &links.FriendRequest{
+		Id:        hs.Id,
+		Owner:     fs.host.ID().String(),
+		PeerId:    peerId,
+		Nickname:  nickname,
+		Direction: links.FRIEND_REQUEST_OUTGOING,
+		State:     links.FRIEND_REQUEST_PENDING,
+		Timestamp: hs.Timestamp,
+	}
+	if err = links.SaveFriendRequest(request); err != nil {
+		return nil, fmt.Errorf("save friend request failed, err = %v", err)
+	}
+
+	if err = fs.send(pid, hs); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// Accept 接受{idOrNickname}发来的好友请求，通知对方后将其添加为好友
+func (fs *FriendService) Accept(idOrNickname string) (*links.Friend, error) {
+	request, err := fs.findIncoming(idOrNickname)
+	if err != nil {
+		return nil, err
+	}
+
+	// 先通知对方，失败时请求仍然保留，可以重试
+	if err = fs.reply(request, FRIEND_ACCEPT); err != nil {
+		return nil, err
+	}
+
+	friend := &links.Friend{Id: request.PeerId, Nickname: request.Nickname, PubKey: request.PubKey}
+	if err = links.AcceptFriendRequest(fs.host.ID().String(), request, friend); err != nil {
+		return nil, fmt.Errorf("save friend failed, err = %v", err)
+	}
+	global.AddMyFriend(friend)
+
+	return friend, nil
+}
+
+// Reject 拒绝{idOrNickname}发来的好友请求
+func (fs *FriendService) Reject(idOrNickname string) (*links.FriendRequest, error) {
+	request, err := fs.findIncoming(idOrNickname)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = links.RejectFriendRequest(fs.host.ID().String(), request.Id); err != nil {
+		return nil, err
+	}
+
+	// 对方不在线也没关系，拒绝只需要在本地生效
+	if err = fs.reply(request, FRIEND_REJECT); err != nil {
+		log.Printf("[friend] notify %s of rejection failed, err = %v", request.PeerId, err)
+	}
+	return request, nil
+}
+
+// SetNick 修改握手消息中使用的昵称
+func (fs *FriendService) SetNick(nickname string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.nick = nickname
+}
+
+func (fs *FriendService) Nick() string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.nick
+}
+
+// PendingRequests 返回别人发来的、尚未处理的好友请求
+func (fs *FriendService) PendingRequests() ([]*links.FriendRequest, error) {
+	return links.LoadPendingRequests(fs.host.ID().String(), links.FRIEND_REQUEST_INCOMING)
+}
+
+// findIncoming 按照 id 或昵称查找待处理的好友请求
+func (fs *FriendService) findIncoming(idOrNickname string) (*links.FriendRequest, error) {
+	request, err := links.GetPendingRequest(fs.host.ID().String(), idOrNickname, links.FRIEND_REQUEST_INCOMING)
+	if err == nil {
+		return request, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	requests, err := fs.PendingRequests()
+	if err != nil {
+		return nil, err
+	}
+	var found *links.FriendRequest
+	for _, request = range requests {
+		if request.Nickname != idOrNickname {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("more than one request from `%s`, use the id instead", idOrNickname)
+		}
+		found = request
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no pending friend request from `%s`", idOrNickname)
+	}
+	return found, nil
+}
+
+// reply 对{request}作出应答
+func (fs *FriendService) reply(request *links.FriendRequest, handshakeType string) error {
+	pid, err := peer.Decode(request.PeerId)
+	if err != nil {
+		return fmt.Errorf("invalid peer id `%s`, err = %v", request.PeerId, err)
+	}
+
+	hs, err := fs.newHandshake(request.Id, handshakeType)
+	if err != nil {
+		return err
+	}
+	return fs.send(pid, hs)
+}
+
+func (fs *FriendService) newHandshake(id string, handshakeType string) (*FriendHandshake, error) {
+	hs := &FriendHandshake{
+		Id:         id,
+		Type:       handshakeType,
+		SenderID:   fs.host.ID().String(),
+		SenderNick: fs.Nick(),
+		Timestamp:  time.Now().UnixMilli(),
+	}
+	if err := signHandshake(fs.key, hs); err != nil {
+		return nil, fmt.Errorf("sign handshake failed, err = %v", err)
+	}
+	return hs, nil
+}
+
+func (fs *FriendService) send(pid peer.ID, hs *FriendHandshake) error {
+	ctx, cancel := context.WithTimeout(fs.ctx, friendAckTimeout)
+	defer cancel()
+
+	s, err := fs.host.NewStream(ctx, pid, FriendProtocol)
+	if err != nil {
+		return fmt.Errorf("open stream to `%s` failed, err = %v", pid, err)
+	}
+	defer s.Close()
+
+	if err = writeJSONLine(s, hs); err != nil {
+		s.Reset()
+		return fmt.Errorf("send %s to `%s` failed, err = %v", hs.Type, pid, err)
+	}
+
+	_ = s.SetReadDeadline(time.Now().Add(friendAckTimeout))
+	line, err := bufio.NewReader(io.LimitReader(s, friendMaxLineSize)).ReadBytes('\n')
+	if err != nil {
+		s.Reset()
+		return fmt.Errorf("wait ack from `%s` failed, err = %v", pid, err)
+	}
+
+	ack := new(DirectAck)
+	if err = json.Unmarshal(line, ack); err != nil {
+		return fmt.Errorf("unmarshal ack from `%s` failed, err = %v", pid, err)
+	}
+	if ack.Id != hs.Id {
+		return fmt.Errorf("ack id(`%s`) mismatch with handshake id(`%s`)", ack.Id, hs.Id)
+	}
+	if ack.Status != FRIEND_STATUS_RECEIVED {
+		return fmt.Errorf("`%s` refused the %s", pid, hs.Type)
+	}
+
+	return nil
+}
+
+// signHandshake 对握手消息签名，签名覆盖除 Sign 之外的所有字段
+func signHandshake(key crypto.PrivKey, hs *FriendHandshake) error {
+	return signJSON(key, hs, &hs.PubKey, &hs.Sign)
+}
+
+// authenticateHandshake 校验握手消息的签名，以及公钥和发送者 id 是否匹配
+func authenticateHandshake(hs *FriendHandshake) error {
+	return verifyJSON(hs, &hs.Sign, hs.SenderID, hs.PubKey)
+}
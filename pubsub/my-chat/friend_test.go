@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newSignedHandshake(t *testing.T, handshakeType string) *FriendHandshake {
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	assert.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(privKey)
+	assert.NoError(t, err)
+
+	hs := &FriendHandshake{
+		Id:         uuid.New().String(),
+		Type:       handshakeType,
+		SenderID:   id.String(),
+		SenderNick: "alice",
+		Timestamp:  time.Now().UnixMilli(),
+	}
+	assert.NoError(t, signHandshake(privKey, hs))
+	return hs
+}
+
+func TestAuthenticateHandshake(t *testing.T) {
+	hs := newSignedHandshake(t, FRIEND_REQUEST)
+	assert.NoError(t, authenticateHandshake(hs))
+}
+
+func TestAuthenticateHandshake_TamperedType(t *testing.T) {
+	// 把拒绝篡改为接受
+	hs := newSignedHandshake(t, FRIEND_REJECT)
+	hs.Type = FRIEND_ACCEPT
+	assert.Error(t, authenticateHandshake(hs))
+}
+
+func TestAuthenticateHandshake_ForgedSender(t *testing.T) {
+	hs := newSignedHandshake(t, FRIEND_REQUEST)
+	other := newSignedHandshake(t, FRIEND_REQUEST)
+
+	hs.SenderID = other.SenderID
+	assert.Error(t, authenticateHandshake(hs))
+}
+
+func TestHandleHandshakeLimitsRequests(t *testing.T) {
+	fs := newTestAPIServer(t).friends
+
+	// 界面不读取 Events，处理握手也不能阻塞
+	var first *FriendHandshake
+	for i := 0; i < maxPendingFriendRequests; i++ {
+		hs := newSignedHandshake(t, FRIEND_REQUEST)
+		assert.NoError(t, fs.handleHandshake(mustDecodeID(t, hs.SenderID), hs))
+		if first == nil {
+			first = hs
+		}
+	}
+	assert.Len(t, fs.Events, FriendBufSize)
+
+	hs := newSignedHandshake(t, FRIEND_REQUEST)
+	assert.Error(t, fs.handleHandshake(mustDecodeID(t, hs.SenderID), hs))
+
+	// 已有请求的节点可以重新发送
+	assert.NoError(t, fs.handleHandshake(mustDecodeID(t, first.SenderID), first))
+}
+
+func mustDecodeID(t *testing.T, id string) peer.ID {
+	pid, err := peer.Decode(id)
+	assert.NoError(t, err)
+	return pid
+}
@@ -5,12 +5,19 @@ import (
 	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
 	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
 	libp2p_crypto "github.com/libp2p/go-libp2p/core/crypto"
+	"sort"
+	"sync"
 )
 
 var (
 	myAccount *account.Account
 	myFriends map[string]*links.Friend
 	myGroups  map[string]*links.Group
+
+	// 好友列表会在网络协议的回调中被修改
+	friendsMu sync.RWMutex
+	// 群列表会被界面和接口服务同时访问
+	groupsMu sync.RWMutex
 )
 
 func GetPrivateKey(passphrase string) (libp2p_crypto.PrivKey, error) {
@@ -41,6 +48,9 @@ func GetMyFriends(myId string) (map[string]*links.Friend, error) {
 		return nil, fmt.Errorf("请先登录账号，再加载好友列表")
 	}
 
+	friendsMu.Lock()
+	defer friendsMu.Unlock()
+
 	if myFriends == nil {
 		myFriends, err = links.LoadMyFriends(myId)
 		if err != nil {
@@ -58,6 +68,9 @@ func GetMyGroups(myId string) (map[string]*links.Group, error) {
 		return nil, fmt.Errorf("请先登录账号，再加载群列表")
 	}
 
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+
 	if myGroups == nil {
 		myGroups, err = links.LoadMyGroup(myId)
 		if err != nil {
@@ -65,5 +78,107 @@ func GetMyGroups(myId string) (map[string]*links.Group, error) {
 		}
 	}
 
-	return myGroups, nil
+	// 返回副本，调用者遍历时不需要持有锁
+	groups := make(map[string]*links.Group, len(myGroups))
+	for id, group := range myGroups {
+		groups[id] = group
+	}
+	return groups, nil
+}
+
+func IsMyFriend(id string) bool {
+	friendsMu.RLock()
+	defer friendsMu.RUnlock()
+
+	if myFriends == nil {
+		return false
+	}
+
+	_, ok := myFriends[id]
+	return ok
+}
+
+// FindMyFriend 按照 id 或昵称查找好友
+func FindMyFriend(idOrNickname string) (*links.Friend, bool) {
+	friendsMu.RLock()
+	defer friendsMu.RUnlock()
+
+	// 返回副本，避免调用者读到并发修改中的好友信息
+	if friend, ok := myFriends[idOrNickname]; ok {
+		f := *friend
+		return &f, true
+	}
+
+	for _, friend := range myFriends {
+		if friend.Nickname == idOrNickname {
+			f := *friend
+			return &f, true
+		}
+	}
+
+	return nil, false
+}
+
+// ListMyFriends 返回按昵称排序的好友列表
+func ListMyFriends() []*links.Friend {
+	friendsMu.RLock()
+	defer friendsMu.RUnlock()
+
+	friends := make([]*links.Friend, 0, len(myFriends))
+	for _, friend := range myFriends {
+		f := *friend
+		friends = append(friends, &f)
+	}
+	sort.Slice(friends, func(i, j int) bool {
+		return friends[i].Nickname < friends[j].Nickname
+	})
+	return friends
+}
+
+// AddMyGroup 将新加入的群放入缓存
+func AddMyGroup(group *links.Group) {
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+
+	if myGroups == nil {
+		myGroups = make(map[string]*links.Group)
+	}
+	myGroups[group.Id] = group
+}
+
+// RemoveMyGroup 将退出的群移出缓存
+func RemoveMyGroup(groupId string) {
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+
+	delete(myGroups, groupId)
+}
+
+// AddMyFriend 将新添加的好友放入缓存
+func AddMyFriend(friend *links.Friend) {
+	friendsMu.Lock()
+	defer friendsMu.Unlock()
+
+	if myFriends == nil {
+		myFriends = make(map[string]*links.Friend)
+	}
+	myFriends[friend.Id] = friend
+}
+
+// RemoveMyFriend 将删除的好友移出缓存
+func RemoveMyFriend(id string) {
+	friendsMu.Lock()
+	defer friendsMu.Unlock()
+
+	delete(myFriends, id)
+}
+
+// SetMyFriendPubKey 更新缓存中好友的公钥
+func SetMyFriendPubKey(id string, pubKey []byte) {
+	friendsMu.Lock()
+	defer friendsMu.Unlock()
+
+	if friend, ok := myFriends[id]; ok {
+		friend.PubKey = pubKey
+	}
 }
@@ -0,0 +1,169 @@
+package history
+
+import (
+	db2 "github.com/czh0526/libp2p-examples/pubsub/my-chat/db"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/db/model"
+	"gorm.io/gorm"
+)
+
+const (
+	STATE_SENDING   = 0
+	STATE_SENT      = 1
+	STATE_DELIVERED = 2
+	STATE_QUEUED    = 3
+	STATE_FAILED    = 4
+	STATE_RECEIVED  = 5
+	STATE_REQUEST   = 6
+	// 对方不在线，消息交给了存储转发节点
+	STATE_STORED = 7
+)
+
+type Message struct {
+	Id         string `json:"id"`
+	Owner      string `json:"owner"`
+	Room       string `json:"room,omitempty"`
+	Peer       string `json:"peer,omitempty"`
+	SenderID   string `json:"sender_id"`
+	SenderNick string `json:"sender_nick"`
+	Content    string `json:"content"`
+	Timestamp  int64  `json:"timestamp"`
+	State      int32  `json:"state"`
+}
+
+func (m *Message) LoadFromModel(messageModel *model.Message) error {
+	m.Id = messageModel.ID
+	m.Owner = messageModel.Owner
+	m.Room = messageModel.Room
+	m.Peer = messageModel.Peer
+	m.SenderID = messageModel.SenderID
+	m.SenderNick = messageModel.SenderNick
+	m.Content = messageModel.Content
+	m.Timestamp = messageModel.Timestamp
+	m.State = messageModel.State
+	return nil
+}
+
+func (m *Message) SaveToModel() *model.Message {
+	return &model.Message{
+		ID:         m.Id,
+		Owner:      m.Owner,
+		Room:       m.Room,
+		Peer:       m.Peer,
+		SenderID:   m.SenderID,
+		SenderNick: m.SenderNick,
+		Content:    m.Content,
+		Timestamp:  m.Timestamp,
+		State:      m.State,
+	}
+}
+
+// SaveMessage 保存一条聊天记录
+func SaveMessage(message *Message) error {
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	if err = tx.Create(message.SaveToModel()).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// UpdateState 更新消息的投递状态
+func UpdateState(owner string, id string, state int32) error {
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	session := db.Session(&gorm.Session{})
+	return session.Model(&model.Message{}).
+		Where("owner = ? AND id = ?", owner, id).
+		Update("state", state).Error
+}
+
+// DeleteMessages 删除{ids}对应的消息
+func DeleteMessages(owner string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	session := db.Session(&gorm.Session{})
+	return session.Where("owner = ? AND id IN ?", owner, ids).
+		Delete(&model.Message{}).Error
+}
+
+// LoadRoomMessages 加载聊天室中时间早于{before}的最近{limit}条消息，按时间正序排列。
+// {before}为 0 时，从最新的消息开始加载。
+// MessageExists 判断消息是否已经保存过，用于过滤重复投递的消息
+func MessageExists(owner string, id string) (bool, error) {
+	db, err := db2.GetDB()
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	session := db.Session(&gorm.Session{})
+	err = session.Model(&model.Message{}).
+		Where("owner = ? AND id = ?", owner, id).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func LoadRoomMessages(owner string, room string, before int64, limit int) ([]*Message, error) {
+	return loadMessages(owner, "room = ?", room, before, limit)
+}
+
+// LoadPeerMessages 加载与{peerId}私聊的、时间早于{before}的最近{limit}条消息，按时间正序排列。
+func LoadPeerMessages(owner string, peerId string, before int64, limit int) ([]*Message, error) {
+	return loadMessages(owner, "peer = ?", peerId, before, limit)
+}
+
+// LoadDirectMessages 加载所有私聊中时间早于{before}的最近{limit}条消息，按时间正序排列。
+func LoadDirectMessages(owner string, before int64, limit int) ([]*Message, error) {
+	return loadMessages(owner, "peer <> ''", nil, before, limit)
+}
+
+func loadMessages(owner string, query string, arg interface{}, before int64, limit int) ([]*Message, error) {
+	db, err := db2.GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	session := db.Session(&gorm.Session{})
+	tx := session.Model(&model.Message{}).Where("owner = ?", owner)
+	if arg != nil {
+		tx = tx.Where(query, arg)
+	} else {
+		tx = tx.Where(query)
+	}
+	if before > 0 {
+		tx = tx.Where("timestamp < ?", before)
+	}
+
+	var messageList []*model.Message
+	err = tx.Order("timestamp desc").Limit(limit).Find(&messageList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// 倒序查询，正序返回
+	messages := make([]*Message, len(messageList))
+	for i, messageModel := range messageList {
+		message := &Message{}
+		if err = message.LoadFromModel(messageModel); err != nil {
+			return nil, err
+		}
+		messages[len(messageList)-1-i] = message
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,88 @@
+package links
+
+import (
+	db2 "github.com/czh0526/libp2p-examples/pubsub/my-chat/db"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/db/model"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// PeerAddr 是地址簿中的一项：连接成功过的节点及其地址
+type PeerAddr struct {
+	Owner    string   `json:"owner"`
+	PeerId   string   `json:"peerId"`
+	Addrs    []string `json:"addrs"`
+	LastSeen int64    `json:"lastSeen"`
+}
+
+func (p *PeerAddr) LoadFromModel(addrModel *model.PeerAddr) error {
+	p.Owner = addrModel.Owner
+	p.PeerId = addrModel.PeerID
+	p.Addrs = strings.Fields(addrModel.Addrs)
+	p.LastSeen = addrModel.LastSeen
+	return nil
+}
+
+func (p *PeerAddr) SaveToModel() *model.PeerAddr {
+	return &model.PeerAddr{
+		Owner:    p.Owner,
+		PeerID:   p.PeerId,
+		Addrs:    strings.Join(p.Addrs, " "),
+		LastSeen: p.LastSeen,
+	}
+}
+
+// SavePeerAddrs 保存节点的地址，每个节点只保留最新的一条记录
+func SavePeerAddrs(peerAddr *PeerAddr) error {
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	err = tx.Where("owner = ? AND peer_id = ?", peerAddr.Owner, peerAddr.PeerId).Delete(&model.PeerAddr{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Create(peerAddr.SaveToModel()).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// LoadPeerAddrs 加载最近连接过的{limit}个节点
+func LoadPeerAddrs(myId string, limit int) ([]*PeerAddr, error) {
+	db, err := db2.GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	session := db.Session(&gorm.Session{})
+	var addrList []*model.PeerAddr
+	err = session.Where("owner = ?", myId).Order("last_seen desc").Limit(limit).Find(&addrList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	peerAddrs := make([]*PeerAddr, 0, len(addrList))
+	for _, addrModel := range addrList {
+		peerAddr := &PeerAddr{}
+		_ = peerAddr.LoadFromModel(addrModel)
+		peerAddrs = append(peerAddrs, peerAddr)
+	}
+
+	return peerAddrs, nil
+}
+
+// PrunePeerAddrs 删除{before}之前最后一次连接的节点
+func PrunePeerAddrs(myId string, before int64) error {
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	return db.Where("owner = ? AND last_seen < ?", myId, before).Delete(&model.PeerAddr{}).Error
+}
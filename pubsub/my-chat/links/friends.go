@@ -5,6 +5,7 @@ import (
 	"fmt"
 	db2 "github.com/czh0526/libp2p-examples/pubsub/my-chat/db"
 	"github.com/czh0526/libp2p-examples/pubsub/my-chat/db/model"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"gorm.io/gorm"
 )
 
@@ -12,12 +13,15 @@ type Friend struct {
 	Id       string `json:"id"`
 	Nickname string `json:"nickname"`
 	Owner    string `json:"owner"`
+	// 好友的公钥，用于加密离线消息
+	PubKey []byte `json:"pubKey,omitempty"`
 }
 
 func (f *Friend) LoadFromModel(friendModel *model.Friend) error {
 	f.Id = friendModel.ID
 	f.Nickname = friendModel.Nickname
 	f.Owner = friendModel.Owner
+	f.PubKey = friendModel.PubKey
 	return nil
 }
 
@@ -26,6 +30,7 @@ func (f *Friend) SaveToModel() *model.Friend {
 		ID:       f.Id,
 		Nickname: f.Nickname,
 		Owner:    f.Owner,
+		PubKey:   f.PubKey,
 	}
 }
 
@@ -57,7 +62,7 @@ func LoadMyFriends(myId string) (map[string]*Friend, error) {
 	return friends, nil
 }
 
-func GetFriend(id string) (*Friend, error) {
+func GetFriend(myId string, id string) (*Friend, error) {
 	db, err := db2.GetDB()
 	if err != nil {
 		return nil, err
@@ -65,7 +70,7 @@ func GetFriend(id string) (*Friend, error) {
 
 	session := db.Session(&gorm.Session{})
 	var friendModel model.Friend
-	err = session.Where("id = ?", id).First(&friendModel).Error
+	err = session.Where("owner = ? AND id = ?", myId, id).First(&friendModel).Error
 	if err != nil {
 		return nil, err
 	}
@@ -77,9 +82,16 @@ func GetFriend(id string) (*Friend, error) {
 }
 
 func AddFriend(myId string, friend *Friend) error {
-	friend, err := GetFriend(friend.Id)
+	if _, err := peer.Decode(friend.Id); err != nil {
+		return fmt.Errorf("invalid peer id `%s`, err = %v", friend.Id, err)
+	}
+	if friend.Id == myId {
+		return fmt.Errorf("can not add myself as a friend")
+	}
+
+	existing, err := GetFriend(myId, friend.Id)
 	if err == nil {
-		return fmt.Errorf("%s(`%s`) has already be added", friend.Nickname, friend.Id)
+		return fmt.Errorf("%s(`%s`) has already be added", existing.Nickname, existing.Id)
 	}
 	if !errors.Is(err, gorm.ErrRecordNotFound) {
 		return fmt.Errorf("find `%s` failed, err = %v", friend.Nickname, err)
@@ -95,6 +107,69 @@ func AddFriend(myId string, friend *Friend) error {
 	return nil
 }
 
+// RemoveFriend 删除好友
+func RemoveFriend(myId string, id string) error {
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	result := tx.Where("owner = ? AND id = ?", myId, id).Delete(&model.Friend{})
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return gorm.ErrRecordNotFound
+	}
+
+	return tx.Commit().Error
+}
+
+// RenameFriend 修改好友的昵称
+func RenameFriend(myId string, id string, nickname string) error {
+	if len(nickname) == 0 {
+		return fmt.Errorf("nickname is required")
+	}
+
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	result := tx.Model(&model.Friend{}).Where("owner = ? AND id = ?", myId, id).Update("nickname", nickname)
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return gorm.ErrRecordNotFound
+	}
+
+	return tx.Commit().Error
+}
+
+// SetFriendPubKey 记录好友的公钥
+func SetFriendPubKey(myId string, id string, pubKey []byte) error {
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	err = tx.Model(&model.Friend{}).Where("owner = ? AND id = ?", myId, id).Update("pub_key", pubKey).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
 func createFriend(friendModel *model.Friend) error {
 	db, err := db2.GetDB()
 	if err != nil {
@@ -1,15 +1,26 @@
 package links
 
 import (
+	"errors"
+	"fmt"
 	db2 "github.com/czh0526/libp2p-examples/pubsub/my-chat/db"
 	"github.com/czh0526/libp2p-examples/pubsub/my-chat/db/model"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+const (
+	// 公开群：主题由群名决定，任何知道群名的人都可以加入
+	GROUP_TYPE_OPEN int32 = 0
+	// 邀请群：主题是随机生成的，只接收成员发出的消息
+	GROUP_TYPE_INVITE_ONLY int32 = 1
+)
+
 type Group struct {
 	Id    string `json:"id"`
 	Name  string `json:"name"`
 	Type  int32  `json:"type"`
+	Topic string `json:"topic"`
 	Owner string `json:"owner"`
 }
 
@@ -17,6 +28,7 @@ func (g *Group) LoadFromModel(groupModel *model.Group) error {
 	g.Id = groupModel.ID
 	g.Name = groupModel.Name
 	g.Type = groupModel.Type
+	g.Topic = groupModel.Topic
 	g.Owner = groupModel.Owner
 	return nil
 }
@@ -26,10 +38,15 @@ func (g *Group) SaveToGroup() *model.Group {
 		ID:    g.Id,
 		Name:  g.Name,
 		Type:  g.Type,
+		Topic: g.Topic,
 		Owner: g.Owner,
 	}
 }
 
+func (g *Group) IsInviteOnly() bool {
+	return g.Type == GROUP_TYPE_INVITE_ONLY
+}
+
 func LoadMyGroup(myId string) (map[string]*Group, error) {
 	db, err := db2.GetDB()
 	if err != nil {
@@ -58,6 +75,150 @@ func LoadMyGroup(myId string) (map[string]*Group, error) {
 	return groups, nil
 }
 
+func GetGroup(myId string, id string) (*Group, error) {
+	db, err := db2.GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	session := db.Session(&gorm.Session{})
+	var groupModel model.Group
+	err = session.Where("owner = ? AND id = ?", myId, id).First(&groupModel).Error
+	if err != nil {
+		return nil, err
+	}
+
+	group := &Group{}
+	_ = group.LoadFromModel(&groupModel)
+
+	return group, nil
+}
+
+// CreateGroup 创建一个新群，并为其分配主题
+func CreateGroup(myId string, name string, groupType int32) (*Group, error) {
+	if len(name) == 0 {
+		return nil, fmt.Errorf("group name is required")
+	}
+
+	group := &Group{
+		Id:   uuid.New().String(),
+		Name: name,
+		Type: groupType,
+	}
+	switch groupType {
+	case GROUP_TYPE_OPEN:
+		group.Topic = name
+	case GROUP_TYPE_INVITE_ONLY:
+		group.Topic = uuid.New().String()
+	default:
+		return nil, fmt.Errorf("unknown group type %d", groupType)
+	}
+
+	if err := JoinGroup(myId, group); err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// JoinGroup 加入一个群，保存群信息，并将自己记录为群成员
 func JoinGroup(myId string, group *Group) error {
-	return nil
+	if len(group.Id) == 0 || len(group.Topic) == 0 {
+		return fmt.Errorf("group id and topic are required")
+	}
+
+	_, err := GetGroup(myId, group.Id)
+	if err == nil {
+		return fmt.Errorf("group %s(`%s`) has already be joined", group.Name, group.Id)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("find group `%s` failed, err = %v", group.Name, err)
+	}
+
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	group.Owner = myId
+	tx := db.Begin()
+	if err = tx.Create(group.SaveToGroup()).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	member := &model.GroupMember{GroupID: group.Id, MemberID: myId, Owner: myId}
+	if err = tx.Create(member).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// LeaveGroup 退出群，删除群信息和成员列表
+func LeaveGroup(myId string, groupId string) error {
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	err = tx.Where("owner = ? AND id = ?", myId, groupId).Delete(&model.Group{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Where("owner = ? AND group_id = ?", myId, groupId).Delete(&model.GroupMember{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func AddGroupMember(myId string, groupId string, memberId string) error {
+	members, err := LoadGroupMembers(myId, groupId)
+	if err != nil {
+		return err
+	}
+	if members[memberId] {
+		return fmt.Errorf("`%s` is already a member of group `%s`", memberId, groupId)
+	}
+
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	member := &model.GroupMember{GroupID: groupId, MemberID: memberId, Owner: myId}
+	if err = tx.Create(member).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// LoadGroupMembers 加载群成员的 id 集合
+func LoadGroupMembers(myId string, groupId string) (map[string]bool, error) {
+	db, err := db2.GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	session := db.Session(&gorm.Session{})
+	var memberList []*model.GroupMember
+	err = session.Where("owner = ? AND group_id = ?", myId, groupId).Find(&memberList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]bool, len(memberList))
+	for _, memberModel := range memberList {
+		members[memberModel.MemberID] = true
+	}
+
+	return members, nil
 }
@@ -0,0 +1,198 @@
+package links
+
+import (
+	"errors"
+	db2 "github.com/czh0526/libp2p-examples/pubsub/my-chat/db"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/db/model"
+	"gorm.io/gorm"
+)
+
+const (
+	// 别人发给我的好友请求
+	FRIEND_REQUEST_INCOMING int32 = 0
+	// 我发给别人的好友请求
+	FRIEND_REQUEST_OUTGOING int32 = 1
+)
+
+const (
+	FRIEND_REQUEST_PENDING  int32 = 0
+	FRIEND_REQUEST_ACCEPTED int32 = 1
+	FRIEND_REQUEST_REJECTED int32 = 2
+)
+
+type FriendRequest struct {
+	Id        string `json:"id"`
+	Owner     string `json:"owner"`
+	PeerId    string `json:"peerId"`
+	Nickname  string `json:"nickname"`
+	Direction int32  `json:"direction"`
+	State     int32  `json:"state"`
+	Timestamp int64  `json:"timestamp"`
+	PubKey    []byte `json:"pubKey,omitempty"`
+}
+
+func (r *FriendRequest) LoadFromModel(requestModel *model.FriendRequest) error {
+	r.Id = requestModel.ID
+	r.Owner = requestModel.Owner
+	r.PeerId = requestModel.PeerID
+	r.Nickname = requestModel.Nickname
+	r.Direction = requestModel.Direction
+	r.State = requestModel.State
+	r.Timestamp = requestModel.Timestamp
+	r.PubKey = requestModel.PubKey
+	return nil
+}
+
+func (r *FriendRequest) SaveToModel() *model.FriendRequest {
+	return &model.FriendRequest{
+		ID:        r.Id,
+		Owner:     r.Owner,
+		PeerID:    r.PeerId,
+		Nickname:  r.Nickname,
+		Direction: r.Direction,
+		State:     r.State,
+		Timestamp: r.Timestamp,
+		PubKey:    r.PubKey,
+	}
+}
+
+// LoadPendingRequests 加载尚未处理的好友请求，按时间排序
+func LoadPendingRequests(myId string, direction int32) ([]*FriendRequest, error) {
+	db, err := db2.GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	session := db.Session(&gorm.Session{})
+	var requestList []*model.FriendRequest
+	err = session.Where("owner = ? AND direction = ? AND state = ?", myId, direction, FRIEND_REQUEST_PENDING).
+		Order("timestamp").Find(&requestList).Error
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]*FriendRequest, 0, len(requestList))
+	for _, requestModel := range requestList {
+		request := &FriendRequest{}
+		_ = request.LoadFromModel(requestModel)
+		requests = append(requests, request)
+	}
+
+	return requests, nil
+}
+
+// CountPendingRequests 返回尚未处理的好友请求数
+func CountPendingRequests(myId string, direction int32) (int64, error) {
+	db, err := db2.GetDB()
+	if err != nil {
+		return 0, err
+	}
+
+	session := db.Session(&gorm.Session{})
+	var count int64
+	err = session.Model(&model.FriendRequest{}).
+		Where("owner = ? AND direction = ? AND state = ?", myId, direction, FRIEND_REQUEST_PENDING).
+		Count(&count).Error
+	return count, err
+}
+
+// GetPendingRequest 查找与某个节点之间尚未处理的好友请求
+func GetPendingRequest(myId string, peerId string, direction int32) (*FriendRequest, error) {
+	db, err := db2.GetDB()
+	if err != nil {
+		return nil, err
+	}
+
+	session := db.Session(&gorm.Session{})
+	var requestModel model.FriendRequest
+	err = session.Where("owner = ? AND peer_id = ? AND direction = ? AND state = ?",
+		myId, peerId, direction, FRIEND_REQUEST_PENDING).First(&requestModel).Error
+	if err != nil {
+		return nil, err
+	}
+
+	request := &FriendRequest{}
+	_ = request.LoadFromModel(&requestModel)
+
+	return request, nil
+}
+
+// SaveFriendRequest 保存好友请求，同一个节点同一方向只保留最新的一条待处理请求
+func SaveFriendRequest(request *FriendRequest) error {
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	err = tx.Where("owner = ? AND peer_id = ? AND direction = ? AND state = ?",
+		request.Owner, request.PeerId, request.Direction, FRIEND_REQUEST_PENDING).
+		Delete(&model.FriendRequest{}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Create(request.SaveToModel()).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// RejectFriendRequest 将好友请求标记为已拒绝
+func RejectFriendRequest(myId string, requestId string) error {
+	return updateRequestState(myId, requestId, FRIEND_REQUEST_REJECTED)
+}
+
+// AcceptFriendRequest 将好友请求标记为已接受，并添加好友
+func AcceptFriendRequest(myId string, request *FriendRequest, friend *Friend) error {
+	_, err := GetFriend(myId, friend.Id)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	alreadyFriend := err == nil
+
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	err = tx.Model(&model.FriendRequest{}).Where("owner = ? AND id = ?", myId, request.Id).
+		Update("state", FRIEND_REQUEST_ACCEPTED).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !alreadyFriend {
+		friend.Owner = myId
+		if err = tx.Create(friend.SaveToModel()).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+func updateRequestState(myId string, requestId string, state int32) error {
+	db, err := db2.GetDB()
+	if err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	result := tx.Model(&model.FriendRequest{}).Where("owner = ? AND id = ?", myId, requestId).
+		Update("state", state)
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return gorm.ErrRecordNotFound
+	}
+
+	return tx.Commit().Error
+}
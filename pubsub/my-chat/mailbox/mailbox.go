@@ -0,0 +1,160 @@
+package mailbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"time"
+)
+
+// 存储转发节点的协议：
+// store 由发件人调用，把加密的消息交给节点保管；
+// fetch 由收件人上线后调用，节点只把发给流对端的消息交给它。
+const (
+	StoreProtocol = "/my-chat/mailbox/store/1.0.0"
+	FetchProtocol = "/my-chat/mailbox/fetch/1.0.0"
+)
+
+const (
+	STATUS_STORED   = "stored"
+	STATUS_REJECTED = "rejected"
+)
+
+const (
+	// 单条消息的最大长度
+	MaxPayloadSize = 64 * 1024
+	// 每个收件人最多保管的消息数
+	MaxEnvelopesPerPeer = 1024
+	// 每个发件人最多存放的消息数和字节数，防止一个节点占满所有的空间
+	MaxEnvelopesPerSender = 256
+	MaxBytesPerSender     = 4 * 1024 * 1024
+	// 节点总共保管的字节数和收件人数
+	MaxTotalBytes = 256 * 1024 * 1024
+	MaxRecipients = 4096
+
+	streamTimeout = 10 * time.Second
+)
+
+// Envelope 是交给存储转发节点保管的一条消息，Payload 是用收件人公钥加密过的内容
+type Envelope struct {
+	Id        string
+	Recipient string
+	Payload   []byte
+	// 过期时间（毫秒），由节点按照自己的 TTL 设置
+	Expire int64 `json:",omitempty"`
+
+	// 发件人，节点用来计算配额，不发给收件人
+	sender string
+}
+
+type StoreAck struct {
+	Id     string
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+type FetchResponse struct {
+	Envelopes []*Envelope
+}
+
+// FetchAck 告诉节点哪些消息已经收到，可以删除；节点删除后原样回复，表示取件完成
+type FetchAck struct {
+	Ids []string
+}
+
+// Store 把{env}交给存储转发节点{mailbox}保管
+func Store(ctx context.Context, h host.Host, mailbox peer.ID, env *Envelope) error {
+	ctx, cancel := context.WithTimeout(ctx, streamTimeout)
+	defer cancel()
+
+	s, err := h.NewStream(ctx, mailbox, StoreProtocol)
+	if err != nil {
+		return fmt.Errorf("open stream to mailbox `%s` failed, err = %v", mailbox, err)
+	}
+	defer s.Close()
+
+	if err = writeJSONLine(s, env); err != nil {
+		s.Reset()
+		return fmt.Errorf("send envelope to mailbox `%s` failed, err = %v", mailbox, err)
+	}
+
+	ack := new(StoreAck)
+	if err = readJSONLine(s, bufio.NewReader(s), ack); err != nil {
+		s.Reset()
+		return fmt.Errorf("wait ack from mailbox `%s` failed, err = %v", mailbox, err)
+	}
+	if ack.Id != env.Id {
+		return fmt.Errorf("ack id(`%s`) mismatch with envelope id(`%s`)", ack.Id, env.Id)
+	}
+	if ack.Status != STATUS_STORED {
+		return fmt.Errorf("mailbox `%s` rejected the envelope: %s", mailbox, ack.Error)
+	}
+
+	return nil
+}
+
+// Fetch 从存储转发节点{mailbox}取回发给自己的消息。
+// {handle}返回 true 的消息会被确认，节点随后将其删除。
+func Fetch(ctx context.Context, h host.Host, mailbox peer.ID, handle func(env *Envelope) bool) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, streamTimeout)
+	defer cancel()
+
+	s, err := h.NewStream(ctx, mailbox, FetchProtocol)
+	if err != nil {
+		return 0, fmt.Errorf("open stream to mailbox `%s` failed, err = %v", mailbox, err)
+	}
+	defer s.Close()
+
+	reader := bufio.NewReader(s)
+	resp := new(FetchResponse)
+	if err = readJSONLine(s, reader, resp); err != nil {
+		s.Reset()
+		return 0, fmt.Errorf("fetch from mailbox `%s` failed, err = %v", mailbox, err)
+	}
+
+	ack := &FetchAck{}
+	for _, env := range resp.Envelopes {
+		if handle(env) {
+			ack.Ids = append(ack.Ids, env.Id)
+		}
+	}
+
+	if err = writeJSONLine(s, ack); err != nil {
+		s.Reset()
+		return 0, fmt.Errorf("ack to mailbox `%s` failed, err = %v", mailbox, err)
+	}
+
+	// 等待节点确认已经删除
+	done := new(FetchAck)
+	if err = readJSONLine(s, reader, done); err != nil {
+		s.Reset()
+		return 0, fmt.Errorf("wait mailbox `%s` to finish failed, err = %v", mailbox, err)
+	}
+
+	return len(done.Ids), nil
+}
+
+func writeJSONLine(s network.Stream, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_ = s.SetWriteDeadline(time.Now().Add(streamTimeout))
+	_, err = s.Write(append(data, '\n'))
+	return err
+}
+
+// readJSONLine 从{reader}读取一行 json，同一个流上的多次读取要共用同一个{reader}
+func readJSONLine(s network.Stream, reader *bufio.Reader, v interface{}) error {
+	_ = s.SetReadDeadline(time.Now().Add(streamTimeout))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
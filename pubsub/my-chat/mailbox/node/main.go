@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/mailbox"
+	"github.com/czh0526/libp2p-examples/utils"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"time"
+)
+
+var (
+	portFlag = flag.Int("port", 8090, "port to listen on")
+	ttlFlag  = flag.Duration("ttl", 72*time.Hour, "how long to hold messages for offline peers")
+	keyFlag  = flag.String("key", "privkey.pem", "private key file, created if not exists")
+)
+
+func makeHost() (host.Host, error) {
+	priv, err := utils.GeneratePrivateKey(*keyFlag)
+	if err != nil {
+		return nil, fmt.Errorf("get private key failed: err = %v", err)
+	}
+
+	return libp2p.New(
+		libp2p.Identity(priv),
+		libp2p.ListenAddrStrings(
+			fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", *portFlag)),
+	)
+}
+
+func main() {
+	flag.Parse()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := makeHost()
+	if err != nil {
+		panic(fmt.Sprintf("create mailbox node failed: %v", err))
+	}
+	defer h.Close()
+
+	mailbox.NewServer(ctx, h, *ttlFlag)
+
+	fmt.Printf("peer.ID = %v\n", h.ID())
+	fmt.Printf("ttl = %v\n", *ttlFlag)
+	fmt.Println("peer addresses: ")
+	for _, addr := range h.Addrs() {
+		fmt.Printf("\t=> %v/p2p/%v\n", addr, h.ID())
+	}
+
+	<-ctx.Done()
+}
@@ -0,0 +1,120 @@
+package mailbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	libp2p_crypto "github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+const sealInfo = "my-chat mailbox v1"
+
+// Seal 用收件人的 ECDSA 公钥加密{plaintext}，只有收件人能够解开。
+// 每条消息生成一个临时密钥，与收件人公钥做 ECDH，再用 HKDF 派生 AES-256-GCM 密钥。
+// 输出格式：临时公钥 || nonce || 密文
+func Seal(recipient libp2p_crypto.PubKey, plaintext []byte) ([]byte, error) {
+	stdKey, err := libp2p_crypto.PubKeyToStdKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := stdKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("only ECDSA keys are supported, got %s", recipient.Type())
+	}
+	recipientKey, err := ecdsaKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("convert recipient key failed, err = %v", err)
+	}
+
+	ephemeral, err := recipientKey.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	gcm, err := newSealGCM(shared, ephemeralPub, recipientKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	box := make([]byte, 0, len(ephemeralPub)+len(nonce)+len(plaintext)+gcm.Overhead())
+	box = append(box, ephemeralPub...)
+	box = append(box, nonce...)
+	return gcm.Seal(box, nonce, plaintext, ephemeralPub), nil
+}
+
+// Open 用收件人的私钥解开 Seal 加密的数据
+func Open(priv libp2p_crypto.PrivKey, box []byte) ([]byte, error) {
+	stdKey, err := libp2p_crypto.PrivKeyToStdKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	ecdsaKey, ok := stdKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("only ECDSA keys are supported, got %s", priv.Type())
+	}
+	recipientKey, err := ecdsaKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("convert private key failed, err = %v", err)
+	}
+
+	pubLen := len(recipientKey.PublicKey().Bytes())
+	if len(box) < pubLen {
+		return nil, fmt.Errorf("sealed box too short")
+	}
+	ephemeralPub := box[:pubLen]
+	ephemeral, err := recipientKey.Curve().NewPublicKey(ephemeralPub)
+	if err != nil {
+		return nil, fmt.Errorf("parse ephemeral key failed, err = %v", err)
+	}
+	shared, err := recipientKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newSealGCM(shared, ephemeralPub, recipientKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(box) < pubLen+gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed box too short")
+	}
+	nonce := box[pubLen : pubLen+gcm.NonceSize()]
+
+	plaintext, err := gcm.Open(nil, nonce, box[pubLen+gcm.NonceSize():], ephemeralPub)
+	if err != nil {
+		return nil, fmt.Errorf("open sealed box failed, err = %v", err)
+	}
+	return plaintext, nil
+}
+
+// newSealGCM 从 ECDH 共享密钥派生加密密钥，两个公钥都参与派生
+func newSealGCM(shared []byte, ephemeralPub []byte, recipientPub []byte) (cipher.AEAD, error) {
+	info := append([]byte(sealInfo), ephemeralPub...)
+	info = append(info, recipientPub...)
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, nil, info), key); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,52 @@
+package mailbox
+
+import (
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	priv, pub, err := crypto.GenerateECDSAKeyPair(nil)
+	assert.NoError(t, err)
+
+	box, err := Seal(pub, []byte("hello"))
+	assert.NoError(t, err)
+
+	plaintext, err := Open(priv, box)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(plaintext))
+}
+
+func TestOpen_WrongKey(t *testing.T) {
+	_, pub, err := crypto.GenerateECDSAKeyPair(nil)
+	assert.NoError(t, err)
+	other, _, err := crypto.GenerateECDSAKeyPair(nil)
+	assert.NoError(t, err)
+
+	box, err := Seal(pub, []byte("hello"))
+	assert.NoError(t, err)
+
+	_, err = Open(other, box)
+	assert.Error(t, err)
+}
+
+func TestOpen_Tampered(t *testing.T) {
+	priv, pub, err := crypto.GenerateECDSAKeyPair(nil)
+	assert.NoError(t, err)
+
+	box, err := Seal(pub, []byte("hello"))
+	assert.NoError(t, err)
+	box[len(box)-1] ^= 0xff
+
+	_, err = Open(priv, box)
+	assert.Error(t, err)
+}
+
+func TestSeal_UnsupportedKey(t *testing.T) {
+	_, pub, err := crypto.GenerateEd25519Key(nil)
+	assert.NoError(t, err)
+
+	_, err = Seal(pub, []byte("hello"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,248 @@
+package mailbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Server 是存储转发节点，替不在线的收件人保管加密的消息，直到收件人上线取走或者消息过期
+type Server struct {
+	host host.Host
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	boxes map[string][]*Envelope
+	// 每个发件人占用的配额
+	senders map[string]*quota
+	// 保管的消息的总字节数
+	size int
+}
+
+// quota 是一个发件人还没有被取走的消息
+type quota struct {
+	count int
+	bytes int
+}
+
+func NewServer(ctx context.Context, h host.Host, ttl time.Duration) *Server {
+	srv := newServer(ttl)
+	srv.host = h
+	h.SetStreamHandler(StoreProtocol, srv.onStore)
+	h.SetStreamHandler(FetchProtocol, srv.onFetch)
+	go srv.expireLoop(ctx)
+	return srv
+}
+
+func newServer(ttl time.Duration) *Server {
+	return &Server{
+		ttl:     ttl,
+		boxes:   make(map[string][]*Envelope),
+		senders: make(map[string]*quota),
+	}
+}
+
+func (srv *Server) onStore(s network.Stream) {
+	defer s.Close()
+	remote := s.Conn().RemotePeer()
+
+	// 限制读取的长度，防止被大消息撑爆内存
+	_ = s.SetReadDeadline(time.Now().Add(streamTimeout))
+	reader := bufio.NewReader(io.LimitReader(s, 2*MaxPayloadSize))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		log.Printf("[mailbox] read envelope from %s failed, err = %v", remote, err)
+		s.Reset()
+		return
+	}
+
+	env := new(Envelope)
+	if err = json.Unmarshal(line, env); err != nil {
+		log.Printf("[mailbox] unmarshal envelope from %s failed, err = %v", remote, err)
+		s.Reset()
+		return
+	}
+
+	ack := &StoreAck{Id: env.Id, Status: STATUS_STORED}
+	if err = srv.store(remote.String(), env); err != nil {
+		log.Printf("[mailbox] reject envelope(`%s`) from %s, err = %v", env.Id, remote, err)
+		ack.Status = STATUS_REJECTED
+		ack.Error = err.Error()
+	} else {
+		log.Printf("[mailbox] store envelope(`%s`) from %s for %s", env.Id, remote, env.Recipient)
+	}
+
+	if err = writeJSONLine(s, ack); err != nil {
+		log.Printf("[mailbox] send ack to %s failed, err = %v", remote, err)
+	}
+}
+
+// store 保管{sender}发来的消息，超出收件人、发件人或者节点的配额时拒绝
+func (srv *Server) store(sender string, env *Envelope) error {
+	if len(env.Id) == 0 {
+		return fmt.Errorf("envelope id is required")
+	}
+	if _, err := peer.Decode(env.Recipient); err != nil {
+		return fmt.Errorf("invalid recipient `%s`", env.Recipient)
+	}
+	if len(env.Payload) == 0 || len(env.Payload) > MaxPayloadSize {
+		return fmt.Errorf("payload size %d out of range", len(env.Payload))
+	}
+	env.Expire = time.Now().Add(srv.ttl).UnixMilli()
+	env.sender = sender
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	box, ok := srv.boxes[env.Recipient]
+	for _, stored := range box {
+		// 发件人重试时不重复保存
+		if stored.Id == env.Id {
+			return nil
+		}
+	}
+	if len(box) >= MaxEnvelopesPerPeer {
+		return fmt.Errorf("mailbox of `%s` is full", env.Recipient)
+	}
+	if !ok && len(srv.boxes) >= MaxRecipients {
+		return fmt.Errorf("too many recipients")
+	}
+	q := srv.senders[sender]
+	if q == nil {
+		q = new(quota)
+	}
+	if q.count >= MaxEnvelopesPerSender || q.bytes+len(env.Payload) > MaxBytesPerSender {
+		return fmt.Errorf("quota of sender `%s` exceeded", sender)
+	}
+	if srv.size+len(env.Payload) > MaxTotalBytes {
+		return fmt.Errorf("mailbox is full")
+	}
+
+	srv.boxes[env.Recipient] = append(box, env)
+	srv.senders[sender] = q
+	q.count++
+	q.bytes += len(env.Payload)
+	srv.size += len(env.Payload)
+	return nil
+}
+
+// release 归还{env}占用的配额，调用者需要持有锁
+func (srv *Server) release(env *Envelope) {
+	srv.size -= len(env.Payload)
+	q := srv.senders[env.sender]
+	if q == nil {
+		return
+	}
+	q.count--
+	q.bytes -= len(env.Payload)
+	if q.count <= 0 {
+		delete(srv.senders, env.sender)
+	}
+}
+
+func (srv *Server) onFetch(s network.Stream) {
+	defer s.Close()
+	// 流的对端经过了 libp2p 的身份认证，只能取走发给自己的消息
+	recipient := s.Conn().RemotePeer().String()
+
+	now := time.Now().UnixMilli()
+	var envelopes []*Envelope
+	srv.mu.Lock()
+	for _, env := range srv.boxes[recipient] {
+		if env.Expire > now {
+			envelopes = append(envelopes, env)
+		}
+	}
+	srv.mu.Unlock()
+
+	if err := writeJSONLine(s, &FetchResponse{Envelopes: envelopes}); err != nil {
+		log.Printf("[mailbox] deliver to %s failed, err = %v", recipient, err)
+		s.Reset()
+		return
+	}
+
+	ack := new(FetchAck)
+	if err := readJSONLine(s, bufio.NewReader(s), ack); err != nil {
+		log.Printf("[mailbox] wait ack from %s failed, err = %v", recipient, err)
+		s.Reset()
+		return
+	}
+
+	ack.Ids = srv.remove(recipient, ack.Ids)
+	if err := writeJSONLine(s, ack); err != nil {
+		log.Printf("[mailbox] confirm to %s failed, err = %v", recipient, err)
+	}
+	log.Printf("[mailbox] delivered %d/%d envelopes to %s", len(ack.Ids), len(envelopes), recipient)
+}
+
+// remove 删除收件人确认过的消息，返回实际删除的消息 id
+func (srv *Server) remove(recipient string, ids []string) []string {
+	acked := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		acked[id] = true
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	var remain []*Envelope
+	var removed []string
+	for _, env := range srv.boxes[recipient] {
+		if acked[env.Id] {
+			removed = append(removed, env.Id)
+			srv.release(env)
+		} else {
+			remain = append(remain, env)
+		}
+	}
+	if len(remain) == 0 {
+		delete(srv.boxes, recipient)
+	} else {
+		srv.boxes[recipient] = remain
+	}
+	return removed
+}
+
+// expireLoop 定期清理过期的消息
+func (srv *Server) expireLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			srv.expire(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (srv *Server) expire(now time.Time) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for recipient, box := range srv.boxes {
+		var remain []*Envelope
+		for _, env := range box {
+			if env.Expire > now.UnixMilli() {
+				remain = append(remain, env)
+			} else {
+				srv.release(env)
+			}
+		}
+		if len(remain) == 0 {
+			delete(srv.boxes, recipient)
+		} else {
+			srv.boxes[recipient] = remain
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package mailbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestHost(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = h.Close() })
+	return h
+}
+
+func connect(t *testing.T, ctx context.Context, h host.Host, to host.Host) {
+	assert.NoError(t, h.Connect(ctx, peer.AddrInfo{ID: to.ID(), Addrs: to.Addrs()}))
+}
+
+func TestStoreAndFetch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, sender, recipient, other := newTestHost(t), newTestHost(t), newTestHost(t), newTestHost(t)
+	NewServer(ctx, server, time.Hour)
+	connect(t, ctx, sender, server)
+	connect(t, ctx, recipient, server)
+	connect(t, ctx, other, server)
+
+	env := &Envelope{Id: "1", Recipient: recipient.ID().String(), Payload: []byte("sealed")}
+	assert.NoError(t, Store(ctx, sender, server.ID(), env))
+
+	// 别人取不走发给收件人的消息
+	n, err := Fetch(ctx, other, server.ID(), func(*Envelope) bool { return true })
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// 没有确认的消息继续保留
+	var fetched []*Envelope
+	n, err = Fetch(ctx, recipient, server.ID(), func(e *Envelope) bool {
+		fetched = append(fetched, e)
+		return false
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Len(t, fetched, 1)
+	assert.Equal(t, []byte("sealed"), fetched[0].Payload)
+
+	n, err = Fetch(ctx, recipient, server.ID(), func(*Envelope) bool { return true })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = Fetch(ctx, recipient, server.ID(), func(*Envelope) bool { return true })
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestStore_Rejected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, sender := newTestHost(t), newTestHost(t)
+	NewServer(ctx, server, time.Hour)
+	connect(t, ctx, sender, server)
+
+	env := &Envelope{Id: "1", Recipient: "not-a-peer-id", Payload: []byte("sealed")}
+	assert.Error(t, Store(ctx, sender, server.ID(), env))
+}
+
+func TestExpire(t *testing.T) {
+	srv := newServer(time.Minute)
+	recipient := newTestHost(t).ID().String()
+	assert.NoError(t, srv.store("sender", &Envelope{Id: "1", Recipient: recipient, Payload: []byte("sealed")}))
+
+	srv.expire(time.Now())
+	assert.Len(t, srv.boxes[recipient], 1)
+
+	srv.expire(time.Now().Add(2 * time.Minute))
+	assert.Len(t, srv.boxes, 0)
+	assert.Len(t, srv.senders, 0)
+	assert.Equal(t, 0, srv.size)
+}
+
+func TestStore_Quota(t *testing.T) {
+	srv := newServer(time.Minute)
+	recipients := []string{newTestHost(t).ID().String(), newTestHost(t).ID().String()}
+	payload := make([]byte, MaxBytesPerSender/MaxEnvelopesPerSender)
+
+	// 一个发件人发给不同收件人的消息共用配额
+	for i := 0; i < MaxEnvelopesPerSender; i++ {
+		env := &Envelope{Id: fmt.Sprintf("%d", i), Recipient: recipients[i%2], Payload: payload}
+		assert.NoError(t, srv.store("spammer", env))
+	}
+	assert.Error(t, srv.store("spammer", &Envelope{Id: "more", Recipient: recipients[0], Payload: payload}))
+	// 重试已经保存的消息不占用配额
+	assert.NoError(t, srv.store("spammer", &Envelope{Id: "0", Recipient: recipients[0], Payload: payload}))
+	// 其他发件人不受影响
+	assert.NoError(t, srv.store("friend", &Envelope{Id: "1", Recipient: recipients[0], Payload: payload}))
+
+	// 收件人取走之后归还配额
+	assert.Len(t, srv.remove(recipients[0], []string{"0", "2"}), 2)
+	assert.Equal(t, MaxEnvelopesPerSender-2, srv.senders["spammer"].count)
+	assert.NoError(t, srv.store("spammer", &Envelope{Id: "more", Recipient: recipients[0], Payload: payload}))
+	assert.Equal(t, MaxEnvelopesPerSender*len(payload), srv.size)
+}
+
+func TestStore_TotalLimits(t *testing.T) {
+	srv := newServer(time.Minute)
+	recipient := newTestHost(t).ID().String()
+
+	srv.size = MaxTotalBytes - 1
+	assert.Error(t, srv.store("sender", &Envelope{Id: "1", Recipient: recipient, Payload: []byte("xx")}))
+
+	srv.size = 0
+	for i := 0; i < MaxRecipients; i++ {
+		srv.boxes[fmt.Sprintf("recipient-%d", i)] = nil
+	}
+	assert.Error(t, srv.store("sender", &Envelope{Id: "1", Recipient: recipient, Payload: []byte("xx")}))
+}
@@ -4,44 +4,60 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/config"
 	"github.com/czh0526/libp2p-examples/pubsub/my-chat/global"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
-	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
-	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
-	"time"
+	"syscall"
 )
 
 var (
-	nickFlag = flag.String("nick", "", "nickname to use in chat")
-	passFlag = flag.String("pass", "", "password to use in login")
-	roomFlag = flag.String("room", "awesome-chat-room", "name of chat room to join")
+	nickFlag      = flag.String("nick", "", "nickname to use in chat")
+	passFlag      = flag.String("pass", "", "password to use in login")
+	roomFlag      = flag.String("room", "awesome-chat-room", "name of chat room to join")
+	mailboxFlag   = flag.String("mailbox", "", "comma separated multiaddrs of store-and-forward peers")
+	peersFlag     = flag.Int("peers", DefaultTargetPeers, "number of room peers to keep connected")
+	discoveryFlag = flag.String("discovery", DISCOVERY_DHT, "comma separated discovery modes: dht, mdns")
+	wireFlag      = flag.String("wire", WIRE_JSON, "wire format of room messages: json, protobuf (only when every peer supports it)")
+
+	headlessFlag = flag.Bool("headless", false, "run without the UI and serve the HTTP API instead")
+	apiFlag      = flag.String("api", DefaultAPIAddr, "listen address of the HTTP API in headless mode")
+	apiTokenFlag = flag.String("api-token", "", "bearer token required by the HTTP API, defaults to $MY_CHAT_API_TOKEN or a random token printed at startup")
+
+	homeFlag   = flag.String("home", "", "home directory, defaults to $MY_CHAT_HOME or $XDG_DATA_HOME/my-chat")
+	dbFlag     = flag.String("db", "", "database file, defaults to $MY_CHAT_DB or <home>/db/my-chat.db")
+	keyDirFlag = flag.String("key-dir", "", "private key directory, defaults to $MY_CHAT_KEY_DIR or <home>/data/account")
 )
 
-func loadMyData(nickname string) error {
+func loadMyData(nickname string) (string, map[string]*links.Group, error) {
 
 	myAccount, err := global.GetMyAccount(nickname)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
 	_, err = global.GetMyFriends(myAccount.Id)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
-	_, err = global.GetMyGroups(myAccount.Id)
+	groups, err := global.GetMyGroups(myAccount.Id)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
-	return nil
+	return myAccount.Id, groups, nil
 }
 
 func main() {
@@ -51,9 +67,26 @@ func main() {
 	nickname := *nickFlag
 	passphrase := *passFlag
 	room := *roomFlag
+	useDHT, useMDNS, err := parseDiscoveryModes(*discoveryFlag)
+	if err != nil {
+		panic(fmt.Sprintf("解析节点发现方式出错: %v", err))
+	}
+	if err = SetWireFormat(*wireFlag); err != nil {
+		panic(fmt.Sprintf("解析消息格式出错: %v", err))
+	}
+
+	// 确定数据目录
+	err = config.Init(&config.Options{
+		HomeDir: *homeFlag,
+		DBPath:  *dbFlag,
+		KeyDir:  *keyDirFlag,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("初始化配置出错: %v", err))
+	}
 
 	// 加载账号信息
-	err := loadMyData(nickname)
+	myId, groups, err := loadMyData(nickname)
 	if err != nil {
 		panic(fmt.Sprintf("加载数据出错: %v", err))
 	}
@@ -72,8 +105,8 @@ func main() {
 	}
 
 	// 生成日志
-	file, err := os.OpenFile(fmt.Sprintf(
-		"chat_%s.log", shortID(h.ID())), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(filepath.Join(config.HomeDir, fmt.Sprintf(
+		"chat_%s.log", shortID(h.ID()))), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		panic(err)
 	}
@@ -81,7 +114,31 @@ func main() {
 	log.SetOutput(file)
 
 	// 启动节点发现模块
-	go discoverPeers(ctx, h)
+	settings, err := config.LoadSettings()
+	if err != nil {
+		panic(fmt.Sprintf("加载配置文件出错: %v", err))
+	}
+	var kadDHT *dht.IpfsDHT
+	if useDHT {
+		kadDHT, err = dht.New(ctx, h)
+		if err != nil {
+			panic(fmt.Sprintf("创建DHT失败，err = %v", err))
+		}
+	}
+	rendezvous := []string{room}
+	for _, group := range groups {
+		rendezvous = append(rendezvous, group.Topic)
+	}
+	discovery := NewDiscoveryService(ctx, h, kadDHT, rendezvous, *peersFlag)
+	go discovery.Run(bootstrapPeers(settings))
+	if useMDNS {
+		if err = discovery.StartMDNS(); err != nil {
+			panic(fmt.Sprintf("启动mDNS失败，err = %v", err))
+		}
+		defer discovery.Close()
+	}
+	// 同时重连上次会话中连接过的节点
+	go redialCachedPeers(ctx, h, myId)
 
 	// 创建订阅服务
 	ps, err := pubsub.NewGossipSub(ctx, h)
@@ -90,76 +147,138 @@ func main() {
 	}
 
 	// 创建聊天室
-	chatroom, err := JoinChatRoom(ctx, ps, h.ID(), nickname, room)
+	chatroom, err := JoinChatRoom(ctx, ps, privKey, nickname, room)
 	if err != nil {
 		panic(fmt.Sprintf("加入聊天室失败，err = %v", err))
 	}
+	chatrooms := []*ChatRoom{chatroom}
 
-	// 创建UI界面
-	ui := NewChatUI(chatroom)
-	if err = ui.Run(); err != nil {
+	// 加入所有的群
+	for _, group := range sortGroups(groups) {
+		if group.Topic == room {
+			continue
+		}
+		groupRoom, err := JoinGroupChatRoom(ctx, ps, privKey, nickname, group)
+		if err != nil {
+			panic(fmt.Sprintf("加入群`%s`失败，err = %v", group.Name, err))
+		}
+		chatrooms = append(chatrooms, groupRoom)
+	}
 
+	// 创建私聊服务
+	mailboxes, err := parseMailboxes(*mailboxFlag)
+	if err != nil {
+		panic(fmt.Sprintf("解析存储转发节点出错: %v", err))
 	}
-}
+	dm := NewDMService(ctx, h, privKey, nickname, mailboxes)
+
+	// 创建好友请求服务
+	friends := NewFriendService(ctx, h, privKey, nickname)
 
-func discoverPeers(ctx context.Context, h host.Host) {
-	kadDHT := initDHT(ctx, h)
-	routingDiscovery := drouting.NewRoutingDiscovery(kadDHT)
-	dutil.Advertise(ctx, routingDiscovery, *roomFlag)
+	// 创建文件传输服务
+	files := NewFileService(ctx, h, settings.FileSizeLimit(), config.DownloadDir)
 
-	anyConnected := false
-	for !anyConnected {
-		log.Println("searching for peers...")
-		peerChan, err := routingDiscovery.FindPeers(ctx, *roomFlag)
+	// 记录已连接节点的地址，下次启动时直接重连
+	recordCtx, stopRecord := context.WithCancel(ctx)
+	go recordPeersLoop(recordCtx, h, myId, chatrooms)
+
+	if *headlessFlag {
+		// 无界面模式，收到退出信号时结束
+		apiCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		token := *apiTokenFlag
+		if len(token) == 0 {
+			token = os.Getenv(config.ENV_API_TOKEN)
+		}
+		api, err := NewAPIServer(chatrooms, dm, friends, discovery, token)
 		if err != nil {
-			panic(err)
+			panic(fmt.Sprintf("创建 API 服务失败，err = %v", err))
 		}
-		for p := range peerChan {
-			if p.ID == h.ID() {
-				continue
-			}
-			err := h.Connect(ctx, p)
-			if err != nil {
-				log.Printf("Failed connecting to peer(`%s`), err = %v\n", p.ID, err)
-			} else {
-				log.Printf("Connected to peer(`%s`)\n", p.ID)
-				anyConnected = true
-			}
+		fmt.Printf("my-chat API listening on http://%s\n", *apiFlag)
+		if len(token) == 0 {
+			// 没有指定 token 时每次启动生成新的 token
+			fmt.Printf("API token: %s\n", api.Token())
 		}
-		time.Sleep(10 * time.Second)
+		if err = api.Run(apiCtx, *apiFlag); err != nil {
+			log.Printf("run api server failed, err = %v", err)
+			printErr("run api server failed, err = %v\n", err)
+		}
+		stop()
+		chatrooms = api.listRooms()
+	} else {
+		// 创建UI界面
+		ui := NewChatUI(chatrooms, dm, friends, discovery, files)
+		if err = ui.Run(); err != nil {
+
+		}
+		chatrooms = ui.listRooms()
 	}
-	log.Println("Peer discovery complete")
-}
 
-func initDHT(ctx context.Context, h host.Host) *dht.IpfsDHT {
-	kadDHT, err := dht.New(ctx, h)
-	if err != nil {
-		panic(err)
+	stopRecord()
+	recordPeers(h, myId, chatrooms)
+
+	// 退出聊天室，通知其它节点自己已经离线
+	for _, chatroom := range chatrooms {
+		if err = chatroom.Leave(); err != nil {
+			log.Printf("leave room `%s` failed, err = %v", chatroom.roomName, err)
+		}
 	}
-	if err = kadDHT.Bootstrap(ctx); err != nil {
-		panic(err)
+
+	// 记住本次会话中可用的引导节点，下次启动时使用
+	if kadDHT != nil {
+		if err = rememberBootstrapPeers(h, kadDHT); err != nil {
+			log.Printf("save bootstrap peers failed, err = %v", err)
+		}
 	}
+}
 
-	var wg sync.WaitGroup
-	for _, peerAddr := range BOOTSTRAP_PEERS {
-		peerInfo, err := peer.AddrInfoFromP2pAddr(peerAddr)
-		if err != nil {
-			log.Printf("parse bootstrap peer address failed, err = %v \n", err)
+// parseMailboxes 解析逗号分隔的存储转发节点地址
+func parseMailboxes(addrs string) ([]peer.AddrInfo, error) {
+	var mailboxes []peer.AddrInfo
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if len(addr) == 0 {
 			continue
 		}
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mailbox address `%s`, err = %v", addr, err)
+		}
+		mailboxes = append(mailboxes, *info)
+	}
+	return mailboxes, nil
+}
+
+// sortGroups 将群按名称排序，保证每次启动时聊天室的顺序一致
+func sortGroups(groups map[string]*links.Group) []*links.Group {
+	groupList := make([]*links.Group, 0, len(groups))
+	for _, group := range groups {
+		groupList = append(groupList, group)
+	}
+	sort.Slice(groupList, func(i, j int) bool {
+		return groupList[i].Name < groupList[j].Name
+	})
+	return groupList
+}
+
+// initDHT 连接引导节点，并启动 DHT
+func initDHT(ctx context.Context, h host.Host, kadDHT *dht.IpfsDHT, bootstrap []peer.AddrInfo) {
+	var wg sync.WaitGroup
+	for _, peerInfo := range bootstrap {
 		wg.Add(1)
-		go func() {
+		go func(peerInfo peer.AddrInfo) {
 			defer wg.Done()
-			if err := h.Connect(ctx, *peerInfo); err != nil {
+			if err := h.Connect(ctx, peerInfo); err != nil {
 				log.Printf("connect bootstrap peer failed, err = %v \n", err)
 			} else {
 				log.Printf("Connected to bootstrap peer %s\n", peerInfo.ID)
 			}
-		}()
+		}(peerInfo)
 	}
 	wg.Wait()
 
-	return kadDHT
+	if err := kadDHT.Bootstrap(ctx); err != nil {
+		log.Printf("bootstrap dht failed, err = %v \n", err)
+	}
 }
 
 func shortID(pid peer.ID) string {
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/global"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// 启动时最多重连的节点数
+	maxCachedPeers = 64
+	// 超过这个时间没有连接过的节点不再重连
+	cachedPeerTTL = 30 * 24 * time.Hour
+	// 记录节点地址的间隔
+	recordPeersInterval = 5 * time.Minute
+
+	redialTimeout = 10 * time.Second
+)
+
+// redialCachedPeers 重连地址簿中最近连接过的节点，不依赖 DHT 和引导节点
+func redialCachedPeers(ctx context.Context, h host.Host, myId string) {
+	if err := links.PrunePeerAddrs(myId, time.Now().Add(-cachedPeerTTL).UnixMilli()); err != nil {
+		log.Printf("prune cached peers failed, err = %v \n", err)
+	}
+
+	peerAddrs, err := links.LoadPeerAddrs(myId, maxCachedPeers)
+	if err != nil {
+		log.Printf("load cached peers failed, err = %v \n", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, peerAddr := range peerAddrs {
+		peerInfo, err := cachedAddrInfo(peerAddr)
+		if err != nil {
+			log.Printf("parse cached peer `%s` failed, err = %v \n", peerAddr.PeerId, err)
+			continue
+		}
+		if peerInfo.ID == h.ID() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(peerInfo peer.AddrInfo) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, redialTimeout)
+			defer cancel()
+			if err := h.Connect(ctx, peerInfo); err != nil {
+				log.Printf("redial cached peer(`%s`) failed, err = %v \n", peerInfo.ID, err)
+			} else {
+				log.Printf("Connected to cached peer(`%s`)\n", peerInfo.ID)
+			}
+		}(peerInfo)
+	}
+	wg.Wait()
+}
+
+func cachedAddrInfo(peerAddr *links.PeerAddr) (peer.AddrInfo, error) {
+	pid, err := peer.Decode(peerAddr.PeerId)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+
+	peerInfo := peer.AddrInfo{ID: pid}
+	for _, addr := range peerAddr.Addrs {
+		multiAddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return peer.AddrInfo{}, err
+		}
+		peerInfo.Addrs = append(peerInfo.Addrs, multiAddr)
+	}
+	return peerInfo, nil
+}
+
+// recordPeersLoop 定期把已连接的好友和聊天室成员的地址记入地址簿，退出前再记录一次
+func recordPeersLoop(ctx context.Context, h host.Host, myId string, chatrooms []*ChatRoom) {
+	ticker := time.NewTicker(recordPeersInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			recordPeers(h, myId, chatrooms)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordPeers 记录当前已连接的好友和聊天室成员的地址
+func recordPeers(h host.Host, myId string, chatrooms []*ChatRoom) {
+	peers := make(map[peer.ID]bool)
+	for _, chatroom := range chatrooms {
+		for _, pid := range chatroom.ListPeers() {
+			peers[pid] = true
+		}
+	}
+	for _, pid := range h.Network().Peers() {
+		if global.IsMyFriend(pid.String()) {
+			peers[pid] = true
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	for pid := range peers {
+		if h.Network().Connectedness(pid) != network.Connected {
+			continue
+		}
+
+		var addrs []string
+		for _, addr := range h.Peerstore().Addrs(pid) {
+			addrs = append(addrs, addr.String())
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+
+		err := links.SavePeerAddrs(&links.PeerAddr{
+			Owner:    myId,
+			PeerId:   pid.String(),
+			Addrs:    addrs,
+			LastSeen: now,
+		})
+		if err != nil {
+			log.Printf("save addrs of peer(`%s`) failed, err = %v \n", pid, err)
+		}
+	}
+}
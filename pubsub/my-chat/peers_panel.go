@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/global"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ma "github.com/multiformats/go-multiaddr"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// 测量节点延迟的间隔
+	pingInterval = 15 * time.Second
+	pingTimeout  = 5 * time.Second
+)
+
+// peerRow 是节点面板中的一行
+type peerRow struct {
+	id        peer.ID
+	nick      string
+	friend    bool
+	direction string
+	transport string
+	latency   time.Duration
+	// 在线状态，旧版本的节点没有
+	status string
+}
+
+func (row *peerRow) String() string {
+	name := shortID(row.id)
+	if len(row.nick) > 0 {
+		name = row.nick
+	}
+	if row.friend {
+		name = withColor("green", "*"+name)
+	}
+	switch row.status {
+	case PRESENCE_ONLINE:
+		name = withColor("green", "●") + name
+	case PRESENCE_AWAY:
+		name = withColor("yellow", "●") + name
+	case PRESENCE_OFFLINE:
+		name = withColor("gray", "●") + name
+	}
+
+	latency := "-"
+	if row.latency > 0 {
+		latency = fmt.Sprintf("%dms", row.latency.Milliseconds())
+	}
+	return fmt.Sprintf("%s %s/%s %s", name, row.direction, row.transport, latency)
+}
+
+// rememberNick 记录从消息中得知的节点昵称
+func (ui *ChatUI) rememberNick(id string, nick string) {
+	if len(id) == 0 || len(nick) == 0 {
+		return
+	}
+	ui.nicks[id] = nick
+}
+
+// peerRows 收集当前聊天室中节点的昵称、连接方式和延迟，好友排在前面
+func (ui *ChatUI) peerRows() []*peerRow {
+	rv := ui.currentRoom()
+	if rv == nil {
+		return nil
+	}
+
+	var rows []*peerRow
+	for _, pid := range rv.room.ListPeers() {
+		row := newPeerRow(ui.dm.host, pid, ui.nicks[pid.String()])
+		row.status = ui.peerStatus(pid.String())
+		rows = append(rows, row)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].friend != rows[j].friend {
+			return rows[i].friend
+		}
+		return rows[i].String() < rows[j].String()
+	})
+	return rows
+}
+
+// newPeerRow 收集节点的连接方式和延迟，好友使用好友的昵称，否则使用{nick}
+func newPeerRow(h host.Host, pid peer.ID, nick string) *peerRow {
+	row := &peerRow{
+		id:        pid,
+		nick:      nick,
+		direction: "-",
+		transport: "-",
+		latency:   h.Peerstore().LatencyEWMA(pid),
+	}
+	if friend, ok := global.FindMyFriend(pid.String()); ok {
+		row.friend = true
+		row.nick = friend.Nickname
+	}
+	if conns := h.Network().ConnsToPeer(pid); len(conns) > 0 {
+		row.direction = connDirection(conns[0])
+		row.transport = connTransport(conns[0])
+	}
+	return row
+}
+
+func connDirection(conn network.Conn) string {
+	switch conn.Stat().Direction {
+	case network.DirInbound:
+		return "in"
+	case network.DirOutbound:
+		return "out"
+	default:
+		return "-"
+	}
+}
+
+func connTransport(conn network.Conn) string {
+	addr := conn.RemoteMultiaddr()
+	if _, err := addr.ValueForProtocol(ma.P_CIRCUIT); err == nil {
+		return "relayed"
+	}
+	if _, err := addr.ValueForProtocol(ma.P_QUIC_V1); err == nil {
+		return "quic"
+	}
+	if _, err := addr.ValueForProtocol(ma.P_QUIC); err == nil {
+		return "quic"
+	}
+	if _, err := addr.ValueForProtocol(ma.P_WS); err == nil {
+		return "ws"
+	}
+	if _, err := addr.ValueForProtocol(ma.P_TCP); err == nil {
+		return "tcp"
+	}
+	return "-"
+}
+
+// selectPeer 处理在节点面板中选中的节点：好友打开私聊，陌生人发送好友请求
+func (ui *ChatUI) selectPeer(row *peerRow) {
+	if row.friend {
+		ui.input.SetText(fmt.Sprintf("/msg %s ", row.nick))
+	} else {
+		ui.input.SetText(fmt.Sprintf("/add %s", row.id))
+	}
+	ui.app.SetFocus(ui.input)
+}
+
+// pingPeers ping 所有聊天室中的节点，ping 服务会把延迟记录到 peerstore 中。
+// 由 handleEvents 定期调用，ui.rooms 只能在事件循环中访问。
+func (ui *ChatUI) pingPeers() {
+	peers := make(map[peer.ID]bool)
+	for _, rv := range ui.rooms {
+		for _, pid := range rv.room.ListPeers() {
+			peers[pid] = true
+		}
+	}
+	for pid := range peers {
+		go ui.ping(pid)
+	}
+}
+
+func (ui *ChatUI) ping(pid peer.ID) {
+	ctx, cancel := context.WithTimeout(ui.ctx, pingTimeout)
+	defer cancel()
+
+	res := <-ping.Ping(ctx, ui.dm.host, pid)
+	if res.Error != nil {
+		log.Printf("[ui] ping %s failed, err = %v", pid, res.Error)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/global"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// 最多每隔多久发送一次正在输入
+	typingInterval = 3 * time.Second
+	// 多久没有收到正在输入后不再显示
+	typingTimeout = 5 * time.Second
+	// 多久没有输入后变成离开状态
+	awayTimeout = 5 * time.Minute
+	// 批量发送已读回执的间隔
+	readReceiptInterval = 2 * time.Second
+	// 一条已读回执中最多的消息 id
+	readReceiptBatch = 64
+	// 每个聊天室最多记录的未发送回执的消息 id，超过时丢弃最早的
+	maxUnreadIds = 4 * readReceiptBatch
+)
+
+// peerPresence 是从心跳中得知的节点在线状态
+type peerPresence struct {
+	status   string
+	lastSeen time.Time
+	// 状态消息的发送时间，pubsub 不保证消息的顺序，旧的状态不能覆盖新的状态
+	timestamp int64
+}
+
+// updatePresence 处理聊天室中的控制消息：正在输入、在线状态和已读回执
+func (ui *ChatUI) updatePresence(rv *roomView, cm *ChatMessage) {
+	now := time.Now()
+	switch cm.Type {
+	case CHAT_TYPE_TYPING:
+		rv.typing[cm.SenderID] = now
+	case CHAT_TYPE_PRESENCE:
+		if p, ok := ui.presence[cm.SenderID]; ok && p.timestamp > cm.Timestamp {
+			return
+		}
+		ui.presence[cm.SenderID] = &peerPresence{status: cm.Status, lastSeen: now, timestamp: cm.Timestamp}
+		if cm.Status == PRESENCE_OFFLINE {
+			delete(rv.typing, cm.SenderID)
+		}
+	case CHAT_TYPE_READ:
+		for _, id := range cm.ReadIds {
+			if id == rv.lastSent {
+				rv.readBy[cm.SenderID] = true
+				break
+			}
+		}
+	default:
+		// 发消息的节点一定在线，并且已经输入完了
+		delete(rv.typing, cm.SenderID)
+		if p, ok := ui.presence[cm.SenderID]; ok {
+			p.lastSeen = now
+		}
+	}
+	ui.refreshRoomTitle(rv)
+}
+
+// peerStatus 返回节点的在线状态，没有收到过心跳的节点（例如旧版本）返回空
+func (ui *ChatUI) peerStatus(id string) string {
+	p, ok := ui.presence[id]
+	if !ok {
+		return ""
+	}
+	if time.Since(p.lastSeen) > 3*PresenceInterval {
+		return PRESENCE_OFFLINE
+	}
+	return p.status
+}
+
+// onInput 处理输入框的变化：通知当前聊天室正在输入，离开状态时恢复在线
+func (ui *ChatUI) onInput(text string) {
+	now := time.Now()
+	ui.lastActive = now
+	if ui.away {
+		ui.setPresence(PRESENCE_ONLINE)
+	}
+
+	rv := ui.currentRoom()
+	if rv == nil || len(text) == 0 || strings.HasPrefix(text, "/") {
+		return
+	}
+	if now.Sub(ui.lastTyping) < typingInterval {
+		return
+	}
+	ui.lastTyping = now
+	if err := rv.room.SendTyping(); err != nil {
+		log.Printf("[ui] send typing to `%s` failed, err = %v", rv.room.roomName, err)
+	}
+}
+
+// checkAway 长时间没有输入时，在所有聊天室中变成离开状态
+func (ui *ChatUI) checkAway() {
+	if !ui.away && time.Since(ui.lastActive) > awayTimeout {
+		ui.setPresence(PRESENCE_AWAY)
+	}
+}
+
+func (ui *ChatUI) setPresence(status string) {
+	ui.away = status == PRESENCE_AWAY
+	for _, rv := range ui.rooms {
+		if err := rv.room.SetPresence(status); err != nil {
+			log.Printf("[ui] send presence to `%s` failed, err = %v", rv.room.roomName, err)
+		}
+	}
+}
+
+// flushReadReceipts 为当前聊天室中已经显示的消息发送已读回执
+func (ui *ChatUI) flushReadReceipts() {
+	rv := ui.currentRoom()
+	if rv == nil || len(rv.unreadIds) == 0 {
+		return
+	}
+	// 发送失败时不再重试，回执只是提示，丢掉即可
+	ids := rv.unreadIds
+	rv.unreadIds = nil
+	for len(ids) > 0 {
+		n := min(len(ids), readReceiptBatch)
+		if err := rv.room.SendReadReceipt(ids[:n]); err != nil {
+			log.Printf("[ui] send read receipt to `%s` failed, err = %v", rv.room.roomName, err)
+			return
+		}
+		ids = ids[n:]
+	}
+}
+
+// addUnreadId 记录显示过的消息，等切换到聊天室之后发送回执
+func (rv *roomView) addUnreadId(id string) {
+	if len(rv.unreadIds) >= maxUnreadIds {
+		rv.unreadIds = append(rv.unreadIds[:0], rv.unreadIds[1:]...)
+	}
+	rv.unreadIds = append(rv.unreadIds, id)
+}
+
+// refreshRoomTitle 在聊天室的标题中显示谁正在输入，以及我的最后一条消息被谁读过
+func (ui *ChatUI) refreshRoomTitle(rv *roomView) {
+	title := fmt.Sprintf("Room: %s", rv.room.roomName)
+
+	var typing []string
+	for id, ts := range rv.typing {
+		if time.Since(ts) > typingTimeout {
+			delete(rv.typing, id)
+			continue
+		}
+		typing = append(typing, ui.displayName(id))
+	}
+	if len(typing) > 0 {
+		sort.Strings(typing)
+		title += fmt.Sprintf(" - %s typing...", strings.Join(typing, ", "))
+	}
+
+	if len(rv.readBy) > 0 {
+		var readers []string
+		for id := range rv.readBy {
+			readers = append(readers, ui.displayName(id))
+		}
+		sort.Strings(readers)
+		title += fmt.Sprintf(" - read by %s", strings.Join(readers, ", "))
+	}
+
+	ui.app.QueueUpdateDraw(func() {
+		rv.box.SetTitle(title)
+	})
+}
+
+// displayName 返回节点的昵称，好友使用好友的昵称，不知道昵称时返回缩短的 id
+func (ui *ChatUI) displayName(id string) string {
+	if friend, ok := global.FindMyFriend(id); ok {
+		return friend.Nickname
+	}
+	if nick, ok := ui.nicks[id]; ok {
+		return nick
+	}
+	if pid, err := peer.Decode(id); err == nil {
+		return shortID(pid)
+	}
+	return id
+}
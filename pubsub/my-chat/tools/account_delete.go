@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var deleteAccountCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "delete an account with its private key, friends, groups and messages",
+	Run:   deleteAccount,
+}
+
+func deleteAccount(cmd *cobra.Command, _ []string) {
+	nickname, _ := cmd.Flags().GetString("nick")
+	passphrase, _ := cmd.Flags().GetString("passphrase")
+	yes, _ := cmd.Flags().GetBool("yes")
+	if len(nickname) == 0 {
+		fmt.Println("获取参数出错：nickname is required")
+		os.Exit(1)
+	}
+	if !yes {
+		fmt.Println("删除账户无法恢复，请先用 `account export` 备份私钥，然后加上 --yes 确认删除")
+		os.Exit(1)
+	}
+
+	acc, err := account.GetAccount(nickname)
+	if err != nil {
+		fmt.Printf("查找账户`%s`出错，%v \n", nickname, err)
+		os.Exit(1)
+	}
+
+	if err = account.DeleteAccount(acc.Id, passphrase); err != nil {
+		fmt.Printf("删除账户出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("删除账户成功，id = %s \n", acc.Id)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	libp2p_crypto "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+const (
+	EXPORT_FORMAT_PEM    = "pem"
+	EXPORT_FORMAT_LIBP2P = "libp2p"
+)
+
+var exportAccountCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export the private key of an account",
+	Run:   exportAccount,
+}
+
+func exportAccount(cmd *cobra.Command, _ []string) {
+	nickname, _ := cmd.Flags().GetString("nick")
+	passphrase, _ := cmd.Flags().GetString("passphrase")
+	format, _ := cmd.Flags().GetString("format")
+	out, _ := cmd.Flags().GetString("out")
+	if len(nickname) == 0 {
+		fmt.Println("获取参数出错：nickname is required")
+		os.Exit(1)
+	}
+	if len(out) == 0 {
+		fmt.Println("获取参数出错：out is required")
+		os.Exit(1)
+	}
+
+	acc, err := account.GetAccount(nickname)
+	if err != nil {
+		fmt.Printf("查找账户`%s`出错，%v \n", nickname, err)
+		os.Exit(1)
+	}
+
+	privateKeyDer, err := account.ExportPrivateKey(acc.Id, passphrase)
+	if err != nil {
+		fmt.Printf("读取私钥出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	var content []byte
+	switch format {
+	case EXPORT_FORMAT_PEM:
+		content = pem.EncodeToMemory(&pem.Block{Type: account.PEM_TYPE_PLAIN, Bytes: privateKeyDer})
+	case EXPORT_FORMAT_LIBP2P:
+		// 与 utils.GeneratePrivateKey 生成的文件格式一致
+		privateKey, err := libp2p_crypto.UnmarshalECDSAPrivateKey(privateKeyDer)
+		if err != nil {
+			fmt.Printf("解析私钥出错，%v \n", err)
+			os.Exit(1)
+		}
+		content, err = libp2p_crypto.MarshalPrivateKey(privateKey)
+		if err != nil {
+			fmt.Printf("序列化私钥出错，%v \n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("获取参数出错：unknown format `%s` \n", format)
+		os.Exit(1)
+	}
+
+	// 导出的私钥是明文的，只允许当前用户读写
+	if err = os.WriteFile(out, content, 0600); err != nil {
+		fmt.Printf("写入私钥文件出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("导出私钥成功，file = %s \n", out)
+}
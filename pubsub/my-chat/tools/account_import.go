@@ -1 +1,76 @@
 package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var importAccountCmd = &cobra.Command{
+	Use:   "import",
+	Short: "import an account from an existing private key (pem or libp2p marshalled)",
+	Run:   importAccount,
+}
+
+type ImportAccountArgument struct {
+	Nickname         string
+	Phone            string
+	Passphrase       string
+	KeyFile          string
+	SourcePassphrase string
+}
+
+func fetchImportAccountArgs(cmd *cobra.Command) (*ImportAccountArgument, error) {
+	nickname, _ := cmd.Flags().GetString("nick")
+	phone, _ := cmd.Flags().GetString("phone")
+	passphrase, _ := cmd.Flags().GetString("passphrase")
+	keyFile, _ := cmd.Flags().GetString("key")
+	sourcePassphrase, _ := cmd.Flags().GetString("key-passphrase")
+
+	if len(nickname) == 0 {
+		return nil, fmt.Errorf("nickname is required")
+	}
+	if len(phone) == 0 {
+		return nil, fmt.Errorf("phone is required")
+	}
+	if len(keyFile) == 0 {
+		return nil, fmt.Errorf("key file is required")
+	}
+
+	return &ImportAccountArgument{
+		Nickname:         nickname,
+		Phone:            phone,
+		Passphrase:       passphrase,
+		KeyFile:          keyFile,
+		SourcePassphrase: sourcePassphrase,
+	}, nil
+}
+
+func importAccount(cmd *cobra.Command, _ []string) {
+	args, err := fetchImportAccountArgs(cmd)
+	if err != nil {
+		fmt.Printf("获取参数出错：%v \n", err)
+		os.Exit(1)
+	}
+
+	content, err := os.ReadFile(args.KeyFile)
+	if err != nil {
+		fmt.Printf("读取私钥文件出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	privateKeyDer, err := account.ParsePrivateKey(content, args.SourcePassphrase)
+	if err != nil {
+		fmt.Printf("解析私钥出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	id, err := account.ImportAccount(args.Nickname, args.Phone, args.Passphrase, privateKeyDer)
+	if err != nil {
+		fmt.Printf("导入账户出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("导入账户成功，id = %s \n", id)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+)
+
+var listAccountCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list all accounts",
+	Run:   listAccounts,
+}
+
+func listAccounts(cmd *cobra.Command, _ []string) {
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	accounts, err := account.LoadAccounts()
+	if err != nil {
+		fmt.Printf("加载账户列表出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	accountList := make([]*account.Account, 0, len(accounts))
+	for _, acc := range accounts {
+		accountList = append(accountList, acc)
+	}
+	sort.Slice(accountList, func(i, j int) bool {
+		return accountList[i].Nickname < accountList[j].Nickname
+	})
+
+	if asJSON {
+		printJSON(accountList)
+		return
+	}
+
+	rows := make([][]string, 0, len(accountList))
+	for _, acc := range accountList {
+		rows = append(rows, []string{acc.Nickname, acc.Phone, acc.Id})
+	}
+	printTable([]string{"NICKNAME", "PHONE", "ID"}, rows)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var migrateAccountCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "rewrite a legacy encrypted private key in the keystore format",
+	Run:   migrateAccount,
+}
+
+func migrateAccount(cmd *cobra.Command, _ []string) {
+	nickname, _ := cmd.Flags().GetString("nick")
+	passphrase, _ := cmd.Flags().GetString("passphrase")
+	if len(nickname) == 0 {
+		fmt.Println("获取参数出错：nickname is required")
+		os.Exit(1)
+	}
+
+	acc, err := account.GetAccount(nickname)
+	if err != nil {
+		fmt.Printf("查找账户`%s`出错，%v \n", nickname, err)
+		os.Exit(1)
+	}
+
+	migrated, err := account.MigrateLegacyKey(acc.Id, passphrase)
+	if err != nil {
+		fmt.Printf("迁移私钥出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	if migrated {
+		fmt.Printf("迁移私钥成功，id = %s \n", acc.Id)
+	} else {
+		fmt.Printf("私钥已经是新格式，id = %s \n", acc.Id)
+	}
+}
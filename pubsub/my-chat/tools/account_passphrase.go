@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var changePassphraseCmd = &cobra.Command{
+	Use:   "change-passphrase",
+	Short: "re-encrypt the private key of an account with a new passphrase",
+	Run:   changePassphrase,
+}
+
+func changePassphrase(cmd *cobra.Command, _ []string) {
+	nickname, _ := cmd.Flags().GetString("nick")
+	oldPassphrase, _ := cmd.Flags().GetString("passphrase")
+	newPassphrase, _ := cmd.Flags().GetString("new-passphrase")
+	if len(nickname) == 0 {
+		fmt.Println("获取参数出错：nickname is required")
+		os.Exit(1)
+	}
+
+	acc, err := account.GetAccount(nickname)
+	if err != nil {
+		fmt.Printf("查找账户`%s`出错，%v \n", nickname, err)
+		os.Exit(1)
+	}
+
+	if err = account.ChangePassphrase(acc.Id, oldPassphrase, newPassphrase); err != nil {
+		fmt.Printf("修改口令出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("修改口令成功，id = %s \n", acc.Id)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var showAccountCmd = &cobra.Command{
+	Use:   "show",
+	Short: "show details of an account",
+	Run:   showAccount,
+}
+
+type AccountDetail struct {
+	*account.Account
+	KeyFile   string `json:"keyFile"`
+	KeyFormat string `json:"keyFormat"`
+}
+
+func showAccount(cmd *cobra.Command, _ []string) {
+	nickname, _ := cmd.Flags().GetString("nick")
+	asJSON, _ := cmd.Flags().GetBool("json")
+	if len(nickname) == 0 {
+		fmt.Println("获取参数出错：nickname is required")
+		os.Exit(1)
+	}
+
+	acc, err := account.GetAccount(nickname)
+	if err != nil {
+		fmt.Printf("查找账户`%s`出错，%v \n", nickname, err)
+		os.Exit(1)
+	}
+
+	detail := &AccountDetail{Account: acc}
+	detail.KeyFile, err = account.GetPrivateKeyFile(acc.Id)
+	if err != nil {
+		fmt.Printf("查找私钥文件出错，%v \n", err)
+		os.Exit(1)
+	}
+	detail.KeyFormat, err = account.GetKeyFormat(acc.Id)
+	if err != nil {
+		// 私钥文件缺失或损坏时仍然显示账户信息
+		detail.KeyFormat = fmt.Sprintf("unknown (%v)", err)
+	}
+
+	if asJSON {
+		printJSON(detail)
+		return
+	}
+
+	printTable([]string{"FIELD", "VALUE"}, [][]string{
+		{"nickname", detail.Nickname},
+		{"phone", detail.Phone},
+		{"id", detail.Id},
+		{"key file", detail.KeyFile},
+		{"key format", detail.KeyFormat},
+	})
+}
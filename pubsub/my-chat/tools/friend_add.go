@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
 	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/spf13/cobra"
 	"os"
 )
@@ -16,47 +18,108 @@ var addFriendCmd = &cobra.Command{
 type AddFriendArgument struct {
 	Id       string
 	Nickname string
-	SelfId   string
+	Self     string
 }
 
 func fetchAddFriendArgs(cmd *cobra.Command) (*AddFriendArgument, error) {
 	nickname, _ := cmd.Flags().GetString("nick")
 	id, _ := cmd.Flags().GetString("id")
-	selfId, _ := cmd.Flags().GetString("self-id")
+	self, _ := cmd.Flags().GetString("self")
+
+	if len(self) == 0 {
+		return nil, fmt.Errorf("self is required")
+	}
+	if len(nickname) == 0 {
+		return nil, fmt.Errorf("nickname is required")
+	}
+	if _, err := peer.Decode(id); err != nil {
+		return nil, fmt.Errorf("invalid peer id `%s`, err = %v", id, err)
+	}
 
 	return &AddFriendArgument{
 		Id:       id,
 		Nickname: nickname,
-		SelfId:   selfId,
+		Self:     self,
 	}, nil
 }
 
 func addFriend(cmd *cobra.Command, _ []string) {
 	args, err := fetchAddFriendArgs(cmd)
 	if err != nil {
-		fmt.Printf("Error parsing arguments: %v\n", err)
+		fmt.Printf("获取参数出错：%v \n", err)
 		os.Exit(1)
 	}
 
-	friends, err := links.LoadMyFriends(args.SelfId)
+	me, err := account.GetAccount(args.Self)
 	if err != nil {
-		fmt.Printf("加载好友列表出错，%v \n", err)
+		fmt.Printf("查找账户`%s`出错，%v \n", args.Self, err)
+		os.Exit(1)
+	}
+
+	friend := &links.Friend{
+		Id:       args.Id,
+		Nickname: args.Nickname,
+	}
+	if err = links.AddFriend(me.Id, friend); err != nil {
+		fmt.Printf("添加好友出错，%v \n", err)
 		os.Exit(1)
 	}
 
-	friend, exists := friends[args.Id]
-	if !exists {
-		friend = &links.Friend{
-			Nickname: args.Nickname,
+	fmt.Printf("添加好友`%s`成功.\n", args.Nickname)
+}
+
+// findFriend 按照 id 或昵称查找好友
+func findFriend(myId string, idOrNickname string) (*links.Friend, error) {
+	friends, err := links.LoadMyFriends(myId)
+	if err != nil {
+		return nil, err
+	}
+
+	if friend, ok := friends[idOrNickname]; ok {
+		return friend, nil
+	}
+
+	var found *links.Friend
+	for _, friend := range friends {
+		if friend.Nickname != idOrNickname {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("more than one friend named `%s`, use the id instead", idOrNickname)
 		}
+		found = friend
 	}
-	friends[args.Id] = friend
+	if found == nil {
+		return nil, fmt.Errorf("friend `%s` not found", idOrNickname)
+	}
+
+	return found, nil
+}
 
-	err = links.SaveFriends(args.SelfId, friends)
+// fetchSelfAndFriend 解析 --self 和 --friend 参数
+func fetchSelfAndFriend(cmd *cobra.Command) (*account.Account, *links.Friend) {
+	self, _ := cmd.Flags().GetString("self")
+	idOrNickname, _ := cmd.Flags().GetString("friend")
+	if len(self) == 0 {
+		fmt.Println("获取参数出错：self is required")
+		os.Exit(1)
+	}
+	if len(idOrNickname) == 0 {
+		fmt.Println("获取参数出错：friend is required")
+		os.Exit(1)
+	}
+
+	me, err := account.GetAccount(self)
 	if err != nil {
-		fmt.Printf("保存好友列表出错，%v \n", err)
+		fmt.Printf("查找账户`%s`出错，%v \n", self, err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("添加好友`%s`成功.\n", args.Nickname)
+	friend, err := findFriend(me.Id, idOrNickname)
+	if err != nil {
+		fmt.Printf("查找好友出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	return me, friend
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/spf13/cobra"
+	"os"
+	"sort"
+)
+
+var listFriendCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list my friends",
+	Run:   listFriends,
+}
+
+func listFriends(cmd *cobra.Command, _ []string) {
+	self, _ := cmd.Flags().GetString("self")
+	asJSON, _ := cmd.Flags().GetBool("json")
+	if len(self) == 0 {
+		fmt.Println("获取参数出错：self is required")
+		os.Exit(1)
+	}
+
+	me, err := account.GetAccount(self)
+	if err != nil {
+		fmt.Printf("查找账户`%s`出错，%v \n", self, err)
+		os.Exit(1)
+	}
+
+	friends, err := links.LoadMyFriends(me.Id)
+	if err != nil {
+		fmt.Printf("加载好友列表出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	friendList := make([]*links.Friend, 0, len(friends))
+	for _, friend := range friends {
+		friendList = append(friendList, friend)
+	}
+	sort.Slice(friendList, func(i, j int) bool {
+		return friendList[i].Nickname < friendList[j].Nickname
+	})
+
+	if asJSON {
+		printJSON(friendList)
+		return
+	}
+
+	rows := make([][]string, 0, len(friendList))
+	for _, friend := range friendList {
+		rows = append(rows, []string{friend.Nickname, friend.Id})
+	}
+	printTable([]string{"NICKNAME", "ID"}, rows)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var removeFriendCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "remove a friend by id or nick name",
+	Run:   removeFriend,
+}
+
+func removeFriend(cmd *cobra.Command, _ []string) {
+	me, friend := fetchSelfAndFriend(cmd)
+
+	if err := links.RemoveFriend(me.Id, friend.Id); err != nil {
+		fmt.Printf("删除好友出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("删除好友`%s`成功.\n", friend.Nickname)
+}
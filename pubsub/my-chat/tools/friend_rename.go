@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var renameFriendCmd = &cobra.Command{
+	Use:   "rename",
+	Short: "change the nick name of a friend",
+	Run:   renameFriend,
+}
+
+func renameFriend(cmd *cobra.Command, _ []string) {
+	nickname, _ := cmd.Flags().GetString("nick")
+	if len(nickname) == 0 {
+		fmt.Println("获取参数出错：nickname is required")
+		os.Exit(1)
+	}
+
+	me, friend := fetchSelfAndFriend(cmd)
+
+	if err := links.RenameFriend(me.Id, friend.Id, nickname); err != nil {
+		fmt.Printf("修改好友昵称出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("好友`%s`已改名为`%s`.\n", friend.Nickname, nickname)
+}
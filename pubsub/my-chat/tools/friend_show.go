@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var showFriendCmd = &cobra.Command{
+	Use:   "show",
+	Short: "show a friend by id or nick name",
+	Run:   showFriend,
+}
+
+func showFriend(cmd *cobra.Command, _ []string) {
+	asJSON, _ := cmd.Flags().GetBool("json")
+	_, friend := fetchSelfAndFriend(cmd)
+
+	if asJSON {
+		printJSON(friend)
+		return
+	}
+
+	printTable([]string{"FIELD", "VALUE"}, [][]string{
+		{"nickname", friend.Nickname},
+		{"id", friend.Id},
+		{"owner", friend.Owner},
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var joinGroupCmd = &cobra.Command{
+	Use:   "join",
+	Short: "join a group created by others",
+	Run:   joinGroup,
+}
+
+type JoinGroupArgument struct {
+	Self       string
+	Id         string
+	Name       string
+	Topic      string
+	InviteOnly bool
+}
+
+func fetchJoinGroupArgs(cmd *cobra.Command) (*JoinGroupArgument, error) {
+	self, _ := cmd.Flags().GetString("self")
+	id, _ := cmd.Flags().GetString("id")
+	name, _ := cmd.Flags().GetString("name")
+	topic, _ := cmd.Flags().GetString("topic")
+	inviteOnly, _ := cmd.Flags().GetBool("invite-only")
+
+	if len(self) == 0 {
+		return nil, fmt.Errorf("self is required")
+	}
+	if len(id) == 0 {
+		return nil, fmt.Errorf("id is required")
+	}
+	if len(name) == 0 {
+		return nil, fmt.Errorf("name is required")
+	}
+	// 公开群的主题就是群名
+	if len(topic) == 0 {
+		if inviteOnly {
+			return nil, fmt.Errorf("topic is required for invite-only group")
+		}
+		topic = name
+	}
+
+	return &JoinGroupArgument{
+		Self:       self,
+		Id:         id,
+		Name:       name,
+		Topic:      topic,
+		InviteOnly: inviteOnly,
+	}, nil
+}
+
+func joinGroup(cmd *cobra.Command, _ []string) {
+	args, err := fetchJoinGroupArgs(cmd)
+	if err != nil {
+		fmt.Printf("获取参数出错：%v \n", err)
+		os.Exit(1)
+	}
+
+	me, err := account.GetAccount(args.Self)
+	if err != nil {
+		fmt.Printf("查找账户`%s`出错，%v \n", args.Self, err)
+		os.Exit(1)
+	}
+
+	group := &links.Group{
+		Id:    args.Id,
+		Name:  args.Name,
+		Type:  links.GROUP_TYPE_OPEN,
+		Topic: args.Topic,
+	}
+	if args.InviteOnly {
+		group.Type = links.GROUP_TYPE_INVITE_ONLY
+	}
+	if err = links.JoinGroup(me.Id, group); err != nil {
+		fmt.Printf("加入群出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("加入群`%s`成功.\n", args.Name)
+}
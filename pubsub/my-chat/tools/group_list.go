@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var listGroupCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list my groups",
+	Run:   listGroups,
+}
+
+func listGroups(cmd *cobra.Command, _ []string) {
+	self, _ := cmd.Flags().GetString("self")
+	if len(self) == 0 {
+		fmt.Println("获取参数出错：self is required")
+		os.Exit(1)
+	}
+
+	me, err := account.GetAccount(self)
+	if err != nil {
+		fmt.Printf("查找账户`%s`出错，%v \n", self, err)
+		os.Exit(1)
+	}
+
+	groups, err := links.LoadMyGroup(me.Id)
+	if err != nil {
+		fmt.Printf("加载群列表出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	for _, group := range groups {
+		groupType := "open"
+		if group.IsInviteOnly() {
+			groupType = "invite-only"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", group.Id, group.Name, groupType, group.Topic)
+	}
+}
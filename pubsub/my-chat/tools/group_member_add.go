@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var addGroupMemberCmd = &cobra.Command{
+	Use:   "add-member",
+	Short: "add a member into an invite-only group",
+	Run:   addGroupMember,
+}
+
+func addGroupMember(cmd *cobra.Command, _ []string) {
+	self, _ := cmd.Flags().GetString("self")
+	groupId, _ := cmd.Flags().GetString("group")
+	memberId, _ := cmd.Flags().GetString("member")
+
+	if len(self) == 0 || len(groupId) == 0 || len(memberId) == 0 {
+		fmt.Println("获取参数出错：self, group and member are required")
+		os.Exit(1)
+	}
+	if _, err := peer.Decode(memberId); err != nil {
+		fmt.Printf("成员id`%s`不合法，%v \n", memberId, err)
+		os.Exit(1)
+	}
+
+	me, err := account.GetAccount(self)
+	if err != nil {
+		fmt.Printf("查找账户`%s`出错，%v \n", self, err)
+		os.Exit(1)
+	}
+
+	if _, err = links.GetGroup(me.Id, groupId); err != nil {
+		fmt.Printf("查找群`%s`出错，%v \n", groupId, err)
+		os.Exit(1)
+	}
+
+	if err = links.AddGroupMember(me.Id, groupId, memberId); err != nil {
+		fmt.Printf("添加群成员出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("添加群成员`%s`成功.\n", memberId)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/account"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/links"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var newGroupCmd = &cobra.Command{
+	Use:   "new",
+	Short: "create a group",
+	Run:   newGroup,
+}
+
+type NewGroupArgument struct {
+	Self       string
+	Name       string
+	InviteOnly bool
+}
+
+func fetchNewGroupArgs(cmd *cobra.Command) (*NewGroupArgument, error) {
+	self, _ := cmd.Flags().GetString("self")
+	name, _ := cmd.Flags().GetString("name")
+	inviteOnly, _ := cmd.Flags().GetBool("invite-only")
+
+	if len(self) == 0 {
+		return nil, fmt.Errorf("self is required")
+	}
+	if len(name) == 0 {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	return &NewGroupArgument{
+		Self:       self,
+		Name:       name,
+		InviteOnly: inviteOnly,
+	}, nil
+}
+
+func newGroup(cmd *cobra.Command, _ []string) {
+	args, err := fetchNewGroupArgs(cmd)
+	if err != nil {
+		fmt.Printf("获取参数出错：%v \n", err)
+		os.Exit(1)
+	}
+
+	me, err := account.GetAccount(args.Self)
+	if err != nil {
+		fmt.Printf("查找账户`%s`出错，%v \n", args.Self, err)
+		os.Exit(1)
+	}
+
+	groupType := links.GROUP_TYPE_OPEN
+	if args.InviteOnly {
+		groupType = links.GROUP_TYPE_INVITE_ONLY
+	}
+	group, err := links.CreateGroup(me.Id, args.Name, groupType)
+	if err != nil {
+		fmt.Printf("创建群出错，%v \n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("创建群成功，id = %s, topic = %s \n", group.Id, group.Topic)
+}
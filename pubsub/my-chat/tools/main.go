@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/config"
 	"github.com/spf13/cobra"
 	"os"
 )
@@ -9,6 +10,16 @@ import (
 var rootCmd = &cobra.Command{
 	Use:   "app",
 	Short: "This is a sample application",
+	PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+		home, _ := cmd.Flags().GetString("home")
+		dbPath, _ := cmd.Flags().GetString("db")
+		keyDir, _ := cmd.Flags().GetString("key-dir")
+		return config.Init(&config.Options{
+			HomeDir: home,
+			DBPath:  dbPath,
+			KeyDir:  keyDir,
+		})
+	},
 }
 
 var accountCmd = &cobra.Command{
@@ -21,25 +32,135 @@ var friendCmd = &cobra.Command{
 	Short: "Friend related commands",
 }
 
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Group related commands",
+}
+
+var settingsCmd = &cobra.Command{
+	Use:   "settings",
+	Short: "Settings related commands",
+}
+
 func init() {
+	// data directories, shared by all commands
+	rootCmd.PersistentFlags().String("home", "", "home directory, defaults to $MY_CHAT_HOME or $XDG_DATA_HOME/my-chat")
+	rootCmd.PersistentFlags().String("db", "", "database file, defaults to $MY_CHAT_DB or <home>/db/my-chat.db")
+	rootCmd.PersistentFlags().String("key-dir", "", "private key directory, defaults to $MY_CHAT_KEY_DIR or <home>/data/account")
+
 	// new account
 	newAccountCmd.Flags().String("nick", "", "nick name")
 	newAccountCmd.Flags().String("phone", "", "phone number")
 	newAccountCmd.Flags().String("passphrase", "", "passphrase")
 
+	// migrate account
+	migrateAccountCmd.Flags().String("nick", "", "nick name")
+	migrateAccountCmd.Flags().String("passphrase", "", "passphrase")
+
+	// list accounts
+	listAccountCmd.Flags().Bool("json", false, "output in json")
+
+	// show account
+	showAccountCmd.Flags().String("nick", "", "nick name")
+	showAccountCmd.Flags().Bool("json", false, "output in json")
+
+	// import account
+	importAccountCmd.Flags().String("nick", "", "nick name")
+	importAccountCmd.Flags().String("phone", "", "phone number")
+	importAccountCmd.Flags().String("passphrase", "", "passphrase to encrypt the imported key")
+	importAccountCmd.Flags().String("key", "", "private key file, pem or libp2p marshalled")
+	importAccountCmd.Flags().String("key-passphrase", "", "passphrase of the key file, if it is encrypted")
+
+	// export account
+	exportAccountCmd.Flags().String("nick", "", "nick name")
+	exportAccountCmd.Flags().String("passphrase", "", "passphrase")
+	exportAccountCmd.Flags().String("format", EXPORT_FORMAT_PEM, "pem | libp2p")
+	exportAccountCmd.Flags().String("out", "", "output file")
+
+	// change passphrase
+	changePassphraseCmd.Flags().String("nick", "", "nick name")
+	changePassphraseCmd.Flags().String("passphrase", "", "current passphrase")
+	changePassphraseCmd.Flags().String("new-passphrase", "", "new passphrase, empty to store the key unencrypted")
+
+	// delete account
+	deleteAccountCmd.Flags().String("nick", "", "nick name")
+	deleteAccountCmd.Flags().String("passphrase", "", "passphrase")
+	deleteAccountCmd.Flags().Bool("yes", false, "confirm the deletion")
+
 	// add friend
 	addFriendCmd.Flags().String("id", "", "id of friend")
 	addFriendCmd.Flags().String("nick", "", "nick name of friend")
-	addFriendCmd.Flags().String("self-id", "", "the id of myself")
+
+	// list friends
+	listFriendCmd.Flags().Bool("json", false, "output in json")
+
+	// show friend
+	showFriendCmd.Flags().String("friend", "", "id or nick name of friend")
+	showFriendCmd.Flags().Bool("json", false, "output in json")
+
+	// remove friend
+	removeFriendCmd.Flags().String("friend", "", "id or nick name of friend")
+
+	// rename friend
+	renameFriendCmd.Flags().String("friend", "", "id or nick name of friend")
+	renameFriendCmd.Flags().String("nick", "", "new nick name of friend")
+
+	// friend commands share the owner flag
+	friendCmd.PersistentFlags().String("self", "", "nick name of myself")
+
+	// new group
+	newGroupCmd.Flags().String("name", "", "name of group")
+	newGroupCmd.Flags().Bool("invite-only", false, "only members can talk in the group")
+
+	// join group
+	joinGroupCmd.Flags().String("id", "", "id of group")
+	joinGroupCmd.Flags().String("name", "", "name of group")
+	joinGroupCmd.Flags().String("topic", "", "topic of group, defaults to name for open group")
+	joinGroupCmd.Flags().Bool("invite-only", false, "the group is invite-only")
+
+	// add group member
+	addGroupMemberCmd.Flags().String("group", "", "id of group")
+	addGroupMemberCmd.Flags().String("member", "", "id of member")
+
+	// list bootstrap peers
+	listBootstrapCmd.Flags().Bool("json", false, "output in json")
+
+	// group commands share the owner flag
+	groupCmd.PersistentFlags().String("self", "", "nick name of myself")
 
 	// account commands
 	accountCmd.AddCommand(newAccountCmd)
+	accountCmd.AddCommand(migrateAccountCmd)
+	accountCmd.AddCommand(listAccountCmd)
+	accountCmd.AddCommand(showAccountCmd)
+	accountCmd.AddCommand(importAccountCmd)
+	accountCmd.AddCommand(exportAccountCmd)
+	accountCmd.AddCommand(changePassphraseCmd)
+	accountCmd.AddCommand(deleteAccountCmd)
 
 	// friend commands
 	friendCmd.AddCommand(addFriendCmd)
+	friendCmd.AddCommand(listFriendCmd)
+	friendCmd.AddCommand(showFriendCmd)
+	friendCmd.AddCommand(removeFriendCmd)
+	friendCmd.AddCommand(renameFriendCmd)
+
+	// group commands
+	groupCmd.AddCommand(newGroupCmd)
+	groupCmd.AddCommand(joinGroupCmd)
+	groupCmd.AddCommand(listGroupCmd)
+	groupCmd.AddCommand(addGroupMemberCmd)
+
+	// settings commands
+	bootstrapCmd.AddCommand(addBootstrapCmd)
+	bootstrapCmd.AddCommand(removeBootstrapCmd)
+	bootstrapCmd.AddCommand(listBootstrapCmd)
+	settingsCmd.AddCommand(bootstrapCmd)
 
 	rootCmd.AddCommand(accountCmd)
 	rootCmd.AddCommand(friendCmd)
+	rootCmd.AddCommand(groupCmd)
+	rootCmd.AddCommand(settingsCmd)
 }
 
 func main() {
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/common"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/config"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Bootstrap peer related commands",
+}
+
+var addBootstrapCmd = &cobra.Command{
+	Use:   "add <multiaddr>",
+	Short: "add a bootstrap peer, the address must end with /p2p/<peer id>",
+	Args:  cobra.ExactArgs(1),
+	Run:   addBootstrap,
+}
+
+var removeBootstrapCmd = &cobra.Command{
+	Use:   "remove <multiaddr | peer id>",
+	Short: "remove a bootstrap peer by address, or all addresses of a peer",
+	Args:  cobra.ExactArgs(1),
+	Run:   removeBootstrap,
+}
+
+var listBootstrapCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list configured and learned bootstrap peers",
+	Run:   listBootstrap,
+}
+
+func loadSettings() *config.Settings {
+	settings, err := config.LoadSettings()
+	if err != nil {
+		fmt.Printf("加载配置文件出错，%v \n", err)
+		os.Exit(1)
+	}
+	return settings
+}
+
+func saveSettings(settings *config.Settings) {
+	settings.Timestamp = common.GetTimestampString(time.Now())
+	if err := config.SaveSettings(settings); err != nil {
+		fmt.Printf("保存配置文件出错，%v \n", err)
+		os.Exit(1)
+	}
+}
+
+func addBootstrap(_ *cobra.Command, args []string) {
+	settings := loadSettings()
+
+	added, err := settings.AddBootstrap(args[0])
+	if err != nil {
+		fmt.Printf("获取参数出错：%v \n", err)
+		os.Exit(1)
+	}
+	if !added {
+		fmt.Printf("引导节点`%s`已经存在.\n", args[0])
+		return
+	}
+
+	saveSettings(settings)
+	fmt.Printf("添加引导节点`%s`成功.\n", args[0])
+}
+
+func removeBootstrap(_ *cobra.Command, args []string) {
+	settings := loadSettings()
+
+	removed := settings.RemoveBootstrap(args[0])
+	if removed == 0 {
+		fmt.Printf("没有找到引导节点`%s`.\n", args[0])
+		os.Exit(1)
+	}
+
+	saveSettings(settings)
+	fmt.Printf("删除引导节点成功，共 %d 条.\n", removed)
+}
+
+func listBootstrap(cmd *cobra.Command, _ []string) {
+	asJSON, _ := cmd.Flags().GetBool("json")
+	settings := loadSettings()
+
+	if asJSON {
+		printJSON(map[string][]string{
+			"bootstrap":        settings.Bootstrap,
+			"learnedBootstrap": settings.LearnedBootstrap,
+		})
+		return
+	}
+
+	rows := make([][]string, 0, len(settings.Bootstrap)+len(settings.LearnedBootstrap))
+	for _, addr := range settings.Bootstrap {
+		rows = append(rows, []string{addr, "configured"})
+	}
+	for _, addr := range settings.LearnedBootstrap {
+		rows = append(rows, []string{addr, "learned"})
+	}
+	printTable([]string{"ADDRESS", "SOURCE"}, rows)
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FileProtocol = "/my-chat/file/1.0.0"
+	FileBufSize  = 128
+
+	// 每次读写的数据块大小
+	fileChunkSize = 64 * 1024
+	// 建立连接和等待应答的超时时间，以及两个数据块之间的最长等待时间
+	fileStreamTimeout = 30 * time.Second
+	// 传输中断后自动续传的次数
+	fileRetries = 3
+	// 两次进度通知之间的最短间隔
+	fileProgressInterval = 200 * time.Millisecond
+)
+
+// 下载请求的应答状态
+const (
+	FILE_STATUS_OK        = "ok"
+	FILE_STATUS_NOT_FOUND = "not_found"
+	FILE_STATUS_DENIED    = "denied"
+	FILE_STATUS_INVALID   = "invalid"
+)
+
+// 收到的文件通知的状态
+const (
+	FILE_STATE_OFFERED     = "offered"
+	FILE_STATE_DOWNLOADING = "downloading"
+	FILE_STATE_DONE        = "done"
+	FILE_STATE_FAILED      = "failed"
+)
+
+// FileOffer 是在聊天室或者私聊中发出的文件通知，接收方确认后通过 FileProtocol 向发送方下载
+type FileOffer struct {
+	Id     string
+	Name   string
+	Size   int64
+	Sha256 string
+}
+
+// FileRequest 从{Offset}开始下载文件，{Offset}为已经收到的长度，用于断点续传
+type FileRequest struct {
+	Id     string
+	Offset int64
+}
+
+// FileResponse 之后紧跟着从{Offset}开始的文件内容
+type FileResponse struct {
+	Id     string
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+// FileProgress 是一次文件传输的进度，Done 为 true 时传输结束，Err 不为空表示失败
+type FileProgress struct {
+	Offer *FileOffer
+	Peer  peer.ID
+	// 是否是别人从我这里下载
+	Upload   bool
+	Received int64
+	Done     bool
+	Path     string
+	Err      error
+
+	// 上一次通知的时间
+	reported time.Time
+}
+
+// sharedFile 是我发出的文件，{allow}决定哪些节点可以下载
+type sharedFile struct {
+	offer *FileOffer
+	path  string
+	allow func(pid peer.ID) bool
+}
+
+// receivedOffer 是别人发给我的文件通知
+type receivedOffer struct {
+	Offer *FileOffer
+	From  peer.ID
+	State string
+	Path  string
+}
+
+// FileService 负责在节点之间传输文件，文件的通知通过聊天室或者私聊发出
+type FileService struct {
+	Progress chan *FileProgress
+
+	ctx         context.Context
+	host        host.Host
+	maxSize     int64
+	downloadDir string
+
+	mu     sync.Mutex
+	shared map[string]*sharedFile
+	offers map[string]*receivedOffer
+}
+
+func NewFileService(ctx context.Context, h host.Host, maxSize int64, downloadDir string) *FileService {
+	fs := &FileService{
+		Progress:    make(chan *FileProgress, FileBufSize),
+		ctx:         ctx,
+		host:        h,
+		maxSize:     maxSize,
+		downloadDir: downloadDir,
+		shared:      make(map[string]*sharedFile),
+		offers:      make(map[string]*receivedOffer),
+	}
+	h.SetStreamHandler(FileProtocol, fs.onFileRequest)
+	return fs
+}
+
+// Share 计算文件的 sha256 并生成文件通知，{allow}决定哪些节点可以下载
+func (fs *FileService) Share(path string, allow func(pid peer.ID) bool) (*FileOffer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("`%s` is not a regular file", path)
+	}
+	if info.Size() > fs.maxSize {
+		return nil, fmt.Errorf("`%s` is larger than the limit of %s", path, formatSize(fs.maxSize))
+	}
+
+	sum, err := fileSha256(path)
+	if err != nil {
+		return nil, err
+	}
+
+	offer := &FileOffer{
+		Id:     uuid.New().String(),
+		Name:   filepath.Base(path),
+		Size:   info.Size(),
+		Sha256: sum,
+	}
+	fs.mu.Lock()
+	fs.shared[offer.Id] = &sharedFile{offer: offer, path: path, allow: allow}
+	fs.mu.Unlock()
+	return offer, nil
+}
+
+// AddOffer 记录收到的文件通知，不能接收的文件返回错误
+func (fs *FileService) AddOffer(from peer.ID, offer *FileOffer) error {
+	// id 会用作临时文件名，必须是 uuid
+	if _, err := uuid.Parse(offer.Id); err != nil {
+		return fmt.Errorf("invalid file offer id `%s`", offer.Id)
+	}
+	name := filepath.Base(offer.Name)
+	if name != offer.Name || name == "." || name == ".." || name == string(filepath.Separator) {
+		return fmt.Errorf("invalid file name `%s`", offer.Name)
+	}
+	if offer.Size < 0 || offer.Size > fs.maxSize {
+		return fmt.Errorf("file size %d out of range, limit is %s", offer.Size, formatSize(fs.maxSize))
+	}
+	if sum, err := hex.DecodeString(offer.Sha256); err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("invalid sha256 `%s`", offer.Sha256)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.offers[offer.Id]; !ok {
+		fs.offers[offer.Id] = &receivedOffer{Offer: offer, From: from, State: FILE_STATE_OFFERED}
+	}
+	return nil
+}
+
+// ListOffers 返回收到的文件通知，按文件名排序
+func (fs *FileService) ListOffers() []receivedOffer {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	offers := make([]receivedOffer, 0, len(fs.offers))
+	for _, ro := range fs.offers {
+		offers = append(offers, *ro)
+	}
+	sort.Slice(offers, func(i, j int) bool {
+		return offers[i].Offer.Name < offers[j].Offer.Name
+	})
+	return offers
+}
+
+// FindOffer 按 id 或者 id 的前缀查找收到的文件通知
+func (fs *FileService) FindOffer(prefix string) (*FileOffer, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var found *FileOffer
+	for id, ro := range fs.offers {
+		if !strings.HasPrefix(id, prefix) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("file id `%s` is ambiguous", prefix)
+		}
+		found = ro.Offer
+	}
+	if found == nil {
+		return nil, fmt.Errorf("unknown file `%s`", prefix)
+	}
+	return found, nil
+}
+
+// Download 接收文件，在后台下载，进度通过 Progress 通知
+func (fs *FileService) Download(id string) error {
+	fs.mu.Lock()
+	ro, ok := fs.offers[id]
+	if !ok {
+		fs.mu.Unlock()
+		return fmt.Errorf("unknown file `%s`", id)
+	}
+	switch ro.State {
+	case FILE_STATE_DOWNLOADING:
+		fs.mu.Unlock()
+		return fmt.Errorf("`%s` is already downloading", ro.Offer.Name)
+	case FILE_STATE_DONE:
+		fs.mu.Unlock()
+		return fmt.Errorf("`%s` is already saved to %s", ro.Offer.Name, ro.Path)
+	}
+	ro.State = FILE_STATE_DOWNLOADING
+	offer, from := ro.Offer, ro.From
+	fs.mu.Unlock()
+
+	go fs.download(offer, from)
+	return nil
+}
+
+// download 下载文件，中断时从已经收到的位置继续
+func (fs *FileService) download(offer *FileOffer, from peer.ID) {
+	var path string
+	var err error
+	for i := 1; ; i++ {
+		path, err = fs.fetch(offer, from)
+		if err == nil {
+			break
+		}
+		log.Printf("[file] download `%s` from %s failed (%d/%d), err = %v", offer.Name, from, i, fileRetries, err)
+		if i >= fileRetries || fs.ctx.Err() != nil {
+			break
+		}
+		time.Sleep(time.Duration(i) * time.Second)
+	}
+
+	progress := &FileProgress{Offer: offer, Peer: from, Done: true, Path: path, Err: err}
+	fs.mu.Lock()
+	ro := fs.offers[offer.Id]
+	if err != nil {
+		ro.State = FILE_STATE_FAILED
+	} else {
+		ro.State = FILE_STATE_DONE
+		ro.Path = path
+		progress.Received = offer.Size
+	}
+	fs.mu.Unlock()
+
+	fs.report(progress)
+}
+
+// fetch 把文件下载到下载目录中的 <id>.part，校验通过后改成原来的文件名
+func (fs *FileService) fetch(offer *FileOffer, from peer.ID) (string, error) {
+	if err := os.MkdirAll(fs.downloadDir, 0700); err != nil {
+		return "", fmt.Errorf("create download directory failed, err = %v", err)
+	}
+	partPath := filepath.Join(fs.downloadDir, offer.Id+".part")
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size()
+	if offset > offer.Size {
+		offset = 0
+		if err = f.Truncate(0); err != nil {
+			return "", err
+		}
+	}
+
+	if offset < offer.Size {
+		if err = fs.receive(f, offer, from, offset); err != nil {
+			return "", err
+		}
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+
+	sum, err := fileSha256(partPath)
+	if err != nil {
+		return "", err
+	}
+	if sum != offer.Sha256 {
+		// 内容错误，下次从头开始
+		_ = os.Remove(partPath)
+		return "", fmt.Errorf("sha256 mismatch, expect %s, got %s", offer.Sha256, sum)
+	}
+
+	path := uniquePath(filepath.Join(fs.downloadDir, offer.Name))
+	if err = os.Rename(partPath, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// receive 请求从{offset}开始的文件内容，写入{f}
+func (fs *FileService) receive(f *os.File, offer *FileOffer, from peer.ID, offset int64) error {
+	ctx, cancel := context.WithTimeout(fs.ctx, fileStreamTimeout)
+	defer cancel()
+
+	s, err := fs.host.NewStream(ctx, from, FileProtocol)
+	if err != nil {
+		return fmt.Errorf("open stream to `%s` failed, err = %v", from, err)
+	}
+	defer s.Close()
+
+	if err = writeJSONLine(s, &FileRequest{Id: offer.Id, Offset: offset}); err != nil {
+		s.Reset()
+		return fmt.Errorf("send request to `%s` failed, err = %v", from, err)
+	}
+
+	_ = s.SetReadDeadline(time.Now().Add(fileStreamTimeout))
+	reader := bufio.NewReader(s)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		s.Reset()
+		return fmt.Errorf("wait response from `%s` failed, err = %v", from, err)
+	}
+	resp := new(FileResponse)
+	if err = json.Unmarshal(line, resp); err != nil {
+		s.Reset()
+		return fmt.Errorf("unmarshal response from `%s` failed, err = %v", from, err)
+	}
+	if resp.Status != FILE_STATUS_OK {
+		reason := resp.Status
+		if len(resp.Error) > 0 {
+			reason += ", " + resp.Error
+		}
+		return fmt.Errorf("`%s` refused to send `%s`: %s", from, offer.Name, reason)
+	}
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		s.Reset()
+		return err
+	}
+	progress := &FileProgress{Offer: offer, Peer: from, Received: offset}
+	err = copyChunks(f, reader, offer.Size-offset, s.SetReadDeadline, func(n int64) {
+		progress.Received += n
+		fs.reportThrottled(progress)
+	})
+	if err != nil {
+		s.Reset()
+		return fmt.Errorf("receive `%s` from `%s` failed, err = %v", offer.Name, from, err)
+	}
+	return nil
+}
+
+func (fs *FileService) onFileRequest(s network.Stream) {
+	defer s.Close()
+	remote := s.Conn().RemotePeer()
+
+	_ = s.SetReadDeadline(time.Now().Add(fileStreamTimeout))
+	line, err := bufio.NewReader(io.LimitReader(s, 4096)).ReadBytes('\n')
+	if err != nil {
+		log.Printf("[file] read request from %s failed, err = %v", remote, err)
+		s.Reset()
+		return
+	}
+	req := new(FileRequest)
+	if err = json.Unmarshal(line, req); err != nil {
+		log.Printf("[file] unmarshal request from %s failed, err = %v", remote, err)
+		s.Reset()
+		return
+	}
+
+	fs.mu.Lock()
+	sf, ok := fs.shared[req.Id]
+	fs.mu.Unlock()
+
+	resp := &FileResponse{Id: req.Id, Status: FILE_STATUS_OK}
+	var f *os.File
+	switch {
+	case !ok:
+		resp.Status = FILE_STATUS_NOT_FOUND
+	case sf.allow != nil && !sf.allow(remote):
+		resp.Status = FILE_STATUS_DENIED
+	case req.Offset < 0 || req.Offset > sf.offer.Size:
+		resp.Status = FILE_STATUS_INVALID
+		resp.Error = fmt.Sprintf("offset %d out of range", req.Offset)
+	default:
+		if f, err = openShared(sf, req.Offset); err != nil {
+			resp.Status = FILE_STATUS_NOT_FOUND
+			resp.Error = err.Error()
+		} else {
+			defer f.Close()
+		}
+	}
+
+	_ = s.SetWriteDeadline(time.Now().Add(fileStreamTimeout))
+	if err = writeJSONLine(s, resp); err != nil || resp.Status != FILE_STATUS_OK {
+		log.Printf("[file] request of `%s` from %s: %s %s, err = %v", req.Id, remote, resp.Status, resp.Error, err)
+		return
+	}
+
+	log.Printf("[file] send `%s` to %s from offset %d", sf.offer.Name, remote, req.Offset)
+	progress := &FileProgress{Offer: sf.offer, Peer: remote, Upload: true, Received: req.Offset}
+	err = copyChunks(s, f, sf.offer.Size-req.Offset, s.SetWriteDeadline, func(n int64) {
+		progress.Received += n
+		fs.reportThrottled(progress)
+	})
+	if err != nil {
+		log.Printf("[file] send `%s` to %s failed, err = %v", sf.offer.Name, remote, err)
+		s.Reset()
+	}
+	fs.report(&FileProgress{Offer: sf.offer, Peer: remote, Upload: true, Received: progress.Received, Done: true, Err: err})
+}
+
+// openShared 打开要发送的文件并定位到{offset}，文件在发出通知之后被修改时返回错误。
+// 内容被修改但长度不变的情况由接收方校验 sha256 发现。
+func openShared(sf *sharedFile, offset int64) (*os.File, error) {
+	f, err := os.Open(sf.path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() != sf.offer.Size {
+		f.Close()
+		return nil, fmt.Errorf("file has been changed")
+	}
+	return f, nil
+}
+
+// copyChunks 分块复制{size}字节，每一块之前用{setDeadline}刷新超时，每一块之后调用{onChunk}
+func copyChunks(dst io.Writer, src io.Reader, size int64, setDeadline func(time.Time) error,
+	onChunk func(n int64)) error {
+	buf := make([]byte, fileChunkSize)
+	for size > 0 {
+		chunk := buf
+		if size < int64(len(chunk)) {
+			chunk = chunk[:size]
+		}
+		_ = setDeadline(time.Now().Add(fileStreamTimeout))
+		n, err := io.ReadFull(src, chunk)
+		if n > 0 {
+			if _, werr := dst.Write(chunk[:n]); werr != nil {
+				return werr
+			}
+			size -= int64(n)
+			onChunk(int64(n))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// report 通知界面传输进度，界面忙不过来（或者无界面模式）时丢弃
+func (fs *FileService) report(progress *FileProgress) {
+	select {
+	case fs.Progress <- progress:
+	default:
+	}
+}
+
+func (fs *FileService) reportThrottled(progress *FileProgress) {
+	now := time.Now()
+	if now.Sub(progress.reported) < fileProgressInterval {
+		return
+	}
+	progress.reported = now
+	p := *progress
+	fs.report(&p)
+}
+
+func fileSha256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileOfferText 是文件通知在消息中的文字，也是历史记录中保存的内容
+func fileOfferText(offer *FileOffer) string {
+	return fmt.Sprintf("[file] %s (%s)", offer.Name, formatSize(offer.Size))
+}
+
+// shortFileId 显示文件 id 的前 8 位，/download 支持 id 的前缀
+func shortFileId(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// uniquePath 文件已经存在时，在文件名后面加上序号
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512 B", formatSize(512))
+	assert.Equal(t, "1.0 KB", formatSize(1024))
+	assert.Equal(t, "1.5 MB", formatSize(1536*1024))
+}
+
+func TestUniquePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.jpg")
+	assert.Equal(t, path, uniquePath(path))
+
+	assert.NoError(t, os.WriteFile(path, nil, 0600))
+	assert.Equal(t, filepath.Join(dir, "photo (1).jpg"), uniquePath(path))
+}
+
+func TestAddOffer(t *testing.T) {
+	fs := &FileService{maxSize: 1024, offers: make(map[string]*receivedOffer)}
+	valid := func() *FileOffer {
+		return &FileOffer{Id: uuid.New().String(), Name: "a.txt", Size: 10, Sha256: strings.Repeat("ab", 32)}
+	}
+
+	assert.NoError(t, fs.AddOffer("", valid()))
+
+	offer := valid()
+	offer.Id = "../../etc/passwd"
+	assert.Error(t, fs.AddOffer("", offer))
+
+	offer = valid()
+	offer.Name = "../a.txt"
+	assert.Error(t, fs.AddOffer("", offer))
+
+	offer = valid()
+	offer.Size = 2048
+	assert.Error(t, fs.AddOffer("", offer))
+
+	offer = valid()
+	offer.Sha256 = "abc"
+	assert.Error(t, fs.AddOffer("", offer))
+}
+
+func TestCopyChunks(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 3*fileChunkSize+1)
+	noDeadline := func(time.Time) error { return nil }
+
+	var dst bytes.Buffer
+	var chunks int
+	err := copyChunks(&dst, bytes.NewReader(data), int64(len(data)), noDeadline, func(int64) { chunks++ })
+	assert.NoError(t, err)
+	assert.Equal(t, data, dst.Bytes())
+	assert.Equal(t, 4, chunks)
+
+	// 对端提前关闭时返回错误
+	dst.Reset()
+	err = copyChunks(&dst, bytes.NewReader(data[:10]), int64(len(data)), noDeadline, func(int64) {})
+	assert.Error(t, err)
+	assert.Equal(t, 10, dst.Len())
+}
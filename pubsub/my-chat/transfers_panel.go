@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rivo/tview"
+	"io"
+	"strings"
+)
+
+const (
+	// 进度条的宽度
+	progressBarWidth = 10
+	// 传输面板最多显示的条数
+	maxTransferRows = 20
+)
+
+// displayFileOffer 记录收到的文件通知，并提示如何下载
+func (ui *ChatUI) displayFileOffer(w io.Writer, senderId string, offer *FileOffer) {
+	from, err := peer.Decode(senderId)
+	if err == nil {
+		err = ui.files.AddOffer(from, offer)
+	}
+	if err != nil {
+		fmt.Fprintln(w, withColor("red", fmt.Sprintf("ignore file offer: %v", err)))
+		return
+	}
+	fmt.Fprintln(w, withColor("gray", fmt.Sprintf("/download %s to accept", shortFileId(offer.Id))))
+}
+
+// displayProgress 更新传输面板，传输结束时在消息框中提示结果
+func (ui *ChatUI) displayProgress(p *FileProgress) {
+	key := p.Offer.Id + "/" + p.Peer.String()
+	if _, ok := ui.progress[key]; !ok {
+		ui.progressOrder = append(ui.progressOrder, key)
+		if len(ui.progressOrder) > maxTransferRows {
+			delete(ui.progress, ui.progressOrder[0])
+			ui.progressOrder = ui.progressOrder[1:]
+		}
+	}
+	ui.progress[key] = p
+
+	if p.Done && !p.Upload {
+		if p.Err != nil {
+			ui.printError(fmt.Sprintf("download `%s` failed: %v", p.Offer.Name, p.Err))
+		} else {
+			ui.printInfo(fmt.Sprintf("saved `%s` to %s", p.Offer.Name, p.Path))
+		}
+	}
+
+	var sb strings.Builder
+	for i := len(ui.progressOrder) - 1; i >= 0; i-- {
+		sb.WriteString(ui.progress[ui.progressOrder[i]].row())
+		sb.WriteString("\n")
+	}
+	text := sb.String()
+	ui.app.QueueUpdateDraw(func() {
+		ui.transfers.SetText(text)
+	})
+}
+
+// row 是传输面板中的一行：方向、文件名、进度条和百分比
+func (p *FileProgress) row() string {
+	arrow := "↓"
+	if p.Upload {
+		arrow = "↑"
+	}
+
+	percent := 100
+	if p.Offer.Size > 0 {
+		percent = int(p.Received * 100 / p.Offer.Size)
+	}
+	filled := percent * progressBarWidth / 100
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+	// 文件名来自对端，可能包含颜色标记
+	name := tview.Escape(p.Offer.Name)
+
+	switch {
+	case p.Done && p.Err != nil:
+		return fmt.Sprintf("%s %s %s", arrow, name, withColor("red", "failed"))
+	case p.Done:
+		return fmt.Sprintf("%s %s %s", arrow, name, withColor("green", "done"))
+	default:
+		return fmt.Sprintf("%s %s |%s| %d%%", arrow, name, bar, percent)
+	}
+}
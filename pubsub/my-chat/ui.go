@@ -1,44 +1,144 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/global"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/history"
 	"github.com/gdamore/tcell/v2"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/rivo/tview"
 	"io"
 	"log"
+	"strings"
 	"time"
 )
 
+// 每次加载的历史消息条数
+const HistoryPageSize = 50
+
+const (
+	PANE_ROOM = "room"
+	PANE_DM   = "dm"
+)
+
+// roomView 是一个聊天室在界面上的镜像
+type roomView struct {
+	room   *ChatRoom
+	box    *tview.TextView
+	oldest int64
+	unread int
+
+	// 正在输入的节点及最近一次收到正在输入的时间
+	typing map[string]time.Time
+	// 尚未发送已读回执的消息 id
+	unreadIds []string
+	// 我最后发出的消息 id，以及读过它的节点
+	lastSent string
+	readBy   map[string]bool
+}
+
+type roomMessage struct {
+	room *ChatRoom
+	msg  *ChatMessage
+}
+
 type ChatUI struct {
-	chatroom  *ChatRoom
+	ctx       context.Context
+	self      peer.ID
+	nick      string
+	rooms     []*roomView
+	current   int
+	dm        *DMService
+	friends   *FriendService
+	discovery *DiscoveryService
+	files     *FileService
 	app       *tview.Application
-	peersList *tview.TextView
+	roomPages *tview.Pages
+	roomsList *tview.TextView
+	dmBox     *tview.TextView
+	peersList *tview.List
+	transfers *tview.TextView
+	input     *tview.InputField
+
+	dmW        io.Writer
+	inputCh    chan string
+	pageCh     chan string
+	switchCh   chan int
+	completeCh chan string
+	typingCh   chan string
+	roomMsgCh  chan *roomMessage
+	// 后台命令完成后要在事件循环中执行的操作
+	resultCh chan func()
+	doneCh   chan struct{}
+
+	// 已加载的最早一条私聊历史消息的时间戳
+	dmOldest int64
 
-	msgW    io.Writer
-	inputCh chan string
-	doneCh  chan struct{}
+	// 从收到的消息中得知的节点昵称
+	nicks map[string]string
+
+	// 节点的在线状态，以及自己的输入状态
+	presence   map[string]*peerPresence
+	lastActive time.Time
+	lastTyping time.Time
+	away       bool
+
+	// 文件传输的最新进度，按开始的顺序显示
+	progress      map[string]*FileProgress
+	progressOrder []string
+
+	// 输入框中的命令，/join 用 ps 加入新的聊天室
+	commands []*command
+	ps       *pubsub.PubSub
 }
 
-func NewChatUI(chatroom *ChatRoom) *ChatUI {
+func NewChatUI(chatrooms []*ChatRoom, dm *DMService, friends *FriendService, discovery *DiscoveryService,
+	files *FileService) *ChatUI {
 	app := tview.NewApplication()
 
-	msgBox := tview.NewTextView()
-	msgBox.SetDynamicColors(true)
-	msgBox.SetBorder(true)
-	msgBox.SetTitle(fmt.Sprintf("Room: %s", chatroom.roomName))
+	// 聊天室消息框，每个聊天室一页
+	roomPages := tview.NewPages()
+
+	// 私聊消息框
+	dmBox := tview.NewTextView()
+	dmBox.SetDynamicColors(true)
+	dmBox.SetBorder(true)
+	dmBox.SetTitle("Direct Messages")
+	dmBox.SetChangedFunc(func() { app.Draw() })
+
+	roomsList := tview.NewTextView()
+	roomsList.SetDynamicColors(true)
+	roomsList.SetBorder(true)
+	roomsList.SetTitle("Rooms (Ctrl+N/Ctrl+P)")
 
-	peersList := tview.NewTextView()
+	// 节点面板：Tab 切换焦点，Enter 私聊好友或者添加陌生人为好友
+	peersList := tview.NewList()
+	peersList.ShowSecondaryText(false)
 	peersList.SetBorder(true)
 	peersList.SetTitle("Peers")
-	peersList.SetChangedFunc(func() { app.Draw() })
+
+	// 文件传输的进度
+	transfers := tview.NewTextView()
+	transfers.SetDynamicColors(true)
+	transfers.SetBorder(true)
+	transfers.SetTitle("Transfers")
+
+	sidePanel := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(roomsList, 0, 1, false).
+		AddItem(peersList, 0, 2, false).
+		AddItem(transfers, 0, 1, false)
 
 	chatPanel := tview.NewFlex().
-		AddItem(msgBox, 0, 1, false).
-		AddItem(peersList, 35, 1, false)
+		AddItem(roomPages, 0, 2, false).
+		AddItem(dmBox, 0, 1, false).
+		AddItem(sidePanel, 35, 1, false)
 
 	// 用户输入框
 	input := tview.NewInputField().
-		SetLabel(chatroom.nick + " > ").
+		SetLabel(dm.nick + " > ").
 		SetFieldWidth(0).
 		SetFieldBackgroundColor(tcell.ColorBlack)
 	// 缓存用户输入
@@ -53,13 +153,50 @@ func NewChatUI(chatroom *ChatRoom) *ChatUI {
 			return
 		}
 
-		if line == "/quit" {
-			app.Stop()
-			return
-		}
 		inputCh <- line
 		input.SetText("")
 	})
+	// 输入框的变化用于发送正在输入和判断是否离开，忙不过来时直接丢弃
+	typingCh := make(chan string, 1)
+	input.SetChangedFunc(func(text string) {
+		select {
+		case typingCh <- text:
+		default:
+		}
+	})
+
+	// 翻页查看历史消息：PgUp 翻聊天室，Ctrl+U 翻私聊
+	// 切换聊天室：Ctrl+N 下一个，Ctrl+P 上一个
+	// Tab：输入框有内容时补全，否则在输入框和节点面板之间切换
+	pageCh := make(chan string, 8)
+	switchCh := make(chan int, 8)
+	completeCh := make(chan string, 8)
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyTab:
+			if input.HasFocus() && len(input.GetText()) > 0 {
+				completeCh <- input.GetText()
+			} else if input.HasFocus() {
+				app.SetFocus(peersList)
+			} else {
+				app.SetFocus(input)
+			}
+			return nil
+		case tcell.KeyPgUp:
+			pageCh <- PANE_ROOM
+			return nil
+		case tcell.KeyCtrlU:
+			pageCh <- PANE_DM
+			return nil
+		case tcell.KeyCtrlN:
+			switchCh <- 1
+			return nil
+		case tcell.KeyCtrlP:
+			switchCh <- -1
+			return nil
+		}
+		return event
+	})
 
 	flex := tview.NewFlex().
 		SetDirection(tview.FlexRow).
@@ -67,14 +204,53 @@ func NewChatUI(chatroom *ChatRoom) *ChatUI {
 		AddItem(input, 1, 1, true)
 	app.SetRoot(flex, true)
 
-	return &ChatUI{
-		chatroom:  chatroom,
-		app:       app,
-		peersList: peersList,
-		msgW:      msgBox,
-		inputCh:   inputCh,
-		doneCh:    make(chan struct{}),
+	ui := &ChatUI{
+		ctx:        dm.ctx,
+		self:       dm.host.ID(),
+		nick:       dm.nick,
+		dm:         dm,
+		friends:    friends,
+		discovery:  discovery,
+		files:      files,
+		app:        app,
+		roomPages:  roomPages,
+		roomsList:  roomsList,
+		dmBox:      dmBox,
+		peersList:  peersList,
+		transfers:  transfers,
+		input:      input,
+		dmW:        dmBox,
+		inputCh:    inputCh,
+		pageCh:     pageCh,
+		switchCh:   switchCh,
+		completeCh: completeCh,
+		typingCh:   typingCh,
+		roomMsgCh:  make(chan *roomMessage, ChatRoomBufSize),
+		resultCh:   make(chan func(), 8),
+		doneCh:     make(chan struct{}),
+		nicks:      make(map[string]string),
+		presence:   make(map[string]*peerPresence),
+		progress:   make(map[string]*FileProgress),
+		lastActive: time.Now(),
+		commands:   newCommands(),
 	}
+	if len(chatrooms) > 0 {
+		ui.ps = chatrooms[0].ps
+	}
+
+	for _, chatroom := range chatrooms {
+		ui.addRoom(chatroom)
+	}
+	ui.switchRoom(0)
+
+	// 加载最近的私聊历史消息
+	ui.dmOldest = time.Now().UnixMilli()
+	ui.loadHistory(PANE_DM)
+
+	// 显示重启前尚未处理的好友请求
+	ui.listFriendRequests()
+
+	return ui
 }
 
 func (ui *ChatUI) Run() error {
@@ -88,33 +264,184 @@ func (ui *ChatUI) end() {
 	ui.doneCh <- struct{}{}
 }
 
+// listRooms 返回界面中所有的聊天室，包括运行中加入的聊天室
+func (ui *ChatUI) listRooms() []*ChatRoom {
+	chatrooms := make([]*ChatRoom, 0, len(ui.rooms))
+	for _, rv := range ui.rooms {
+		chatrooms = append(chatrooms, rv.room)
+	}
+	return chatrooms
+}
+
+// addRoom 为聊天室创建消息框，加载历史消息，并开始转发聊天室的消息
+func (ui *ChatUI) addRoom(chatroom *ChatRoom) {
+	msgBox := tview.NewTextView()
+	msgBox.SetDynamicColors(true)
+	msgBox.SetBorder(true)
+	msgBox.SetTitle(fmt.Sprintf("Room: %s", chatroom.roomName))
+
+	rv := &roomView{
+		room:   chatroom,
+		box:    msgBox,
+		oldest: time.Now().UnixMilli(),
+		typing: make(map[string]time.Time),
+		readBy: make(map[string]bool),
+	}
+	ui.rooms = append(ui.rooms, rv)
+	ui.app.QueueUpdateDraw(func() {
+		ui.roomPages.AddPage(chatroom.topicId, msgBox, true, false)
+	})
+	ui.loadRoomHistory(rv)
+
+	go func() {
+		for m := range chatroom.Messages {
+			ui.roomMsgCh <- &roomMessage{room: chatroom, msg: m}
+		}
+	}()
+}
+
+// switchRoom 在聊天室之间切换，{delta}为相对当前聊天室的偏移
+func (ui *ChatUI) switchRoom(delta int) {
+	if len(ui.rooms) == 0 {
+		ui.current = 0
+		ui.refreshRooms()
+		return
+	}
+
+	// 离开的聊天室中已经显示的消息都读过了
+	ui.flushReadReceipts()
+	ui.current = (ui.current + delta + len(ui.rooms)) % len(ui.rooms)
+	rv := ui.rooms[ui.current]
+	rv.unread = 0
+	// 页面属于界面的 goroutine，事件循环中不能直接修改
+	ui.app.QueueUpdateDraw(func() {
+		ui.roomPages.SwitchToPage(rv.room.topicId)
+	})
+	ui.refreshRooms()
+}
+
+func (ui *ChatUI) currentRoom() *roomView {
+	if len(ui.rooms) == 0 {
+		return nil
+	}
+	return ui.rooms[ui.current]
+}
+
+func (ui *ChatUI) findRoom(chatroom *ChatRoom) *roomView {
+	for _, rv := range ui.rooms {
+		if rv.room == chatroom {
+			return rv
+		}
+	}
+	return nil
+}
+
 func (ui *ChatUI) handleEvents() {
 
 	peerRefreshTicker := time.NewTicker(time.Second)
 	defer peerRefreshTicker.Stop()
+	readReceiptTicker := time.NewTicker(readReceiptInterval)
+	defer readReceiptTicker.Stop()
+	pingTicker := time.NewTicker(pingInterval)
+	defer pingTicker.Stop()
+	ui.pingPeers()
 
 	for {
 		select {
 		case input := <-ui.inputCh: // 读取用户输入
 			{
 				log.Printf("[ui] read input: %s", input)
-				err := ui.chatroom.Publish(input)
+				if ui.handleCommand(input) {
+					continue
+				}
+				rv := ui.currentRoom()
+				if rv == nil {
+					continue
+				}
+				id, err := rv.room.Publish(input)
 				if err != nil {
 					printErr("publish error: %s", err)
+				} else {
+					rv.lastSent = id
+					rv.readBy = make(map[string]bool)
+					ui.lastTyping = time.Time{}
+					ui.refreshRoomTitle(rv)
 				}
-				ui.displaySelfMessage(input)
+				ui.displaySelfMessage(rv, input)
+			}
+		case text := <-ui.typingCh: // 正在输入
+			{
+				ui.onInput(text)
+			}
+		case rm := <-ui.roomMsgCh: // 读取聊天室内容
+			{
+				log.Printf("[ui] receive msg: %v", rm.msg)
+				ui.displayChatMessage(rm.room, rm.msg)
+			}
+		case m := <-ui.dm.Messages: // 读取好友私聊
+			{
+				log.Printf("[ui] receive direct msg: %v", m)
+				ui.displayDirectMessage(m, false)
+			}
+		case m := <-ui.dm.Requests: // 读取陌生人私聊
+			{
+				log.Printf("[ui] receive direct msg request: %v", m)
+				ui.displayDirectMessage(m, true)
 			}
-		case m := <-ui.chatroom.Messages: // 读取聊天室内容
+		case hs := <-ui.friends.Events: // 读取好友请求及应答
 			{
-				log.Printf("[ui] receive msg: %s", m)
-				ui.displayChatMessage(m)
+				log.Printf("[ui] receive friend %s: %v", hs.Type, hs)
+				ui.displayFriendHandshake(hs)
+			}
+		case p := <-ui.files.Progress: // 文件传输进度
+			{
+				ui.displayProgress(p)
+			}
+		case pane := <-ui.pageCh: // 翻看历史消息
+			{
+				log.Printf("[ui] load history of %s", pane)
+				ui.loadHistory(pane)
+				ui.app.Draw()
+			}
+		case text := <-ui.completeCh: // 补全输入
+			{
+				completed, matches := ui.complete(text)
+				if len(matches) > 0 {
+					ui.printInfo(strings.Join(matches, "  "))
+				}
+				ui.app.QueueUpdateDraw(func() {
+					if ui.input.GetText() == text {
+						ui.input.SetText(completed)
+					}
+				})
+			}
+		case f := <-ui.resultCh: // 后台命令的结果
+			{
+				f()
+			}
+		case delta := <-ui.switchCh: // 切换聊天室
+			{
+				ui.switchRoom(delta)
+				ui.refreshPeers()
 			}
 		case <-peerRefreshTicker.C:
 			{
 				log.Println("[ui] refresh peer list")
 				ui.refreshPeers()
+				ui.checkAway()
+				if rv := ui.currentRoom(); rv != nil && len(rv.typing) > 0 {
+					ui.refreshRoomTitle(rv)
+				}
 			}
-		case <-ui.chatroom.ctx.Done(): // 聊天室结束
+		case <-pingTicker.C:
+			{
+				ui.pingPeers()
+			}
+		case <-readReceiptTicker.C:
+			{
+				ui.flushReadReceipts()
+			}
+		case <-ui.ctx.Done(): // 聊天室结束
 			{
 				log.Println("[ui] chatroom done")
 				return
@@ -128,26 +455,197 @@ func (ui *ChatUI) handleEvents() {
 	}
 }
 
-func (ui *ChatUI) displayChatMessage(cm *ChatMessage) {
+func (ui *ChatUI) displayChatMessage(chatroom *ChatRoom, cm *ChatMessage) {
+	rv := ui.findRoom(chatroom)
+	if rv == nil {
+		return
+	}
+
+	ui.rememberNick(cm.SenderID, cm.SenderNick)
+	ui.updatePresence(rv, cm)
+	if !cm.IsText() && cm.Type != CHAT_TYPE_FILE {
+		return
+	}
+
 	prompt := withColor("green", fmt.Sprintf("<%s>:", cm.SenderNick))
-	fmt.Fprintf(ui.msgW, "%s %s\n", prompt, cm.Message)
+	fmt.Fprintf(rv.box, "%s %s\n", prompt, cm.Message)
+	if cm.File != nil {
+		ui.displayFileOffer(rv.box, cm.SenderID, cm.File)
+	}
+	rv.addUnreadId(cm.Id)
+
+	if rv != ui.currentRoom() {
+		rv.unread++
+		ui.refreshRooms()
+	}
+}
+
+func (ui *ChatUI) displaySelfMessage(rv *roomView, m string) {
+	prompt := withColor("yellow", fmt.Sprintf("<%s>:", ui.nick))
+	fmt.Fprintf(rv.box, "%s %s\n", prompt, m)
+}
+
+func (ui *ChatUI) displayDirectMessage(m *DirectMessage, request bool) {
+	ui.rememberNick(m.SenderID, m.SenderNick)
+	if request {
+		prompt := withColor("red", fmt.Sprintf("[request]<%s(%s)>:", m.SenderNick, m.SenderID))
+		fmt.Fprintf(ui.dmW, "%s %s\n", prompt, m.Message)
+		return
+	}
+
+	prompt := withColor("green", fmt.Sprintf("<%s>:", m.SenderNick))
+	fmt.Fprintf(ui.dmW, "%s %s\n", prompt, m.Message)
+	if m.File != nil {
+		ui.displayFileOffer(ui.dmW, m.SenderID, m.File)
+	}
+}
+
+func (ui *ChatUI) listFriendRequests() {
+	requests, err := ui.friends.PendingRequests()
+	if err != nil {
+		log.Printf("[ui] load friend requests failed, err = %v", err)
+		return
+	}
+
+	for _, request := range requests {
+		prompt := withColor("red", fmt.Sprintf("(friend request)<%s(%s)>:", request.Nickname, request.PeerId))
+		fmt.Fprintf(ui.dmW, "%s /accept or /reject %s\n", prompt, request.Nickname)
+	}
 }
 
-func (ui *ChatUI) displaySelfMessage(m string) {
-	prompt := withColor("yellow", fmt.Sprintf("<%s>:", ui.chatroom.nick))
-	fmt.Fprintf(ui.msgW, "%s %s\n", prompt, m)
+// listDirectRequests 列出陌生人发来的、还没有处理的私聊
+func (ui *ChatUI) listDirectRequests() {
+	for pid, msgs := range ui.dm.PendingRequests() {
+		prompt := withColor("red", fmt.Sprintf("(%d message(s))<%s(%s)>:", len(msgs), msgs[0].SenderNick, pid))
+		fmt.Fprintf(ui.dmW, "%s /allow or /drop %s\n", prompt, shortID(pid))
+	}
+}
+
+func (ui *ChatUI) displayFriendHandshake(hs *FriendHandshake) {
+	ui.rememberNick(hs.SenderID, hs.SenderNick)
+	switch hs.Type {
+	case FRIEND_REQUEST:
+		prompt := withColor("red", fmt.Sprintf("(friend request)<%s(%s)>:", hs.SenderNick, hs.SenderID))
+		fmt.Fprintf(ui.dmW, "%s /accept or /reject %s\n", prompt, hs.SenderNick)
+	case FRIEND_ACCEPT:
+		fmt.Fprintln(ui.dmW, withColor("yellow", fmt.Sprintf("<%s> accepted your friend request", hs.SenderNick)))
+	case FRIEND_REJECT:
+		fmt.Fprintln(ui.dmW, withColor("red", fmt.Sprintf("<%s> rejected your friend request", hs.SenderNick)))
+	}
+}
+
+// loadHistory 加载更早的一页历史消息，插入到消息框的顶部
+func (ui *ChatUI) loadHistory(pane string) {
+	switch pane {
+	case PANE_ROOM:
+		if rv := ui.currentRoom(); rv != nil {
+			ui.loadRoomHistory(rv)
+		}
+	case PANE_DM:
+		messages, err := history.LoadDirectMessages(ui.self.String(), ui.dmOldest, HistoryPageSize)
+		if err != nil {
+			log.Printf("[ui] load history of %s failed, err = %v", pane, err)
+			return
+		}
+		ui.prependHistory(ui.dmBox, messages, &ui.dmOldest)
+	}
+}
+
+func (ui *ChatUI) loadRoomHistory(rv *roomView) {
+	messages, err := history.LoadRoomMessages(ui.self.String(), rv.room.topicId, rv.oldest, HistoryPageSize)
+	if err != nil {
+		log.Printf("[ui] load history of room `%s` failed, err = %v", rv.room.roomName, err)
+		return
+	}
+	ui.prependHistory(rv.box, messages, &rv.oldest)
+}
+
+func (ui *ChatUI) prependHistory(box *tview.TextView, messages []*history.Message, oldest *int64) {
+	if len(messages) == 0 {
+		return
+	}
+	*oldest = messages[0].Timestamp
+
+	var sb strings.Builder
+	for _, m := range messages {
+		sb.WriteString(ui.formatHistory(m))
+	}
+	box.SetText(sb.String() + box.GetText(false))
+	box.ScrollToBeginning()
+}
+
+func (ui *ChatUI) formatHistory(m *history.Message) string {
+	ts := time.UnixMilli(m.Timestamp).Format("01-02 15:04")
+	self := m.SenderID == ui.self.String()
+
+	var prompt string
+	switch {
+	case len(m.Peer) > 0 && self:
+		peerName := m.Peer
+		if friend, ok := global.FindMyFriend(m.Peer); ok {
+			peerName = friend.Nickname
+		}
+		prompt = withColor("yellow", fmt.Sprintf("<%s -> %s>:", m.SenderNick, peerName))
+	case self:
+		prompt = withColor("yellow", fmt.Sprintf("<%s>:", m.SenderNick))
+	default:
+		prompt = withColor("green", fmt.Sprintf("<%s>:", m.SenderNick))
+	}
+
+	return fmt.Sprintf("%s %s %s\n", withColor("gray", ts), prompt, m.Content)
 }
 
 func withColor(color, msg string) string {
 	return fmt.Sprintf("[%s]%s[-]", color, msg)
 }
 
+func (ui *ChatUI) refreshRooms() {
+	ui.roomsList.Clear()
+	for i, rv := range ui.rooms {
+		name := rv.room.roomName
+		if rv.room.group != nil && rv.room.group.IsInviteOnly() {
+			name += " (invite)"
+		}
+		if rv.unread > 0 {
+			name += withColor("red", fmt.Sprintf(" (%d)", rv.unread))
+		}
+		if i == ui.current {
+			name = withColor("yellow", "> ") + name
+		} else {
+			name = "  " + name
+		}
+		fmt.Fprintln(ui.roomsList, name)
+	}
+}
+
 func (ui *ChatUI) refreshPeers() {
-	peers := ui.chatroom.ListPeers()
+	title := ui.discoveryTitle()
+	rows := ui.peerRows()
 
-	ui.peersList.Clear()
-	for _, p := range peers {
-		fmt.Fprintln(ui.peersList, shortID(p))
+	// List 不是线程安全的，在界面的协程中更新
+	ui.app.QueueUpdateDraw(func() {
+		current := ui.peersList.GetCurrentItem()
+		ui.peersList.SetTitle(title)
+		ui.peersList.Clear()
+		for _, row := range rows {
+			row := row
+			ui.peersList.AddItem(row.String(), "", 0, func() { ui.selectPeer(row) })
+		}
+		if current < len(rows) {
+			ui.peersList.SetCurrentItem(current)
+		}
+	})
+}
+
+// discoveryTitle 在节点列表的标题中显示节点发现的状态：已连接/目标，以及最近一次发现新节点的时间
+func (ui *ChatUI) discoveryTitle() string {
+	if ui.discovery == nil {
+		return "Peers"
+	}
+	state := ui.discovery.State()
+	lastFound := "-"
+	if !state.LastFound.IsZero() {
+		lastFound = state.LastFound.Format("15:04:05")
 	}
-	ui.app.Draw()
+	return fmt.Sprintf("Peers %d/%d, found %s", state.Connected, state.Target, lastFound)
 }
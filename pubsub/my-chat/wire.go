@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"slices"
+	"sort"
+	"time"
+)
+
+// 聊天室消息的编码格式。
+// 接收时两种格式都能识别：json 以 `{` 开头，protobuf 以 version 字段开头。
+// 默认发送 json：gossipsub 会把消息转发给不直接相连的节点，
+// 只看直接相连的节点无法确定整个聊天室都能读懂 protobuf，
+// 所以要等所有节点都升级之后再用 -wire protobuf 切换。
+// 切换之后，聊天室中还有只能读懂 json 的节点时拒绝发送。
+const (
+	WIRE_JSON     = "json"
+	WIRE_PROTOBUF = "protobuf"
+)
+
+// ChatWireVersion 是 protobuf 格式的版本
+const ChatWireVersion = 1
+
+// supportedWireFormats 是本节点能够读懂的格式，在在线状态的心跳中发出
+var supportedWireFormats = []string{WIRE_JSON, WIRE_PROTOBUF}
+
+// wireFormat 是发送消息使用的格式，由命令行参数 -wire 指定
+var wireFormat = WIRE_JSON
+
+func SetWireFormat(format string) error {
+	switch format {
+	case WIRE_JSON, WIRE_PROTOBUF:
+		wireFormat = format
+		return nil
+	default:
+		return fmt.Errorf("unknown wire format `%s`", format)
+	}
+}
+
+// peerWire 是从聊天室消息中得知的节点能够读懂的格式
+type peerWire struct {
+	formats  []string
+	lastSeen time.Time
+}
+
+// rememberFormats 记录发送者能够读懂的格式。
+// 心跳中带有格式列表；没有列表的心跳和其它消息来自旧版本的节点时，只能读懂 json。
+func (chatroom *ChatRoom) rememberFormats(cm *ChatMessage) {
+	chatroom.mu.Lock()
+	defer chatroom.mu.Unlock()
+
+	if chatroom.wires == nil {
+		chatroom.wires = make(map[string]*peerWire)
+	}
+	pw, ok := chatroom.wires[cm.SenderID]
+	switch {
+	case cm.Type == CHAT_TYPE_PRESENCE && cm.Status == PRESENCE_OFFLINE:
+		delete(chatroom.wires, cm.SenderID)
+		return
+	case cm.Type == CHAT_TYPE_PRESENCE:
+		formats := cm.Formats
+		if len(formats) == 0 {
+			formats = []string{WIRE_JSON}
+		}
+		pw = &peerWire{formats: formats}
+		chatroom.wires[cm.SenderID] = pw
+	case !ok:
+		// 新版本的节点加入时先发心跳，先收到其它消息的按旧版本处理，收到心跳后更正
+		pw = &peerWire{formats: []string{WIRE_JSON}}
+		chatroom.wires[cm.SenderID] = pw
+	}
+	pw.lastSeen = time.Now()
+}
+
+// PeerFormats 返回{peerId}能够读懂的格式，没有收到过{peerId}的消息时返回 nil
+func (chatroom *ChatRoom) PeerFormats(peerId string) []string {
+	chatroom.mu.Lock()
+	defer chatroom.mu.Unlock()
+
+	if pw, ok := chatroom.wires[peerId]; ok {
+		return pw.formats
+	}
+	return nil
+}
+
+// peersWithout 返回最近有消息、但是读不懂{format}的节点
+func (chatroom *ChatRoom) peersWithout(format string) []string {
+	chatroom.mu.Lock()
+	defer chatroom.mu.Unlock()
+
+	var peers []string
+	for id, pw := range chatroom.wires {
+		if time.Since(pw.lastSeen) > 3*PresenceInterval {
+			delete(chatroom.wires, id)
+			continue
+		}
+		if !slices.Contains(pw.formats, format) {
+			peers = append(peers, id)
+		}
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// encodeChatMessage 用{format}格式签名并编码消息
+func encodeChatMessage(key crypto.PrivKey, cm *ChatMessage, format string) ([]byte, error) {
+	if format != WIRE_PROTOBUF {
+		if err := signChatMessage(key, cm); err != nil {
+			return nil, err
+		}
+		return json.Marshal(cm)
+	}
+
+	pubKey, err := crypto.MarshalPublicKey(key.GetPublic())
+	if err != nil {
+		return nil, fmt.Errorf("marshal public key failed, err = %v", err)
+	}
+	cm.PubKey = pubKey
+
+	env := toChatEnvelope(cm)
+	data, err := env.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if env.Sign, err = key.Sign(data); err != nil {
+		return nil, err
+	}
+	cm.Sign = env.Sign
+	return env.Marshal()
+}
+
+// decodeChatMessage 识别消息的格式并校验签名
+func decodeChatMessage(data []byte) (*ChatMessage, error) {
+	if len(data) > 0 && data[0] == '{' {
+		cm := new(ChatMessage)
+		if err := json.Unmarshal(data, cm); err != nil {
+			return nil, fmt.Errorf("unmarshal json failed, err = %v", err)
+		}
+		if err := authenticateChatMessage(cm); err != nil {
+			return nil, err
+		}
+		cm.wire = WIRE_JSON
+		return cm, nil
+	}
+
+	env := new(pb.ChatEnvelope)
+	if err := env.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("unmarshal protobuf failed, err = %v", err)
+	}
+	if env.Version != ChatWireVersion {
+		return nil, fmt.Errorf("unsupported wire version %d", env.Version)
+	}
+	if err := authenticateChatEnvelope(env); err != nil {
+		return nil, err
+	}
+	cm := fromChatEnvelope(env)
+	cm.wire = WIRE_PROTOBUF
+	return cm, nil
+}
+
+// authenticateChatEnvelope 校验 protobuf 消息的签名，签名覆盖除 sign 之外的所有字段
+func authenticateChatEnvelope(env *pb.ChatEnvelope) error {
+	if len(env.Sign) == 0 {
+		return fmt.Errorf("message is not signed")
+	}
+	sender := env.GetSender()
+	if sender == nil {
+		return fmt.Errorf("message has no sender")
+	}
+
+	sign := env.Sign
+	env.Sign = nil
+	data, err := env.Marshal()
+	env.Sign = sign
+	if err != nil {
+		return fmt.Errorf("marshal message failed, err = %v", err)
+	}
+
+	senderId, err := peer.Decode(sender.Id)
+	if err != nil {
+		return fmt.Errorf("decode sender id failed, err = %v", err)
+	}
+
+	return verifyData(data, sign, senderId, sender.PubKey)
+}
+
+func toChatEnvelope(cm *ChatMessage) *pb.ChatEnvelope {
+	env := &pb.ChatEnvelope{
+		Version:   ChatWireVersion,
+		Type:      cm.Type,
+		Id:        cm.Id,
+		Timestamp: cm.Timestamp,
+		Sender: &pb.ChatSender{
+			Id:     cm.SenderID,
+			Nick:   cm.SenderNick,
+			PubKey: cm.PubKey,
+		},
+		Body: &pb.ChatBody{
+			Message: cm.Message,
+			Status:  cm.Status,
+			ReadIds: cm.ReadIds,
+			Formats: cm.Formats,
+		},
+	}
+	if cm.File != nil {
+		env.Body.File = &pb.FileOffer{
+			Id:     cm.File.Id,
+			Name:   cm.File.Name,
+			Size_:  cm.File.Size,
+			Sha256: cm.File.Sha256,
+		}
+	}
+	return env
+}
+
+func fromChatEnvelope(env *pb.ChatEnvelope) *ChatMessage {
+	sender := env.GetSender()
+	body := env.GetBody()
+	cm := &ChatMessage{
+		Id:         env.Id,
+		Type:       env.Type,
+		Message:    body.GetMessage(),
+		SenderID:   sender.GetId(),
+		SenderNick: sender.GetNick(),
+		Timestamp:  env.Timestamp,
+		Status:     body.GetStatus(),
+		ReadIds:    body.GetReadIds(),
+		Formats:    body.GetFormats(),
+		PubKey:     sender.GetPubKey(),
+		Sign:       env.Sign,
+	}
+	if file := body.GetFile(); file != nil {
+		cm.File = &FileOffer{
+			Id:     file.Id,
+			Name:   file.Name,
+			Size:   file.Size_,
+			Sha256: file.Sha256,
+		}
+	}
+	return cm
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/czh0526/libp2p-examples/pubsub/my-chat/pb"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestChatMessageWire_Protobuf(t *testing.T) {
+	cm, privKey := newSignedMessage(t)
+	cm.Type = CHAT_TYPE_FILE
+	cm.File = &FileOffer{Id: "f1", Name: "a.txt", Size: 10, Sha256: "00"}
+
+	data, err := encodeChatMessage(privKey, cm, WIRE_PROTOBUF)
+	assert.NoError(t, err)
+	assert.NotEqual(t, byte('{'), data[0])
+
+	decoded, err := decodeChatMessage(data)
+	assert.NoError(t, err)
+	assert.Equal(t, WIRE_PROTOBUF, decoded.wire)
+	assert.Equal(t, cm.Id, decoded.Id)
+	assert.Equal(t, cm.SenderID, decoded.SenderID)
+	assert.Equal(t, cm.Message, decoded.Message)
+	assert.Equal(t, cm.File, decoded.File)
+}
+
+func TestChatMessageWire_JSON(t *testing.T) {
+	cm, privKey := newSignedMessage(t)
+
+	data, err := encodeChatMessage(privKey, cm, WIRE_JSON)
+	assert.NoError(t, err)
+	assert.Equal(t, byte('{'), data[0])
+
+	decoded, err := decodeChatMessage(data)
+	assert.NoError(t, err)
+	assert.Equal(t, WIRE_JSON, decoded.wire)
+	assert.Equal(t, cm.Message, decoded.Message)
+}
+
+func TestChatMessageWire_Tampered(t *testing.T) {
+	cm, privKey := newSignedMessage(t)
+	data, err := encodeChatMessage(privKey, cm, WIRE_PROTOBUF)
+	assert.NoError(t, err)
+
+	env := new(pb.ChatEnvelope)
+	assert.NoError(t, env.Unmarshal(data))
+	env.Body.Message = "forged"
+	data, err = env.Marshal()
+	assert.NoError(t, err)
+
+	_, err = decodeChatMessage(data)
+	assert.Error(t, err)
+}
+
+func TestChatMessageWire_Version(t *testing.T) {
+	cm, privKey := newSignedMessage(t)
+	data, err := encodeChatMessage(privKey, cm, WIRE_PROTOBUF)
+	assert.NoError(t, err)
+
+	env := new(pb.ChatEnvelope)
+	assert.NoError(t, env.Unmarshal(data))
+	env.Version = ChatWireVersion + 1
+	data, err = env.Marshal()
+	assert.NoError(t, err)
+
+	_, err = decodeChatMessage(data)
+	assert.Error(t, err)
+}
+
+func TestChatRoomPeerFormats(t *testing.T) {
+	chatroom := &ChatRoom{roomName: "test"}
+
+	// 先收到文本消息的节点按旧版本处理，收到心跳后更正
+	chatroom.rememberFormats(&ChatMessage{SenderID: "new", Message: "hi"})
+	assert.Equal(t, []string{"new"}, chatroom.peersWithout(WIRE_PROTOBUF))
+	chatroom.rememberFormats(&ChatMessage{SenderID: "new", Type: CHAT_TYPE_PRESENCE,
+		Status: PRESENCE_ONLINE, Formats: supportedWireFormats})
+	assert.Empty(t, chatroom.peersWithout(WIRE_PROTOBUF))
+	assert.Equal(t, supportedWireFormats, chatroom.PeerFormats("new"))
+
+	// 心跳中没有格式列表的节点只能读懂 json
+	chatroom.rememberFormats(&ChatMessage{SenderID: "old", Type: CHAT_TYPE_PRESENCE, Status: PRESENCE_ONLINE})
+	assert.Equal(t, []string{"old"}, chatroom.peersWithout(WIRE_PROTOBUF))
+	assert.Empty(t, chatroom.peersWithout(WIRE_JSON))
+
+	// 离线和长时间没有消息的节点不再检查
+	chatroom.rememberFormats(&ChatMessage{SenderID: "old", Type: CHAT_TYPE_PRESENCE, Status: PRESENCE_OFFLINE})
+	assert.Empty(t, chatroom.peersWithout(WIRE_PROTOBUF))
+	assert.Nil(t, chatroom.PeerFormats("old"))
+	chatroom.rememberFormats(&ChatMessage{SenderID: "old", Message: "hi"})
+	chatroom.wires["old"].lastSeen = time.Now().Add(-4 * PresenceInterval)
+	assert.Empty(t, chatroom.peersWithout(WIRE_PROTOBUF))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"github.com/czh0526/libp2p-examples/signedproto"
 	"github.com/czh0526/libp2p-examples/utils"
 	"github.com/libp2p/go-libp2p"
 	kaddht "github.com/libp2p/go-libp2p-kad-dht"
@@ -23,18 +24,20 @@ var (
 
 func main() {
 	id := flag.Int("id", 0, "peer number to start")
+	window := flag.Duration("window", signedproto.DefaultAcceptanceWindow, "max clock skew of accepted messages")
+	seen := flag.Int("seen", signedproto.DefaultSeenCacheSize, "number of message ids remembered per peer")
 	flag.Parse()
 
 	if *id < 1 {
 		panic("id should be greater than 0")
 	}
 
-	host := makeNode(*id)
+	host := makeNode(*id, signedproto.NewMessageGuard(*window, *seen))
 
 	host.run()
 }
 
-func makeNode(id int) *Node {
+func makeNode(id int, guard *signedproto.MessageGuard) *Node {
 	ctx := context.Background()
 
 	// 读取固定的私钥文件
@@ -72,5 +75,5 @@ func makeNode(id int) *Node {
 		panic(fmt.Sprintf("connect bootstrap peers failed, err = %v", err))
 	}
 
-	return NewNode(routedHost)
+	return NewNode(routedHost, guard)
 }
@@ -3,10 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
-	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
-	ggio "github.com/gogo/protobuf/io"
-	"github.com/gogo/protobuf/proto"
-	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/czh0526/libp2p-examples/signedproto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -18,18 +15,15 @@ import (
 	"time"
 )
 
-const (
-	clientVersion = "go-p2p-node/0.0.1"
-)
-
 type Node struct {
 	host.Host
+	*signedproto.Service
 	*PingProtocol
 	*EchoProtocol
 }
 
-func NewNode(host host.Host) *Node {
-	node := &Node{Host: host}
+func NewNode(host host.Host, guard *signedproto.MessageGuard) *Node {
+	node := &Node{Host: host, Service: signedproto.NewService(host, guard)}
 	node.PingProtocol = NewPingProtocol(node)
 	node.EchoProtocol = NewEchoProtocol(node)
 
@@ -67,95 +61,13 @@ func (n *Node) run() {
 			s.Close()
 			fmt.Println("\n========== good result ===========")
 			fmt.Println()
+			if rejected := n.Guard().String(); len(rejected) > 0 {
+				fmt.Printf("rejected messages: %s\n", rejected)
+			}
 		}
 	}
 }
 
-func (n *Node) NewMessageData(messageId string, gossip bool) *p2p.MessageData {
-	nodePubKey, err := crypto.MarshalPublicKey(n.Peerstore().PubKey(n.ID()))
-	if err != nil {
-		panic("Failed to marshal public key for sender from local peer store.")
-	}
-
-	return &p2p.MessageData{
-		ClientVersion: clientVersion,
-		NodeId:        n.ID().String(),
-		NodePubKey:    nodePubKey,
-		Timestamp:     time.Now().Unix(),
-		Id:            messageId,
-		Gossip:        gossip,
-	}
-}
-
-func (n *Node) SignProtoMessage(message proto.Message) ([]byte, error) {
-	data, err := proto.Marshal(message)
-	if err != nil {
-		return nil, err
-	}
-	return n.signData(data)
-}
-
-func (n *Node) signData(data []byte) ([]byte, error) {
-	key := n.Peerstore().PrivKey(n.ID())
-	return key.Sign(data)
-}
-
-func (n *Node) AuthenticateMessage(message proto.Message, data *p2p.MessageData) bool {
-	sign := data.Sign
-	data.Sign = nil
-	bin, err := proto.Marshal(message)
-	if err != nil {
-		log.Printf("Failed to marshal message: %v", err)
-		return false
-	}
-	data.Sign = sign
-
-	peerId, err := peer.Decode(data.NodeId)
-	if err != nil {
-		log.Printf("Failed to decode peer id from base58: %v", err)
-		return false
-	}
-
-	return n.verifyData(bin, sign, peerId, data.NodePubKey)
-}
-
-func (n *Node) verifyData(data []byte, signature []byte, peerId peer.ID, pubKeyData []byte) bool {
-	key, err := crypto.UnmarshalPublicKey(pubKeyData)
-	if err != nil {
-		log.Println("Failed to unmarshal public key")
-		return false
-	}
-
-	idFromKey, err := peer.IDFromPublicKey(key)
-	if err != nil {
-		log.Println("Failed to extract peer id from public key")
-		return false
-	}
-
-	if idFromKey != peerId {
-		log.Println("Invalid peer id")
-		return false
-	}
-
-	res, err := key.Verify(data, signature)
-	if err != nil {
-		log.Println("Failed to verify signature")
-		return false
-	}
-	return res
-}
-
-func (n *Node) SendProtoMessage(s network.Stream, data proto.Message) bool {
-	writer := ggio.NewFullWriter(s)
-	err := writer.WriteMsg(data)
-	if err != nil {
-		log.Println(err)
-		s.Reset()
-		return false
-	}
-	return true
-}
-
 func (n *Node) ConnectByRelay(pid peer.ID, protocolId protocol.ID) (network.Stream, error) {
 	rHost := n.Host
 
@@ -4,62 +4,45 @@ import (
 	"context"
 	"fmt"
 	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
-	"github.com/google/uuid"
-	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/czh0526/libp2p-examples/signedproto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"log"
-	"sync"
 )
 
 const PING_Request = "/ping/pingreq/0.0.1"
 const PING_Response = "/ping/pingresp/0.0.1"
 
 type PingProtocol struct {
-	node     *Node
-	mu       sync.Mutex
-	requests map[string]*p2p.PingRequest
+	node  *Node
+	proto *signedproto.Protocol[*p2p.PingRequest, *p2p.PingResponse]
 }
 
 func NewPingProtocol(node *Node) *PingProtocol {
-	p := &PingProtocol{node: node, requests: make(map[string]*p2p.PingRequest)}
-	node.SetStreamHandler(PING_Request, p.onPingRequest)
+	p := &PingProtocol{node: node}
+	// 单向协议，不回应答
+	p.proto = signedproto.Register(node.Service, signedproto.Spec[*p2p.PingRequest, *p2p.PingResponse]{
+		RequestID: PING_Request,
+		NewRequest: func(data *p2p.MessageData) *p2p.PingRequest {
+			return &p2p.PingRequest{MessageData: data}
+		},
+	}, p.onPingRequest)
 	return p
 }
 
-func (p *PingProtocol) onPingRequest(s network.Stream) {
-	fmt.Printf("【ping】Read `ping` request from %s \n", s.Conn().RemotePeer())
-	s.Close()
+func (p *PingProtocol) onPingRequest(from peer.ID, req *p2p.PingRequest, _ *p2p.PingResponse) error {
+	fmt.Printf("【ping】Read `ping` request from %s, Message = %v \n", from, req.Message)
+	return nil
 }
 
 func (p *PingProtocol) Ping(peerId peer.ID) bool {
 	fmt.Printf("【ping】Plan to send ping to: %s \n", peerId)
 
-	req := &p2p.PingRequest{
-		MessageData: p.node.NewMessageData(uuid.New().String(), false),
-		Message:     fmt.Sprintf("Ping from %s", p.node.ID()),
-	}
-
-	signature, err := p.node.SignProtoMessage(req)
-	if err != nil {
-		log.Printf("%s: sign ping data failed: err = %v", p.node.ID(), err)
-		return false
-	}
+	req := p.proto.NewRequest()
+	req.Message = fmt.Sprintf("Ping from %s", p.node.ID())
 
-	req.MessageData.Sign = signature
-
-	p.mu.Lock()
-	p.requests[req.MessageData.Id] = req
-	p.mu.Unlock()
-
-	s, err := p.node.NewStream(context.Background(), peerId, PING_Request)
+	err := p.proto.Notify(context.Background(), peerId, req)
 	if err != nil {
-		log.Printf("new stream failed: err = %v", err)
-		return false
-	}
-	defer s.Close()
-
-	ok := p.node.SendProtoMessage(s, req)
-	if !ok {
+		log.Printf("send ping failed: err = %v", err)
 		return false
 	}
 
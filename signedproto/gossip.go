@@ -0,0 +1,179 @@
+package signedproto
+
+import (
+	"context"
+	ggio "github.com/gogo/protobuf/io"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"log"
+	"sync"
+)
+
+// Gossip=true 的消息在节点之间洪泛：收到的节点校验作者的签名后交给处理函数，
+// 不回应答，再把原始的签名消息转发给其他连接的节点。
+// 每转发一次 Hops 加一，Hops 不在签名的范围内，所以转发的节点不需要重新签名。
+const (
+	// 消息最多被转发几次
+	DefaultGossipHops = 3
+	// 最多记住多少个转发过的消息
+	DefaultGossipCacheSize = 4096
+)
+
+// gossipCache 记住已经处理过的 gossip 消息，从其他节点再收到时直接丢弃
+type gossipCache struct {
+	mu    sync.Mutex
+	size  int
+	ids   map[string]struct{}
+	order []string
+}
+
+func newGossipCache(size int) *gossipCache {
+	return &gossipCache{size: size, ids: make(map[string]struct{})}
+}
+
+// add 记住{author}的消息{id}，已经见过时返回 false
+func (c *gossipCache) add(author peer.ID, id string) bool {
+	key := author.String() + "/" + id
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.ids[key]; ok {
+		return false
+	}
+	if len(c.order) >= c.size {
+		delete(c.ids, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.ids[key] = struct{}{}
+	c.order = append(c.order, key)
+	return true
+}
+
+// SetGossipHops 设置 gossip 消息最多被转发几次，0 表示只发给直接连接的节点，
+// 需要在收发消息之前设置
+func (s *Service) SetGossipHops(hops uint32) {
+	s.gossipHops = hops
+}
+
+// isGossip 判断消息是否需要洪泛
+func isGossip(message Message) bool {
+	return message.GetMessageData().GetGossip()
+}
+
+// Gossip 签名请求并洪泛给所有连接的节点，收到的节点不回应答
+func (p *Protocol[Req, Resp]) Gossip(ctx context.Context, req Req) error {
+	data := req.GetMessageData()
+	data.Gossip, data.Hops = true, 0
+	if err := p.svc.sign(req); err != nil {
+		return err
+	}
+	// 自己发出的消息被转发回来时不再处理
+	p.svc.gossiped.add(p.svc.host.ID(), data.Id)
+
+	p.forward(ctx, req)
+	return nil
+}
+
+// onGossip 处理收到的 gossip 消息，{from}是转发消息的节点，不一定是作者
+func (p *Protocol[Req, Resp]) onGossip(from peer.ID, req Req) {
+	data := req.GetMessageData()
+	author, err := verifyMessage(req, data)
+	if err != nil {
+		log.Printf("Reject gossip from %s, err = %v", from, err)
+		p.svc.guard.Reject(err)
+		return
+	}
+	// 同一条消息会从多个节点到达，只处理第一次
+	if !p.svc.gossiped.add(author, data.Id) {
+		return
+	}
+	if err = p.svc.guard.Check(author, data); err != nil {
+		log.Printf("Reject gossip from %s, err = %v", from, err)
+		p.svc.guard.Reject(err)
+		return
+	}
+
+	// 应答不会发出，处理函数可以照常填写
+	var resp Resp
+	if p.spec.NewResponse != nil {
+		resp = p.spec.NewResponse(nil)
+	}
+	if err = p.handler(author, req, resp); err != nil {
+		log.Printf("Handle gossip from %s failed, err = %v", author, err)
+		return
+	}
+
+	if data.Hops >= p.svc.gossipHops {
+		return
+	}
+	data.Hops++
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	p.forward(ctx, req, from, author)
+}
+
+// forward 把消息原样发给除{exclude}之外所有连接的节点
+func (p *Protocol[Req, Resp]) forward(ctx context.Context, req Req, exclude ...peer.ID) {
+	var protocols []protocol.ID
+	for _, id := range []protocol.ID{p.spec.ID, p.spec.RequestID} {
+		if len(id) > 0 {
+			protocols = append(protocols, id)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, pid := range p.svc.host.Network().Peers() {
+		if containsPeer(exclude, pid) || !p.svc.mayServe(pid, protocols) {
+			continue
+		}
+		wg.Add(1)
+		go func(pid peer.ID) {
+			defer wg.Done()
+			if err := p.send(ctx, pid, protocols, req); err != nil {
+				log.Printf("Forward gossip to %s failed, err = %v", pid, err)
+			}
+		}(pid)
+	}
+	wg.Wait()
+}
+
+// send 向{pid}发出单向的消息，协商的结果决定消息是否带长度前缀
+func (p *Protocol[Req, Resp]) send(ctx context.Context, pid peer.ID, protocols []protocol.ID, req Req) error {
+	s, err := p.svc.host.NewStream(ctx, pid, protocols...)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetWriteDeadline(deadline)
+	}
+
+	if s.Protocol() != p.spec.ID {
+		defer s.Close()
+		return writeMessage(s, req)
+	}
+	if err = ggio.NewDelimitedWriter(s).WriteMsg(req); err != nil {
+		s.Reset()
+		return err
+	}
+	return s.Close()
+}
+
+// mayServe 判断{pid}是否可能支持{protocols}，还不知道对方协议（identify 没有完成）时也尝试
+func (s *Service) mayServe(pid peer.ID, protocols []protocol.ID) bool {
+	known, err := s.host.Peerstore().GetProtocols(pid)
+	if err != nil || len(known) == 0 {
+		return true
+	}
+	supported, err := s.host.Peerstore().SupportsProtocols(pid, protocols...)
+	return err == nil && len(supported) > 0
+}
+
+func containsPeer(peers []peer.ID, pid peer.ID) bool {
+	for _, p := range peers {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package signedproto
+
+import (
+	"context"
+	"errors"
+	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testProtocol = "/test/gossip/1.0.0"
+
+// gossipNode 记录收到的 gossip 消息
+type gossipNode struct {
+	*testNode
+
+	mu       sync.Mutex
+	received []string
+}
+
+func (n *gossipNode) messages() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.received...)
+}
+
+func newGossipNodes(t *testing.T, count int, hops uint32) []*gossipNode {
+	spec := echoSpec{
+		ID: testProtocol,
+		NewRequest: func(data *p2p.MessageData) *p2p.EchoRequest {
+			return &p2p.EchoRequest{MessageData: data}
+		},
+	}
+
+	var nodes []*gossipNode
+	for i := 0; i < count; i++ {
+		n := &gossipNode{}
+		n.testNode = newNode(t, spec, func(from peer.ID, req *p2p.EchoRequest, _ *p2p.EchoResponse) error {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			n.received = append(n.received, from.String()+": "+req.Message)
+			return nil
+		})
+		n.svc.SetGossipHops(hops)
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func gossip(t *testing.T, n *gossipNode, message string) {
+	req := n.proto.NewRequest()
+	req.Message = message
+	assert.NoError(t, n.proto.Gossip(context.Background(), req))
+}
+
+func TestGossipFlood(t *testing.T) {
+	// 0 - 1 - 2 - 3，0 和 2 之间也有连接，2 会从 0 和 1 各收到一次
+	nodes := newGossipNodes(t, 4, DefaultGossipHops)
+	connect(t, nodes[0].testNode, nodes[1].testNode)
+	connect(t, nodes[1].testNode, nodes[2].testNode)
+	connect(t, nodes[2].testNode, nodes[3].testNode)
+	connect(t, nodes[0].testNode, nodes[2].testNode)
+
+	gossip(t, nodes[0], "hello")
+
+	want := []string{nodes[0].host.ID().String() + ": hello"}
+	for _, n := range nodes[1:] {
+		assert.Eventually(t, func() bool { return len(n.messages()) > 0 }, 3*time.Second, 10*time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	assert.Empty(t, nodes[0].messages())
+	for _, n := range nodes[1:] {
+		assert.Equal(t, want, n.messages())
+	}
+}
+
+func TestGossipHopLimit(t *testing.T) {
+	// 0 - 1 - 2 - 3，只转发一次：1 收到后转发给 2，2 不再转发
+	nodes := newGossipNodes(t, 4, 1)
+	for i := 0; i < 3; i++ {
+		connect(t, nodes[i].testNode, nodes[i+1].testNode)
+	}
+
+	gossip(t, nodes[0], "hello")
+
+	assert.Eventually(t, func() bool { return len(nodes[2].messages()) > 0 }, 3*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Len(t, nodes[1].messages(), 1)
+	assert.Len(t, nodes[2].messages(), 1)
+	assert.Empty(t, nodes[3].messages())
+}
+
+func TestSignatureExcludesHops(t *testing.T) {
+	nodes := newGossipNodes(t, 1, DefaultGossipHops)
+	req := nodes[0].proto.NewRequest()
+	req.Message = "hello"
+	assert.NoError(t, nodes[0].svc.sign(req))
+
+	// 转发时修改 Hops 不影响签名
+	req.MessageData.Hops = 2
+	author, err := verifyMessage(req, req.MessageData)
+	assert.NoError(t, err)
+	assert.Equal(t, nodes[0].host.ID(), author)
+
+	// 转发的节点不能修改内容
+	req.Message = "tampered"
+	_, err = verifyMessage(req, req.MessageData)
+	assert.True(t, errors.Is(err, ErrInvalidSignature))
+}
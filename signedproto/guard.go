@@ -0,0 +1,168 @@
+package signedproto
+
+import (
+	"errors"
+	"fmt"
+	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 消息的时间戳和本地时间最多相差这么久，超过的消息当作重放丢弃
+	DefaultAcceptanceWindow = 5 * time.Minute
+	// 每个节点最多记住多少个见过的消息 id
+	DefaultSeenCacheSize = 1024
+)
+
+// 消息被拒绝的原因，用 errors.Is 判断
+var (
+	ErrMalformedMessage = errors.New("malformed message")
+	ErrInvalidPeerId    = errors.New("peer id does not match public key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrStaleMessage     = errors.New("timestamp outside acceptance window")
+	ErrReplayedMessage  = errors.New("message id already seen")
+)
+
+var rejectReasons = []error{
+	ErrMalformedMessage,
+	ErrInvalidPeerId,
+	ErrInvalidSignature,
+	ErrStaleMessage,
+	ErrReplayedMessage,
+}
+
+// seenIds 是一个节点最近发来的消息 id，按收到的先后排列。
+// 时间戳只精确到秒，同一秒内的消息分不出先后，所以不按时间戳排序。
+type seenIds struct {
+	ids   map[string]int64
+	order []string
+}
+
+// MessageGuard 检查消息的时间戳和 id，拒绝过期或者重放的消息，并按原因计数。
+// 超出时间窗口的 id 不需要再记住，窗口内的 id 每个节点最多记住 cacheSize 个，
+// 超过时挤掉最早收到的 id，cacheSize 要能容纳一个节点在一个时间窗口内发出的消息。
+type MessageGuard struct {
+	window    time.Duration
+	cacheSize int
+
+	mu       sync.Mutex
+	seen     map[peer.ID]*seenIds
+	rejected map[error]uint64
+	// 上次清理不再发消息的节点的时间
+	pruned time.Time
+}
+
+func NewMessageGuard(window time.Duration, cacheSize int) *MessageGuard {
+	if window <= 0 {
+		window = DefaultAcceptanceWindow
+	}
+	if cacheSize <= 0 {
+		cacheSize = DefaultSeenCacheSize
+	}
+	return &MessageGuard{
+		window:    window,
+		cacheSize: cacheSize,
+		seen:      make(map[peer.ID]*seenIds),
+		rejected:  make(map[error]uint64),
+	}
+}
+
+// Check 检查{from}发来的消息是否过期或者重放，通过时记住消息的 id。
+// 只能在签名校验通过之后调用，否则别人可以伪造 id 挤掉缓存。
+func (g *MessageGuard) Check(from peer.ID, data *p2p.MessageData) error {
+	return g.check(from, data, time.Now())
+}
+
+func (g *MessageGuard) check(from peer.ID, data *p2p.MessageData, now time.Time) error {
+	ts := time.Unix(data.Timestamp, 0)
+	if ts.Before(now.Add(-g.window)) || ts.After(now.Add(g.window)) {
+		return fmt.Errorf("%w, timestamp = %v", ErrStaleMessage, ts)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	expired := now.Add(-g.window).Unix()
+	if now.Sub(g.pruned) >= g.window {
+		g.prune(expired)
+		g.pruned = now
+	}
+
+	s, ok := g.seen[from]
+	if !ok {
+		s = &seenIds{ids: make(map[string]int64)}
+		g.seen[from] = s
+	}
+	s.expire(expired)
+
+	if _, ok := s.ids[data.Id]; ok {
+		return fmt.Errorf("%w, id = %s", ErrReplayedMessage, data.Id)
+	}
+
+	if len(s.order) >= g.cacheSize {
+		delete(s.ids, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.ids[data.Id] = data.Timestamp
+	s.order = append(s.order, data.Id)
+	return nil
+}
+
+// prune 删除最新的 id 也已经离开时间窗口的节点，它们的消息都会被时间戳校验拦下
+func (g *MessageGuard) prune(expired int64) {
+	for from, s := range g.seen {
+		s.expire(expired)
+		if len(s.order) == 0 {
+			delete(g.seen, from)
+		}
+	}
+}
+
+// expire 从最早收到的开始删除时间戳早于{expired}的 id。
+// 乱序到达的 id 可能晚一点才删除，它们的重放会被时间戳校验拦下。
+func (s *seenIds) expire(expired int64) {
+	for len(s.order) > 0 && s.ids[s.order[0]] < expired {
+		delete(s.ids, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Reject 按原因记录一次拒绝，不认识的错误算作 ErrMalformedMessage
+func (g *MessageGuard) Reject(err error) {
+	reason := ErrMalformedMessage
+	for _, r := range rejectReasons {
+		if errors.Is(err, r) {
+			reason = r
+			break
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rejected[reason]++
+}
+
+// Rejected 返回各种原因被拒绝的消息数
+func (g *MessageGuard) Rejected() map[string]uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	res := make(map[string]uint64, len(g.rejected))
+	for reason, count := range g.rejected {
+		res[reason.Error()] = count
+	}
+	return res
+}
+
+// String 返回拒绝计数的摘要，例如 `message id already seen: 3`
+func (g *MessageGuard) String() string {
+	var items []string
+	for reason, count := range g.Rejected() {
+		items = append(items, fmt.Sprintf("%s: %d", reason, count))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,132 @@
+package signedproto
+
+import (
+	"errors"
+	"fmt"
+	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const (
+	testWindow    = time.Minute
+	testCacheSize = 3
+)
+
+var (
+	alice = peer.ID("alice")
+	bob   = peer.ID("bob")
+)
+
+// testMessage 是{from}在{offset}时发出的消息，offset 相对于测试开始的时间
+type testMessage struct {
+	from   peer.ID
+	id     string
+	offset time.Duration
+	// 检查时的本地时间，相对于测试开始的时间
+	at   time.Duration
+	want error
+}
+
+func TestMessageGuardCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []testMessage
+	}{
+		{
+			name: "stale and future timestamps",
+			messages: []testMessage{
+				{from: alice, id: "1", offset: 0},
+				{from: alice, id: "2", offset: -testWindow - time.Second, want: ErrStaleMessage},
+				{from: alice, id: "3", offset: testWindow + time.Second, want: ErrStaleMessage},
+				{from: alice, id: "4", offset: testWindow - time.Second},
+			},
+		},
+		{
+			name: "replayed ids",
+			messages: []testMessage{
+				{from: alice, id: "1", offset: 0},
+				{from: alice, id: "1", offset: 0, at: time.Second, want: ErrReplayedMessage},
+				// 不同节点的 id 互不影响
+				{from: bob, id: "1", offset: 0},
+				// 离开时间窗口后被时间戳校验拦下
+				{from: alice, id: "1", offset: 0, at: testWindow + time.Second, want: ErrStaleMessage},
+			},
+		},
+		{
+			name: "eviction at cache size",
+			messages: []testMessage{
+				{from: alice, id: "1", offset: 1 * time.Second},
+				{from: alice, id: "2", offset: 2 * time.Second},
+				{from: alice, id: "3", offset: 3 * time.Second},
+				// 挤掉最早收到的 1
+				{from: alice, id: "4", offset: 4 * time.Second},
+				// 缓存中的 id 仍然能识别
+				{from: alice, id: "2", offset: 2 * time.Second, want: ErrReplayedMessage},
+				{from: alice, id: "4", offset: 4 * time.Second, want: ErrReplayedMessage},
+				// 比被挤掉的 id 更早的新消息也能通过
+				{from: alice, id: "0", offset: 0},
+			},
+		},
+		{
+			name: "eviction within the same second",
+			messages: []testMessage{
+				{from: alice, id: "1", offset: 0},
+				{from: alice, id: "2", offset: 0},
+				{from: alice, id: "3", offset: 0},
+				// 挤掉 1 之后，同一秒内的新消息不能被拒绝
+				{from: alice, id: "4", offset: 0},
+				{from: alice, id: "5", offset: 0},
+				{from: alice, id: "4", offset: 0, want: ErrReplayedMessage},
+			},
+		},
+		{
+			name: "out of order arrival",
+			messages: []testMessage{
+				// 按收到的先后挤掉 3，而不是时间戳最早的 2
+				{from: alice, id: "3", offset: 3 * time.Second},
+				{from: alice, id: "2", offset: 2 * time.Second},
+				{from: alice, id: "5", offset: 5 * time.Second},
+				{from: alice, id: "6", offset: 6 * time.Second},
+				{from: alice, id: "2", offset: 2 * time.Second, want: ErrReplayedMessage},
+				{from: alice, id: "4", offset: 4 * time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewMessageGuard(testWindow, testCacheSize)
+			start := time.Now().Truncate(time.Second)
+			for i, m := range tt.messages {
+				data := &p2p.MessageData{Id: m.id, Timestamp: start.Add(m.offset).Unix()}
+				err := g.check(m.from, data, start.Add(m.at))
+				msg := fmt.Sprintf("message %d (%s from %s)", i, m.id, m.from)
+				if m.want == nil {
+					assert.NoError(t, err, msg)
+				} else {
+					assert.True(t, errors.Is(err, m.want), "%s: err = %v", msg, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageGuardPrunesIdleAuthors(t *testing.T) {
+	g := NewMessageGuard(testWindow, testCacheSize)
+	start := time.Now().Truncate(time.Second)
+
+	for i := 0; i < 10; i++ {
+		pid := peer.ID(fmt.Sprintf("peer-%d", i))
+		assert.NoError(t, g.check(pid, &p2p.MessageData{Id: "1", Timestamp: start.Unix()}, start))
+	}
+	assert.Len(t, g.seen, 10)
+
+	// 时间窗口过去之后，只留下还在发消息的节点
+	later := start.Add(2 * testWindow)
+	assert.NoError(t, g.check(alice, &p2p.MessageData{Id: "1", Timestamp: later.Unix()}, later))
+	assert.Len(t, g.seen, 1)
+	assert.Contains(t, g.seen, alice)
+}
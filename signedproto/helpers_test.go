@@ -0,0 +1,38 @@
+package signedproto
+
+import (
+	"context"
+	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type (
+	echoSpec    = Spec[*p2p.EchoRequest, *p2p.EchoResponse]
+	echoHandler = Handler[*p2p.EchoRequest, *p2p.EchoResponse]
+)
+
+// testNode 是只监听本地地址、注册了一个测试协议的节点
+type testNode struct {
+	host  host.Host
+	svc   *Service
+	proto *Protocol[*p2p.EchoRequest, *p2p.EchoResponse]
+}
+
+func newNode(t *testing.T, spec echoSpec, handler echoHandler) *testNode {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+
+	n := &testNode{host: h, svc: NewService(h, nil)}
+	n.proto = Register(n.svc, spec, handler)
+	return n
+}
+
+func connect(t *testing.T, a, b *testNode) {
+	err := a.host.Connect(context.Background(), peer.AddrInfo{ID: b.host.ID(), Addrs: b.host.Addrs()})
+	assert.NoError(t, err)
+}
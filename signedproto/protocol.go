@@ -0,0 +1,164 @@
+package signedproto
+
+import (
+	"context"
+	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"log"
+)
+
+// Spec 描述一个签名的 protobuf 协议。
+// ID 是单流协议，应答写回请求所在的流；RequestID/ResponseID 是旧的双流协议，
+// 两种都配置时通过 multistream 协商，新旧节点可以互通。
+// 没有 NewResponse 的协议是单向的，只能用 Notify 和 SendOn 发送。
+type Spec[Req, Resp Message] struct {
+	ID         protocol.ID
+	RequestID  protocol.ID
+	ResponseID protocol.ID
+
+	// 用{data}创建消息，{data}为空时创建用来解码的空消息
+	NewRequest  func(data *p2p.MessageData) Req
+	NewResponse func(data *p2p.MessageData) Resp
+}
+
+// Handler 处理{from}发来的已经校验过的请求，填写应答的内容，
+// 应答的 MessageData 和签名由框架负责。单向协议的{resp}是零值。
+// 返回错误时不发送应答。gossip 消息的{from}是消息的作者，应答被丢弃。
+type Handler[Req, Resp Message] func(from peer.ID, req Req, resp Resp) error
+
+// Protocol 是注册到 Service 上的协议
+type Protocol[Req, Resp Message] struct {
+	svc     *Service
+	spec    Spec[Req, Resp]
+	handler Handler[Req, Resp]
+}
+
+// Register 在{svc}的 host 上注册协议的处理函数
+func Register[Req, Resp Message](svc *Service, spec Spec[Req, Resp], handler Handler[Req, Resp]) *Protocol[Req, Resp] {
+	p := &Protocol[Req, Resp]{svc: svc, spec: spec, handler: handler}
+	if len(spec.ID) > 0 {
+		svc.host.SetStreamHandler(spec.ID, p.onStream)
+	}
+	if len(spec.RequestID) > 0 {
+		svc.host.SetStreamHandler(spec.RequestID, p.onRequest)
+	}
+	if len(spec.ResponseID) > 0 && spec.NewResponse != nil {
+		svc.host.SetStreamHandler(spec.ResponseID, p.onResponse)
+	}
+	return p
+}
+
+// NewRequest 创建一个填好 MessageData 的请求，请求的 id 是新的 uuid
+func (p *Protocol[Req, Resp]) NewRequest() Req {
+	return p.spec.NewRequest(p.svc.NewMessageData(uuid.New().String(), false))
+}
+
+// Request 签名并发出请求，应答通过返回的 Future 得到
+func (p *Protocol[Req, Resp]) Request(ctx context.Context, peerId peer.ID, req Req) *Future {
+	return p.svc.request(ctx, peerId, p.spec.ID, p.spec.RequestID, req, p.spec.NewResponse(nil))
+}
+
+// Notify 签名并用旧的双流协议发出请求，不等待应答
+func (p *Protocol[Req, Resp]) Notify(ctx context.Context, peerId peer.ID, req Req) error {
+	if err := p.svc.sign(req); err != nil {
+		return err
+	}
+	return p.svc.SendProtoMessage(ctx, peerId, p.spec.RequestID, req)
+}
+
+// SendOn 签名请求并写到已经打开的流上，例如通过 relay 建立的流
+func (p *Protocol[Req, Resp]) SendOn(s network.Stream, req Req) error {
+	if err := p.svc.sign(req); err != nil {
+		return err
+	}
+	return writeMessage(s, req)
+}
+
+// onStream 处理单流协议的请求，应答写回同一个流
+func (p *Protocol[Req, Resp]) onStream(s network.Stream) {
+	req := p.spec.NewRequest(nil)
+	if err := readRequest(s, req); err != nil {
+		log.Printf("Read %s request from %s failed, err = %v", p.spec.ID, s.Conn().RemotePeer(), err)
+		return
+	}
+	if isGossip(req) {
+		s.Close()
+		p.onGossip(s.Conn().RemotePeer(), req)
+		return
+	}
+
+	resp, ok := p.handle(s.Conn().RemotePeer(), req)
+	if !ok {
+		s.Reset()
+		return
+	}
+	if p.spec.NewResponse == nil {
+		s.Close()
+		return
+	}
+	if err := writeResponse(s, resp); err != nil {
+		log.Printf("Send %s response to %s failed, err = %v", p.spec.ID, s.Conn().RemotePeer(), err)
+	}
+}
+
+// onRequest 处理旧的双流协议的请求，应答从新开的流发回
+func (p *Protocol[Req, Resp]) onRequest(s network.Stream) {
+	from := s.Conn().RemotePeer()
+	req := p.spec.NewRequest(nil)
+	if err := readMessage(s, req); err != nil {
+		log.Printf("Read %s request from %s failed, err = %v", p.spec.RequestID, from, err)
+		return
+	}
+	if isGossip(req) {
+		p.onGossip(from, req)
+		return
+	}
+
+	resp, ok := p.handle(from, req)
+	if !ok || p.spec.NewResponse == nil || len(p.spec.ResponseID) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeout)
+	defer cancel()
+	if err := p.svc.SendProtoMessage(ctx, from, p.spec.ResponseID, resp); err != nil {
+		log.Printf("Send %s response to %s failed, err = %v", p.spec.ResponseID, from, err)
+	}
+}
+
+// onResponse 处理旧的双流协议的应答
+func (p *Protocol[Req, Resp]) onResponse(s network.Stream) {
+	resp := p.spec.NewResponse(nil)
+	if err := readMessage(s, resp); err != nil {
+		log.Printf("Read %s response from %s failed, err = %v", p.spec.ResponseID, s.Conn().RemotePeer(), err)
+		return
+	}
+	p.svc.resolveResponse(s.Conn().RemotePeer(), resp)
+}
+
+// handle 校验请求并交给处理函数，返回签名后的应答
+func (p *Protocol[Req, Resp]) handle(from peer.ID, req Req) (Resp, bool) {
+	var resp Resp
+	if !p.svc.AuthenticateMessage(req, req.GetMessageData()) {
+		return resp, false
+	}
+
+	if p.spec.NewResponse != nil {
+		resp = p.spec.NewResponse(p.svc.NewMessageData(req.GetMessageData().Id, false))
+	}
+	if err := p.handler(from, req, resp); err != nil {
+		log.Printf("Handle request from %s failed, err = %v", from, err)
+		return resp, false
+	}
+	if p.spec.NewResponse == nil {
+		return resp, true
+	}
+
+	if err := p.svc.sign(resp); err != nil {
+		log.Printf("Sign response to %s failed, err = %v", from, err)
+		return resp, false
+	}
+	return resp, true
+}
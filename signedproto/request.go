@@ -0,0 +1,157 @@
+package signedproto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gogo/protobuf/proto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"sync"
+	"time"
+)
+
+// 没有指定超时时间的请求，最多等待这么久
+const DefaultRequestTimeout = 10 * time.Second
+
+// 请求失败的原因，用 errors.Is 判断
+var (
+	ErrTimeout     = errors.New("request timeout")
+	ErrUnreachable = errors.New("peer unreachable")
+	ErrAuthFailed  = errors.New("authentication failed")
+)
+
+// RequestError 是失败的请求
+type RequestError struct {
+	Id   string
+	Peer peer.ID
+	// 失败的原因：ErrTimeout、ErrUnreachable 或者 ErrAuthFailed
+	Kind error
+	Err  error
+}
+
+func (e *RequestError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("request(`%s`) to %s: %v", e.Id, e.Peer, e.Kind)
+	}
+	return fmt.Sprintf("request(`%s`) to %s: %v, err = %v", e.Id, e.Peer, e.Kind, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Kind
+}
+
+// Response 是一次请求的结果，RTT 是从发出请求到收到应答的时间
+type Response struct {
+	Id      string
+	Peer    peer.ID
+	Message proto.Message
+	RTT     time.Duration
+	Err     error
+}
+
+// Future 是已经发出、等待应答的请求
+type Future struct {
+	Id   string
+	Peer peer.ID
+	ch   chan *Response
+}
+
+// Done 返回应答的管道，请求结束（成功、失败或者超时）时收到一次结果
+func (f *Future) Done() <-chan *Response {
+	return f.ch
+}
+
+// Wait 等待请求结束
+func (f *Future) Wait() (*Response, error) {
+	res := <-f.ch
+	return res, res.Err
+}
+
+// pendingRequest 是等待应答的请求
+type pendingRequest struct {
+	future *Future
+	sent   time.Time
+	// 停止超时计时
+	stop func()
+}
+
+// RequestTracker 按 MessageData.Id 关联请求和应答，超时的请求自动删除
+type RequestTracker struct {
+	mu      sync.Mutex
+	pending map[string]*pendingRequest
+}
+
+func NewRequestTracker() *RequestTracker {
+	return &RequestTracker{pending: make(map[string]*pendingRequest)}
+}
+
+// Add 登记一个发往{peerId}的请求。{ctx}结束时请求以 ErrTimeout 失败，
+// {ctx}没有截止时间时使用 DefaultRequestTimeout。
+func (t *RequestTracker) Add(ctx context.Context, id string, peerId peer.ID) *Future {
+	future := &Future{Id: id, Peer: peerId, ch: make(chan *Response, 1)}
+
+	var cancel context.CancelFunc = func() {}
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+	}
+	pending := &pendingRequest{future: future, sent: time.Now()}
+
+	// 超时回调在另一个协程中执行，需要先拿到锁，所以能看到 stop
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[id] = pending
+	stop := context.AfterFunc(ctx, func() {
+		t.finish(id, "", nil, &RequestError{Id: id, Peer: peerId, Kind: ErrTimeout, Err: ctx.Err()})
+	})
+	pending.stop = func() {
+		stop()
+		cancel()
+	}
+	return future
+}
+
+// Resolve 把{from}发来的应答交给对应的请求，找不到请求时返回 false
+func (t *RequestTracker) Resolve(id string, from peer.ID, message proto.Message) bool {
+	return t.finish(id, from, message, nil)
+}
+
+// Fail 以{kind}结束请求，{from}为空时不检查应答的来源
+func (t *RequestTracker) Fail(id string, from peer.ID, kind error, err error) bool {
+	t.mu.Lock()
+	pending, ok := t.pending[id]
+	t.mu.Unlock()
+	if !ok {
+		return false
+	}
+	return t.finish(id, from, nil, &RequestError{Id: id, Peer: pending.future.Peer, Kind: kind, Err: err})
+}
+
+// finish 结束请求并删除，{from}不是请求的对方时忽略，避免别人伪造应答
+func (t *RequestTracker) finish(id string, from peer.ID, message proto.Message, err error) bool {
+	t.mu.Lock()
+	pending, ok := t.pending[id]
+	if !ok || (len(from) > 0 && from != pending.future.Peer) {
+		t.mu.Unlock()
+		return false
+	}
+	delete(t.pending, id)
+	stop := pending.stop
+	t.mu.Unlock()
+
+	stop()
+	pending.future.ch <- &Response{
+		Id:      id,
+		Peer:    pending.future.Peer,
+		Message: message,
+		RTT:     time.Since(pending.sent),
+		Err:     err,
+	}
+	return true
+}
+
+// Pending 返回等待应答的请求数
+func (t *RequestTracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
@@ -0,0 +1,153 @@
+package signedproto
+
+import (
+	"context"
+	"errors"
+	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const (
+	echoProtocol         = "/test/echo/2.0.0"
+	echoRequestProtocol  = "/test/echo/req/1.0.0"
+	echoResponseProtocol = "/test/echo/resp/1.0.0"
+)
+
+// newEchoNode 创建注册了 echo 协议的节点，为空的协议 id 不注册，{handler}为 nil 时原样返回请求
+func newEchoNode(t *testing.T, single, request, response protocol.ID, handler echoHandler) *testNode {
+	if handler == nil {
+		handler = func(_ peer.ID, req *p2p.EchoRequest, resp *p2p.EchoResponse) error {
+			resp.Message = req.Message
+			return nil
+		}
+	}
+	return newNode(t, echoSpec{
+		ID:         single,
+		RequestID:  request,
+		ResponseID: response,
+		NewRequest: func(data *p2p.MessageData) *p2p.EchoRequest {
+			return &p2p.EchoRequest{MessageData: data}
+		},
+		NewResponse: func(data *p2p.MessageData) *p2p.EchoResponse {
+			return &p2p.EchoResponse{MessageData: data}
+		},
+	}, handler)
+}
+
+func newEchoNodes(t *testing.T, count int) []*testNode {
+	var nodes []*testNode
+	for i := 0; i < count; i++ {
+		nodes = append(nodes, newEchoNode(t, echoProtocol, echoRequestProtocol, echoResponseProtocol, nil))
+	}
+	return nodes
+}
+
+func echo(ctx context.Context, from, to *testNode, message string) (*Response, error) {
+	req := from.proto.NewRequest()
+	req.Message = message
+	return from.proto.Request(ctx, to.host.ID(), req).Wait()
+}
+
+func TestRequestToDeadPeerTimesOut(t *testing.T) {
+	// 对方收到请求之后不再应答
+	block := make(chan struct{})
+	defer close(block)
+	a := newEchoNodes(t, 1)[0]
+	dead := newEchoNode(t, echoProtocol, "", "", func(peer.ID, *p2p.EchoRequest, *p2p.EchoResponse) error {
+		<-block
+		return nil
+	})
+	connect(t, a, dead)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := echo(ctx, a, dead, "hello")
+	assert.True(t, errors.Is(err, ErrTimeout), "err = %v", err)
+	assert.Equal(t, 0, a.svc.Requests().Pending())
+
+	// 已经关闭的节点
+	closed := newEchoNodes(t, 1)[0]
+	connect(t, a, closed)
+	assert.NoError(t, closed.host.Close())
+	_, err = echo(context.Background(), a, closed, "hello")
+	assert.True(t, errors.Is(err, ErrUnreachable), "err = %v", err)
+	assert.Equal(t, 0, a.svc.Requests().Pending())
+}
+
+func TestRequestTrackerTimeout(t *testing.T) {
+	tracker := NewRequestTracker()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	future := tracker.Add(ctx, "1", peer.ID("bob"))
+	assert.Equal(t, 1, tracker.Pending())
+	_, err := future.Wait()
+	assert.True(t, errors.Is(err, ErrTimeout))
+	assert.Equal(t, 0, tracker.Pending())
+
+	// 超时之后的应答找不到请求
+	assert.False(t, tracker.Resolve("1", peer.ID("bob"), &p2p.EchoResponse{}))
+}
+
+func TestResponseFromWrongPeerIgnored(t *testing.T) {
+	// b 收到请求之后等待，让 c 先发出伪造的应答
+	release := make(chan struct{})
+	nodes := newEchoNodes(t, 2)
+	a, c := nodes[0], nodes[1]
+	b := newEchoNode(t, "", echoRequestProtocol, echoResponseProtocol,
+		func(_ peer.ID, req *p2p.EchoRequest, resp *p2p.EchoResponse) error {
+			<-release
+			resp.Message = req.Message
+			return nil
+		})
+	connect(t, a, b)
+	connect(t, c, a)
+
+	req := a.proto.NewRequest()
+	req.Message = "hello"
+	future := a.proto.Request(context.Background(), b.host.ID(), req)
+
+	// c 用自己的签名冒充 b 的应答，签名有效但是来源不对
+	forged := &p2p.EchoResponse{MessageData: c.svc.NewMessageData(req.MessageData.Id, false), Message: "forged"}
+	assert.NoError(t, c.svc.sign(forged))
+	assert.NoError(t, c.svc.SendProtoMessage(context.Background(), a.host.ID(), echoResponseProtocol, forged))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 1, a.svc.Requests().Pending())
+
+	close(release)
+	res, err := future.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, b.host.ID(), res.Peer)
+	assert.Equal(t, "hello", res.Message.(*p2p.EchoResponse).Message)
+}
+
+func TestRequestInteroperatesWithLegacyProtocol(t *testing.T) {
+	// legacy 只支持旧的双流协议，current 两种都支持
+	legacy := newEchoNode(t, "", echoRequestProtocol, echoResponseProtocol, nil)
+	current := newEchoNodes(t, 2)
+	connect(t, current[0], legacy)
+	connect(t, current[0], current[1])
+
+	tests := []struct {
+		name     string
+		from, to *testNode
+	}{
+		{"current to legacy", current[0], legacy},
+		{"legacy to current", legacy, current[0]},
+		{"current to current", current[0], current[1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			res, err := echo(ctx, tt.from, tt.to, tt.name)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.name, res.Message.(*p2p.EchoResponse).Message)
+			assert.Equal(t, 0, tt.from.svc.Requests().Pending())
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package signedproto
+
+import (
+	"context"
+	"fmt"
+	p2p "github.com/czh0526/libp2p-examples/multipro/proto"
+	ggio "github.com/gogo/protobuf/io"
+	"github.com/gogo/protobuf/proto"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	ClientVersion = "go-p2p-node/0.0.1"
+	// 单流协议中一条消息的最大长度
+	maxMessageSize = 1 << 20
+)
+
+// Message 是带有 MessageData 的请求或者应答
+type Message interface {
+	proto.Message
+	GetMessageData() *p2p.MessageData
+}
+
+// Service 负责消息的签名、校验和发送，以及请求和应答的关联，
+// 同一个 host 上的所有协议共用一个 Service。
+type Service struct {
+	host host.Host
+
+	// 所有协议共用，请求的 id 是 uuid，不会冲突
+	requests *RequestTracker
+	// 拒绝过期和重放的消息
+	guard *MessageGuard
+
+	// 转发过的 gossip 消息，以及最多转发几次
+	gossiped   *gossipCache
+	gossipHops uint32
+}
+
+func NewService(host host.Host, guard *MessageGuard) *Service {
+	if guard == nil {
+		guard = NewMessageGuard(DefaultAcceptanceWindow, DefaultSeenCacheSize)
+	}
+	return &Service{
+		host:       host,
+		requests:   NewRequestTracker(),
+		guard:      guard,
+		gossiped:   newGossipCache(DefaultGossipCacheSize),
+		gossipHops: DefaultGossipHops,
+	}
+}
+
+// Guard 返回消息检查器，可以查看各种原因被拒绝的消息数
+func (s *Service) Guard() *MessageGuard {
+	return s.guard
+}
+
+// Requests 返回等待应答的请求
+func (s *Service) Requests() *RequestTracker {
+	return s.requests
+}
+
+func (s *Service) NewMessageData(messageId string, gossip bool) *p2p.MessageData {
+	nodePubKey, err := crypto.MarshalPublicKey(s.host.Peerstore().PubKey(s.host.ID()))
+	if err != nil {
+		panic("Failed to marshal public key for sender from local peer store.")
+	}
+
+	return &p2p.MessageData{
+		ClientVersion: ClientVersion,
+		NodeId:        s.host.ID().String(),
+		NodePubKey:    nodePubKey,
+		Timestamp:     time.Now().Unix(),
+		Id:            messageId,
+		Gossip:        gossip,
+	}
+}
+
+func (s *Service) SignProtoMessage(message proto.Message) ([]byte, error) {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	key := s.host.Peerstore().PrivKey(s.host.ID())
+	return key.Sign(data)
+}
+
+// sign 签名消息，签名写入 MessageData.Sign，签名不包括 Hops
+func (s *Service) sign(message Message) error {
+	data := message.GetMessageData()
+	hops := data.Hops
+	data.Sign, data.Hops = nil, 0
+	signature, err := s.SignProtoMessage(message)
+	data.Hops = hops
+	if err != nil {
+		return fmt.Errorf("sign message failed, err = %v", err)
+	}
+	data.Sign = signature
+	return nil
+}
+
+// AuthenticateMessage 校验消息的签名、时间戳和 id，拒绝的原因记入日志并计数
+func (s *Service) AuthenticateMessage(message proto.Message, data *p2p.MessageData) bool {
+	err := s.authenticate(message, data)
+	if err != nil {
+		log.Printf("Reject message, err = %v", err)
+		s.guard.Reject(err)
+		return false
+	}
+	return true
+}
+
+func (s *Service) authenticate(message proto.Message, data *p2p.MessageData) error {
+	author, err := verifyMessage(message, data)
+	if err != nil {
+		return err
+	}
+	return s.guard.Check(author, data)
+}
+
+// verifyMessage 校验消息的签名，返回消息的作者。签名不包括 Sign 和 Hops。
+func verifyMessage(message proto.Message, data *p2p.MessageData) (peer.ID, error) {
+	if data == nil {
+		return "", fmt.Errorf("%w: no message data", ErrMalformedMessage)
+	}
+
+	sign, hops := data.Sign, data.Hops
+	data.Sign, data.Hops = nil, 0
+	bin, err := proto.Marshal(message)
+	data.Sign, data.Hops = sign, hops
+	if err != nil {
+		return "", fmt.Errorf("%w, marshal message failed, err = %v", ErrMalformedMessage, err)
+	}
+
+	peerId, err := peer.Decode(data.NodeId)
+	if err != nil {
+		return "", fmt.Errorf("%w, decode peer id failed, err = %v", ErrMalformedMessage, err)
+	}
+
+	if err = verifyData(bin, sign, peerId, data.NodePubKey); err != nil {
+		return "", err
+	}
+	return peerId, nil
+}
+
+func verifyData(data []byte, signature []byte, peerId peer.ID, pubKeyData []byte) error {
+	key, err := crypto.UnmarshalPublicKey(pubKeyData)
+	if err != nil {
+		return fmt.Errorf("%w, unmarshal public key failed, err = %v", ErrMalformedMessage, err)
+	}
+
+	idFromKey, err := peer.IDFromPublicKey(key)
+	if err != nil {
+		return fmt.Errorf("%w, extract peer id from public key failed, err = %v", ErrInvalidPeerId, err)
+	}
+
+	if idFromKey != peerId {
+		return fmt.Errorf("%w, peer id = %s", ErrInvalidPeerId, peerId)
+	}
+
+	res, err := key.Verify(data, signature)
+	if err != nil {
+		return fmt.Errorf("%w, err = %v", ErrInvalidSignature, err)
+	}
+	if !res {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// SendProtoMessage 新开一个{p}协议的流发出消息，消息不带长度前缀
+func (s *Service) SendProtoMessage(ctx context.Context, id peer.ID, p protocol.ID, data proto.Message) error {
+	stream, err := s.host.NewStream(ctx, id, p)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	return writeMessage(stream, data)
+}
+
+// writeMessage 向旧的双流协议写出消息，对方读到流结束为止
+func writeMessage(stream network.Stream, data proto.Message) error {
+	err := ggio.NewFullWriter(stream).WriteMsg(data)
+	if err != nil {
+		stream.Reset()
+		return err
+	}
+	return nil
+}
+
+// readMessage 从旧的双流协议读出消息
+func readMessage(stream network.Stream, data proto.Message) error {
+	stream.SetReadDeadline(time.Now().Add(DefaultRequestTimeout))
+	buf, err := io.ReadAll(io.LimitReader(stream, maxMessageSize))
+	if err != nil {
+		stream.Reset()
+		return err
+	}
+	stream.Close()
+	return proto.Unmarshal(buf, data)
+}
+
+// request 登记并发出请求，发送失败时请求立即以 ErrUnreachable 结束。
+// 通过 multistream 协商协议：对方支持{single}时，应答{resp}从同一个流读回；
+// 旧节点只支持{legacy}，应答从对方新开的流上到达。
+func (s *Service) request(ctx context.Context, peerId peer.ID, single, legacy protocol.ID,
+	req Message, resp Message) *Future {
+	data := req.GetMessageData()
+	future := s.requests.Add(ctx, data.Id, peerId)
+
+	if err := s.sign(req); err != nil {
+		log.Printf("%s: sign request failed: err = %v", s.host.ID(), err)
+		s.requests.Fail(data.Id, "", ErrUnreachable, err)
+		return future
+	}
+
+	var protocols []protocol.ID
+	for _, p := range []protocol.ID{single, legacy} {
+		if len(p) > 0 {
+			protocols = append(protocols, p)
+		}
+	}
+	stream, err := s.host.NewStream(ctx, peerId, protocols...)
+	if err != nil {
+		s.requests.Fail(data.Id, "", ErrUnreachable, err)
+		return future
+	}
+
+	if stream.Protocol() != single {
+		defer stream.Close()
+		if err = writeMessage(stream, req); err != nil {
+			s.requests.Fail(data.Id, "", ErrUnreachable, err)
+		}
+		return future
+	}
+
+	go s.roundTrip(ctx, stream, req, resp)
+	return future
+}
+
+// roundTrip 在同一个流上写出请求并读回应答，消息都带有长度前缀
+func (s *Service) roundTrip(ctx context.Context, stream network.Stream, req Message, resp Message) {
+	id := req.GetMessageData().Id
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultRequestTimeout)
+	}
+	stream.SetDeadline(deadline)
+
+	err := ggio.NewDelimitedWriter(stream).WriteMsg(req)
+	if err == nil {
+		err = stream.CloseWrite()
+	}
+	if err == nil {
+		err = ggio.NewDelimitedReader(stream, maxMessageSize).ReadMsg(resp)
+	}
+	if err != nil {
+		stream.Reset()
+		kind := ErrUnreachable
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			kind = ErrTimeout
+		}
+		s.requests.Fail(id, "", kind, err)
+		return
+	}
+	stream.Close()
+
+	s.resolveResponse(stream.Conn().RemotePeer(), resp)
+}
+
+// resolveResponse 校验应答并交给对应的请求，校验失败时请求以 ErrAuthFailed 结束
+func (s *Service) resolveResponse(from peer.ID, resp Message) bool {
+	data := resp.GetMessageData()
+	if !s.AuthenticateMessage(resp, data) {
+		if data != nil {
+			s.requests.Fail(data.Id, from, ErrAuthFailed, nil)
+		}
+		return false
+	}
+	if !s.requests.Resolve(data.Id, from, resp) {
+		log.Printf("Failed to find request for id = %v", data.Id)
+		return false
+	}
+	return true
+}
+
+// readRequest 从单流协议的流中读出请求
+func readRequest(stream network.Stream, req Message) error {
+	stream.SetReadDeadline(time.Now().Add(DefaultRequestTimeout))
+	err := ggio.NewDelimitedReader(stream, maxMessageSize).ReadMsg(req)
+	if err != nil {
+		stream.Reset()
+		return err
+	}
+	return nil
+}
+
+// writeResponse 把应答写回请求所在的流
+func writeResponse(stream network.Stream, resp Message) error {
+	stream.SetWriteDeadline(time.Now().Add(DefaultRequestTimeout))
+	err := ggio.NewDelimitedWriter(stream).WriteMsg(resp)
+	if err != nil {
+		stream.Reset()
+		return err
+	}
+	return stream.Close()
+}